@@ -2,72 +2,87 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"time"
 
-	"github.com/golang-jwt/jwt"
-	"google.golang.org/grpc"
+	"github.com/sgsoul/internal/auth"
+	"github.com/sgsoul/internal/core"
 	pb "github.com/sgsoul/internal/proto"
+	"github.com/sgsoul/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
-var jwtKey = []byte("my_secret_key")
-
-type server struct {
-	pb.UnimplementedAuthServiceServer
-}
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	flag.Parse()
 
-type Claims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
-	jwt.StandardClaims
-}
+	cfg := core.New(*configPath)
+	if cfg == nil {
+		return
+	}
 
-func (s *server) GenerateJWT(ctx context.Context, req *pb.GenerateJWTRequest) (*pb.GenerateJWTResponse, error) {
-	expirationTime := time.Now().Add(time.Duration(req.ExpiryMinutes) * time.Minute)
-	claims := &Claims{
-		Username: req.Username,
-		Role:     req.Role,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	keys, err := auth.LoadKeyring(cfg.AuthKeys, cfg.AuthKeysFile, cfg.AuthKeyAlg)
+	if err != nil {
+		fmt.Printf("failed to load signing keys: %v", err)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	// refresh-токены и отзывы хранятся в общей базе
+	db, err := storage.Open(cfg.DSN)
 	if err != nil {
-		return nil, err
+		fmt.Printf("failed to open storage: %v", err)
+		return
 	}
-	return &pb.GenerateJWTResponse{Token: tokenString}, nil
-}
+	defer db.Close()
 
-func (s *server) ValidateJWT(ctx context.Context, req *pb.ValidateJWTRequest) (*pb.ValidateJWTResponse, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(req.Token, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
+	srv := auth.NewServer(keys, db, time.Duration(cfg.RefreshTokenTime)*time.Minute, auth.LoginPolicy{
+		MaxFailures:   cfg.LoginMaxFailures,
+		IPMaxFailures: cfg.LoginIPMaxFailures,
+		Lockout:       time.Duration(cfg.LoginLockout) * time.Minute,
 	})
+	go srv.StartCleanup(context.Background(), time.Hour)
+
+	creds, err := auth.ServerCredentials(cfg.AuthServerTLS)
 	if err != nil {
-		return nil, err
-	}
-	if !token.Valid {
-		return &pb.ValidateJWTResponse{Valid: false}, nil
+		fmt.Printf("failed to load TLS certificates: %v", err)
+		return
 	}
-	return &pb.ValidateJWTResponse{
-		Valid:    true,
-		Username: claims.Username,
-		Role:     claims.Role,
-	}, nil
-}
 
-func main() {
-	lis, err := net.Listen("tcp", ":50051")
+	address := cfg.AuthListen
+	if address == "" {
+		address = ":50051"
+	}
+	lis, err := net.Listen("tcp", address)
 	if err != nil {
 		fmt.Printf("failed to listen: %v", err)
 		return
 	}
-	s := grpc.NewServer()
-	pb.RegisterAuthServiceServer(s, &server{})
-	fmt.Println("Auth Service is running on port :50051")
+	s := grpc.NewServer(grpc.Creds(creds), auth.Interceptors(time.Duration(cfg.AuthHandlerTimeout)*time.Millisecond))
+	pb.RegisterAuthServiceServer(s, srv)
+
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go srv.StartHealthCheck(context.Background(), hs, 10*time.Second)
+	reflection.Register(s)
+
+	if cfg.AuthMetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			if err := http.ListenAndServe(cfg.AuthMetricsListen, mux); err != nil {
+				fmt.Printf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	fmt.Println("Auth Service is running on", address)
 	if err := s.Serve(lis); err != nil {
 		fmt.Printf("failed to serve: %v", err)
 	}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/dump"
+	"github.com/sgsoul/internal/storage"
+)
+
+const usage = `usage: dump [flags] <command> [file]
+
+commands:
+  export [file]   write all comics (and users with -users) as JSON Lines; "-" or no file - stdout
+  import [file]   upsert comics (and users with -users) from JSON Lines or the old database file
+                  format; gzip is detected automatically; "-" or no file - stdin
+
+flags:
+`
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	dsn := flag.String("dsn", "", "database dsn, overrides dsn from config")
+	users := flag.Bool("users", false, "export or import users too")
+	passwords := flag.Bool("passwords", false, "export password hashes of users")
+	compress := flag.Bool("gzip", false, "gzip the export; implied by a .gz file name")
+	workers := flag.Int("workers", 4, "number of records saved in parallel on import")
+	skipInvalid := flag.Bool("skip-invalid", false, "skip records that fail validation instead of stopping the import")
+	quiet := flag.Bool("quiet", false, "don't report progress")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *dsn == "" {
+		*dsn = core.New(*configPath).DSN
+	}
+
+	st, err := storage.Open(*dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer st.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// прогресс идет в stderr, чтобы не смешиваться с выгрузкой в stdout
+	var progress io.Writer = os.Stderr
+	if *quiet {
+		progress = nil
+	}
+
+	path := "-"
+	if flag.NArg() == 2 {
+		path = flag.Arg(1)
+	}
+
+	switch flag.Arg(0) {
+	case "export":
+		err = exportTo(ctx, st, path, dump.ExportOptions{
+			Users:     *users,
+			Passwords: *passwords,
+			Gzip:      *compress || strings.HasSuffix(path, ".gz"),
+			Progress:  progress,
+		})
+	case "import":
+		err = importFrom(ctx, st, path, dump.ImportOptions{
+			Users:       *users,
+			Workers:     *workers,
+			SkipInvalid: *skipInvalid,
+			Progress:    progress,
+		})
+	default:
+		err = fmt.Errorf("unknown command %q", flag.Arg(0))
+	}
+	if err != nil {
+		st.Close()
+		fail(err)
+	}
+}
+
+func exportTo(ctx context.Context, st storage.Storage, path string, opts dump.ExportOptions) error {
+	if path == "-" {
+		_, err := dump.Export(ctx, st, os.Stdout, opts)
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := dump.Export(ctx, st, file, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func importFrom(ctx context.Context, st storage.Storage, path string, opts dump.ImportOptions) error {
+	if path == "-" {
+		_, err := dump.Import(ctx, st, os.Stdin, opts)
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = dump.Import(ctx, st, file, opts)
+	return err
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "dump:", err)
+	os.Exit(1)
+}
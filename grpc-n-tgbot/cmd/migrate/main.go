@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/storage"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up         apply all pending migrations
+  down N     revert the last N migrations
+  goto V     migrate up or down to version V
+  version    print the current schema version
+  force V    set version V without running migrations (clears dirty state)
+
+flags:
+`
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	dsn := flag.String("dsn", "", "database dsn, overrides dsn from config")
+	dryRun := flag.Bool("dry-run", false, "print planned migrations instead of applying them")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *dsn == "" {
+		*dsn = core.New(*configPath).DSN
+	}
+
+	mg, err := storage.NewMigrator(*dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer mg.Close()
+	mg.Out = os.Stdout
+	mg.DryRun = *dryRun
+
+	if err := run(mg, flag.Args()); err != nil {
+		mg.Close()
+		fail(err)
+	}
+}
+
+func run(mg *storage.Migrator, args []string) error {
+	switch args[0] {
+	case "up":
+		return mg.Up()
+	case "down":
+		n, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		return mg.Down(n)
+	case "goto":
+		v, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		if v < 0 {
+			return fmt.Errorf("invalid version %d", v)
+		}
+		return mg.Goto(uint(v))
+	case "version":
+		version, dirty, err := mg.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version %d (latest known %d)", version, mg.Latest())
+		if dirty {
+			fmt.Print(", dirty")
+		}
+		fmt.Println()
+		return nil
+	case "force":
+		v, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		return mg.Force(v)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func intArg(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("%s requires exactly one numeric argument", args[0])
+	}
+	return strconv.Atoi(args[1])
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "migrate:", err)
+	os.Exit(1)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+
+	"github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/images"
 	"github.com/sgsoul/internal/server"
 	"github.com/sgsoul/internal/service"
 	"github.com/sgsoul/internal/service/search"
@@ -12,18 +16,42 @@ import (
 func main() {
 	cfg := core.New("config.yaml")
 
-	db := storage.NewMySQLDB(cfg.DSN)
+	db, err := storage.Open(cfg.DSN)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
 	sr := search.NewSearch(db)
 	cl := xkcd.NewClient(cfg.SourceURL, db)
+	cl.SetImageOverrides(cfg.ImageOverrides)
+
+	store, err := images.NewStore(cfg.ImageDir)
+	if err != nil {
+		panic(err)
+	}
+	mirror := images.NewMirror(store, db, cfg.ThumbWidth)
+	cl.SetImageMirror(mirror)
+
 	src := service.NewService(cfg, db, cl)
 
-	authClient, err := server.NewAuthClient("localhost:50051")
+	authClient, err := server.NewAuthClient(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	go service.StartScheduler(3, 0, cfg.Port)
-	go server.StartServer(cfg, src, sr, authClient)
+	// индекс в памяти нужен, чтобы поиск работал, даже если база упадет до первого запроса
+	go func() {
+		if err := sr.RebuildIndex(context.Background(), cfg.IndexFile); err != nil {
+			log.Error().Err(err).Msg("error building search index")
+		}
+	}()
+
+	if cfg.SchedulerAPIKey == "" {
+		log.Warn().Msg("scheduler_api_key is not set, scheduled updates will be rejected")
+	}
+	go service.StartScheduler(3, 0, cfg.Port, cfg.SchedulerAPIKey)
+	go server.StartServer(cfg, src, sr, authClient, mirror)
 
 	select {}
 }
@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -21,6 +23,36 @@ var (
 	mu         sync.Mutex               // handle concurrent map access
 )
 
+// все чаты входят через /login с адреса бота; чтобы сервер считал неудачные входы каждого чата
+// отдельно, а не всех вместе, бот передает номер чата в заголовке, которому сервер доверяет
+// только с адресов из login_trusted_addrs и, если задан login_client_secret, только вместе с ним
+const (
+	loginClientHeader       = "X-Login-Client"
+	loginClientSecretHeader = "X-Login-Client-Secret"
+)
+
+func sendLoginBlocked(bot *tgbotapi.BotAPI, chatID int64, wait time.Duration) {
+	mu.Lock()
+	delete(userStates, chatID)
+	mu.Unlock()
+	msg := fmt.Sprintf("Too many failed login attempts. Please try again in %s.", wait.Round(time.Second))
+	bot.Send(tgbotapi.NewMessage(chatID, msg))
+}
+
+// sessionExpired забывает токен чата, если сервер ответил на запрос с ним 401: токен истек или отозван
+// и нужно войти заново
+func sessionExpired(bot *tgbotapi.BotAPI, chatID int64, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	mu.Lock()
+	delete(userTokens, chatID)
+	delete(userStates, chatID)
+	mu.Unlock()
+	bot.Send(tgbotapi.NewMessage(chatID, "Your session has expired. Please use /login again."))
+	return true
+}
+
 func main() {
 	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if botToken == "" {
@@ -162,7 +194,17 @@ func handlePassword(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		return
 	}
 
-	resp, err := http.Post("http://localhost:8080/login", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/login", bytes.NewBuffer(jsonData))
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error logging in"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(loginClientHeader, fmt.Sprintf("telegram:%d", message.Chat.ID))
+	if secret := os.Getenv("LOGIN_CLIENT_SECRET"); secret != "" {
+		req.Header.Set(loginClientSecretHeader, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error logging in"))
 		return
@@ -175,9 +217,19 @@ func handlePassword(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		// сервер заблокировал имя пользователя или чат; Retry-After говорит, сколько ждать
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		sendLoginBlocked(bot, message.Chat.ID, max(time.Duration(seconds)*time.Second, time.Second))
+		return
+	case http.StatusUnauthorized:
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Invalid username or password"))
 		return
+	default:
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error logging in, please try again later"))
+		return
 	}
 
 	for _, cookie := range resp.Cookies() {
@@ -243,7 +295,7 @@ func handleSearchQuery(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 
 	query := message.Text
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", "http://localhost:8080/pics?search="+url.QueryEscape(query), nil)
+	req, err := http.NewRequest("GET", "http://localhost:8080/pics?images=local&search="+url.QueryEscape(query), nil)
 	if err != nil {
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error creating request"))
 		return
@@ -257,6 +309,10 @@ func handleSearchQuery(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	}
 	defer resp.Body.Close()
 
+	if sessionExpired(bot, message.Chat.ID, resp) {
+		return
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error reading response"))
@@ -273,16 +329,38 @@ func handleSearchQuery(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		comicURLs = comicURLs[:3]
 	}
 
-	var responseText strings.Builder
-	for _, url := range comicURLs {
-		responseText.WriteString(url + "\n")
+	// картинки скачиваются из зеркала сервера и загружаются в Telegram как фото
+	for _, path := range comicURLs {
+		if err := sendComicImage(bot, message.Chat.ID, path); err != nil {
+			log.Printf("Error sending comic %s: %v", path, err)
+			bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Error loading comic image"))
+		}
 	}
 
-	bot.Send(tgbotapi.NewMessage(message.Chat.ID, responseText.String()))
-
 	if string(body) == "null" || resp.StatusCode != http.StatusOK {
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "The comic doesn't exist yet, please check back later :("))
 		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "or try to enter a query in English :)"))
 		return
 	}
-}
\ No newline at end of file
+}
+
+func sendComicImage(bot *tgbotapi.BotAPI, chatID int64, path string) error {
+	resp, err := http.Get("http://localhost:8080" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: strings.TrimPrefix(path, "/images/"), Bytes: data})
+	_, err = bot.Send(photo)
+	return err
+}
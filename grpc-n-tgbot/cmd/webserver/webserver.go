@@ -6,15 +6,28 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"os"
 )
 
 var templates = template.Must(template.ParseFiles("templates/login.html", "templates/comics.html"))
 
+// все браузеры входят через /login с адреса веб-сервера; чтобы сервер считал неудачные входы каждого
+// браузера отдельно, веб-сервер передает его адрес в заголовке, которому сервер доверяет только
+// с адресов из login_trusted_addrs и, если задан login_client_secret, только вместе с ним
+const (
+	loginClientHeader       = "X-Login-Client"
+	loginClientSecretHeader = "X-Login-Client-Secret"
+)
+
 func main() {
 	http.HandleFunc("/login", handleLogin)
 	http.HandleFunc("/comics", handleComics)
+	// картинки берутся из зеркала сервера, а не с imgs.xkcd.com
+	http.Handle("/images/", imagesProxy())
 
 	fmt.Println("Starting web server on :8081")
 	http.ListenAndServe(":8081", nil)
@@ -42,7 +55,15 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		resp, err := http.Post("http://localhost:8080/login", "application/json", bytes.NewBuffer(jsonData))
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/login", bytes.NewBuffer(jsonData))
+		if err != nil {
+			http.Error(w, "Error creating request", http.StatusInternalServerError)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setLoginClient(req, r)
+
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			http.Error(w, "Error logging in", http.StatusInternalServerError)
 			fmt.Println("Error sending POST request:", err)
@@ -93,7 +114,7 @@ func handleComics(w http.ResponseWriter, r *http.Request) {
 		}
 
 		client := &http.Client{}
-		req, err := http.NewRequest("GET", "http://localhost:8080/pics?search="+url.QueryEscape(query), nil)
+		req, err := http.NewRequest("GET", "http://localhost:8080/pics?images=local&search="+url.QueryEscape(query), nil)
 		if err != nil {
 			http.Error(w, "Error creating request", http.StatusInternalServerError)
 			return
@@ -107,6 +128,10 @@ func handleComics(w http.ResponseWriter, r *http.Request) {
 		}
 		defer resp.Body.Close()
 
+		if sessionExpired(w, r, resp) {
+			return
+		}
+
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			http.Error(w, "Error reading response", http.StatusInternalServerError)
@@ -133,4 +158,31 @@ func handleComics(w http.ResponseWriter, r *http.Request) {
 
 		templates.ExecuteTemplate(w, "comics.html", template.JS(comicURLsJSON))
 	}
-}
\ No newline at end of file
+}
+
+// setLoginClient называет серверу браузер, от имени которого отправлен запрос входа
+func setLoginClient(req, from *http.Request) {
+	ip, _, err := net.SplitHostPort(from.RemoteAddr)
+	if err != nil {
+		ip = from.RemoteAddr
+	}
+	req.Header.Set(loginClientHeader, "web:"+ip)
+	if secret := os.Getenv("LOGIN_CLIENT_SECRET"); secret != "" {
+		req.Header.Set(loginClientSecretHeader, secret)
+	}
+}
+
+// sessionExpired отправляет на страницу входа, если сервер ответил на запрос с токеном 401:
+// токен истек или отозван и нужно войти заново
+func sessionExpired(w http.ResponseWriter, r *http.Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	return true
+}
+
+func imagesProxy() http.Handler {
+	target, _ := url.Parse("http://localhost:8080")
+	return httputil.NewSingleHostReverseProxy(target)
+}
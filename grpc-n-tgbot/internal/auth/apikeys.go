@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyPrefix отличает ключ API от JWT в заголовке Authorization
+const APIKeyPrefix = "xkcd_"
+
+// apiKeyTouchInterval - не чаще этого обновляется время последнего использования ключа,
+// чтобы не писать в базу на каждый запрос
+const apiKeyTouchInterval = time.Minute
+
+// IsAPIKey сообщает, что token - ключ API, а не JWT
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// CreateAPIKey выпускает ключ API. Права ключа не могут выходить за права роли владельца,
+// а сам ключ не сохраняется и возвращается только в ответе
+func (s *Server) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	claims, err := s.requirePermission(ctx, core.PermUsersManage)
+	if err != nil {
+		return nil, err
+	}
+	if req.Username == "" || req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "username and name are required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one scope is required")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if req.ExpiresAt != 0 && req.ExpiresAt <= now.Unix() {
+		return nil, status.Error(codes.InvalidArgument, "expiry is in the past")
+	}
+
+	user, err := s.store.GetUserByUsername(ctx, req.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if user.Disabled {
+		return nil, status.Error(codes.InvalidArgument, "account disabled")
+	}
+	permissions, err := s.permissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(permissions, scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "role %s has no permission %s", user.Role, scope)
+		}
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomString(9)
+	if err != nil {
+		return nil, err
+	}
+	plain := APIKeyPrefix + secret
+	key := core.APIKey{
+		ID:        id,
+		Hash:      hashToken(plain),
+		Username:  user.Username,
+		Name:      req.Name,
+		Prefix:    plain[:len(APIKeyPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if req.ExpiresAt != 0 {
+		key.ExpiresAt = time.Unix(req.ExpiresAt, 0).UTC()
+	}
+	if err := s.store.SaveAPIKey(ctx, key); err != nil {
+		return nil, storageError(err)
+	}
+
+	log.Info().Str("id", key.ID).Str("username", key.Username).Str("by", claims.Username).Strs("scopes", scopes).Msg("API key created")
+	return &pb.CreateAPIKeyResponse{Key: plain, Info: apiKeyInfo(key)}, nil
+}
+
+// ListAPIKeys возвращает ключи без их значений
+func (s *Server) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	if _, err := s.requirePermission(ctx, core.PermUsersManage); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.store.ListAPIKeys(ctx, req.Username)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	res := &pb.ListAPIKeysResponse{}
+	for _, key := range keys {
+		res.Keys = append(res.Keys, apiKeyInfo(key))
+	}
+	return res, nil
+}
+
+// RevokeAPIKey отзывает ключ; запись остается, чтобы было видно, кто и когда им пользовался
+func (s *Server) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	claims, err := s.requirePermission(ctx, core.PermUsersManage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.store.RevokeAPIKey(ctx, req.Id, time.Now().UTC().Truncate(time.Second))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "API key not found or already revoked")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	log.Info().Str("id", req.Id).Str("by", claims.Username).Msg("API key revoked")
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+// ValidateAPIKey проверяет ключ API так же, как ValidateJWT проверяет токен
+func (s *Server) ValidateAPIKey(ctx context.Context, req *pb.ValidateAPIKeyRequest) (*pb.ValidateJWTResponse, error) {
+	claims, err := s.validateAPIKey(ctx, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ValidateJWTResponse{
+		Valid:       true,
+		Username:    claims.Username,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+	}, nil
+}
+
+// validateAPIKey возвращает владельца ключа с правами из scopes ключа, которые есть у его роли сейчас:
+// смена роли или блокировка пользователя сразу действуют и на его ключи
+func (s *Server) validateAPIKey(ctx context.Context, plain string) (*Claims, error) {
+	key, err := s.store.GetAPIKeyByHash(ctx, hashToken(plain))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	now := time.Now().UTC()
+	switch {
+	case !key.RevokedAt.IsZero():
+		return nil, status.Error(codes.Unauthenticated, "API key revoked")
+	case !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt):
+		return nil, status.Error(codes.Unauthenticated, "API key expired")
+	}
+
+	user, err := s.store.GetUserByUsername(ctx, key.Username)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.Disabled) {
+		return nil, status.Error(codes.Unauthenticated, "account disabled")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	permissions, err := s.permissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.store.TouchAPIKey(ctx, key.ID, now.Truncate(time.Second)); err != nil {
+			log.Warn().Err(err).Str("id", key.ID).Msg("error updating API key last use")
+		}
+	}
+
+	claims := &Claims{Username: user.Username, Role: user.Role, Permissions: []string{}}
+	for _, scope := range key.Scopes {
+		if slices.Contains(permissions, scope) {
+			claims.Permissions = append(claims.Permissions, scope)
+		}
+	}
+	return claims, nil
+}
+
+func apiKeyInfo(key core.APIKey) *pb.APIKey {
+	return &pb.APIKey{
+		Id:         key.ID,
+		Username:   key.Username,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt.Unix(),
+		ExpiresAt:  unixTime(key.ExpiresAt),
+		LastUsedAt: unixTime(key.LastUsedAt),
+		RevokedAt:  unixTime(key.RevokedAt),
+	}
+}
+
+// unixTime переводит нулевое время в 0, а не в отрицательное число секунд
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
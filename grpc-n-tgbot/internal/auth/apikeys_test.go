@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/sgsoul/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	s := newTestServer(t)
+	admin := asUser(generate(t, s, "admin", core.RoleAdmin))
+
+	res, err := s.CreateAPIKey(admin, &pb.CreateAPIKeyRequest{Username: "admin", Name: "ci", Scopes: []string{core.PermComicsIngest, core.PermSearchRead, core.PermSearchRead}})
+	require.NoError(t, err)
+	assert.True(t, IsAPIKey(res.Key))
+	assert.True(t, strings.HasPrefix(res.Key, res.Info.Prefix))
+	assert.Equal(t, []string{core.PermComicsIngest, core.PermSearchRead}, res.Info.Scopes)
+	assert.Zero(t, res.Info.ExpiresAt)
+
+	claims, err := s.ValidateAPIKey(context.Background(), &pb.ValidateAPIKeyRequest{Key: res.Key})
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Username)
+	assert.Equal(t, []string{core.PermComicsIngest, core.PermSearchRead}, claims.Permissions)
+
+	tests := []struct {
+		ctx  context.Context
+		req  *pb.CreateAPIKeyRequest
+		code codes.Code
+	}{
+		{context.Background(), &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot", Scopes: []string{core.PermSearchRead}}, codes.Unauthenticated},
+		{asUser(generate(t, s, "user1", core.RoleUser)), &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot", Scopes: []string{core.PermSearchRead}}, codes.PermissionDenied},
+		// права ключа не шире прав роли
+		{admin, &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot", Scopes: []string{core.PermComicsIngest}}, codes.InvalidArgument},
+		{admin, &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot"}, codes.InvalidArgument},
+		{admin, &pb.CreateAPIKeyRequest{Username: "user1", Scopes: []string{core.PermSearchRead}}, codes.InvalidArgument},
+		{admin, &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot", Scopes: []string{core.PermSearchRead}, ExpiresAt: time.Now().Add(-time.Hour).Unix()}, codes.InvalidArgument},
+		{admin, &pb.CreateAPIKeyRequest{Username: "nobody", Name: "bot", Scopes: []string{core.PermSearchRead}}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		_, err := s.CreateAPIKey(tt.ctx, tt.req)
+		assert.Equal(t, tt.code, status.Code(err), tt.req.String())
+	}
+
+	// ключ API принимается там же, где токен
+	list, err := s.ListAPIKeys(asUser(res.Key), &pb.ListAPIKeysRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	manager, err := s.CreateAPIKey(admin, &pb.CreateAPIKeyRequest{Username: "admin", Name: "users", Scopes: []string{core.PermUsersManage}})
+	require.NoError(t, err)
+	list, err = s.ListAPIKeys(asUser(manager.Key), &pb.ListAPIKeysRequest{Username: "admin"})
+	require.NoError(t, err)
+	assert.Len(t, list.Keys, 2)
+	for _, key := range list.Keys {
+		assert.NotZero(t, key.LastUsedAt, key.Name)
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	s := newTestServer(t)
+	st := s.store.(storage.Storage)
+	ctx := context.Background()
+	admin := asUser(generate(t, s, "admin", core.RoleAdmin))
+
+	assert.NoError(t, st.SetUserRole(ctx, "user1", core.RoleModerator))
+	res, err := s.CreateAPIKey(admin, &pb.CreateAPIKeyRequest{Username: "user1", Name: "fixer", Scopes: []string{core.PermComicsUpdate, core.PermSearchRead}})
+	require.NoError(t, err)
+
+	// права ключа пересчитываются по текущей роли
+	assert.NoError(t, st.SetUserRole(ctx, "user1", core.RoleUser))
+	claims, err := s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: res.Key})
+	require.NoError(t, err)
+	assert.Equal(t, []string{core.PermSearchRead}, claims.Permissions)
+
+	assert.NoError(t, st.SetUserDisabled(ctx, "user1", true))
+	_, err = s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: res.Key})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.NoError(t, st.SetUserDisabled(ctx, "user1", false))
+
+	_, err = s.RevokeAPIKey(admin, &pb.RevokeAPIKeyRequest{Id: res.Info.Id})
+	assert.NoError(t, err)
+	_, err = s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: res.Key})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RevokeAPIKey(admin, &pb.RevokeAPIKeyRequest{Id: res.Info.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	expired := APIKeyPrefix + "expired"
+	assert.NoError(t, st.SaveAPIKey(ctx, core.APIKey{ID: "old", Hash: hashToken(expired), Username: "user1", Name: "old",
+		Scopes: []string{core.PermSearchRead}, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}))
+	_, err = s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: expired})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: APIKeyPrefix + "unknown"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// ключи удаленного пользователя не достаются тому, кто снова зарегистрировал его имя
+	res, err = s.CreateAPIKey(admin, &pb.CreateAPIKeyRequest{Username: "user1", Name: "bot", Scopes: []string{core.PermSearchRead}})
+	require.NoError(t, err)
+	assert.NoError(t, st.DeleteUser(ctx, "user1"))
+	_, err = s.Register(ctx, &pb.RegisterRequest{Username: "user1", Password: "password1"})
+	require.NoError(t, err)
+	_, err = s.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: res.Key})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
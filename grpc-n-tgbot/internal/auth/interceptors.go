@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"runtime/debug"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultHandlerTimeout - дедлайн обработки запроса, если клиент не передал более короткий
+const DefaultHandlerTimeout = 10 * time.Second
+
+// метрики публикуются через expvar: число запросов по методу и коду, суммарное время по методу
+var (
+	grpcRequests = expvar.NewMap("auth_grpc_requests")
+	grpcDuration = expvar.NewMap("auth_grpc_duration_ms")
+	grpcInFlight = expvar.NewInt("auth_grpc_in_flight")
+)
+
+// Interceptors собирает цепочку перехватчиков сервиса: журнал и метрики видят итоговый код,
+// в том числе после паники, а обработчик получает дедлайн не длиннее timeout
+func Interceptors(timeout time.Duration) grpc.ServerOption {
+	if timeout <= 0 {
+		timeout = DefaultHandlerTimeout
+	}
+	return grpc.ChainUnaryInterceptor(
+		logInterceptor,
+		metricsInterceptor,
+		statusInterceptor,
+		recoverInterceptor,
+		deadlineInterceptor(timeout),
+	)
+}
+
+// logInterceptor пишет метод, код и длительность каждого запроса; тела запросов с паролями не пишутся
+func logInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	event := log.Info()
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		event = log.Error()
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		event = event.Str("peer", p.Addr.String())
+	}
+	if err != nil {
+		event = event.Str("error", status.Convert(err).Message())
+	}
+	event.Str("method", info.FullMethod).Str("code", code.String()).Dur("duration", time.Since(start)).Msg("grpc request")
+	return resp, err
+}
+
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpcInFlight.Add(1)
+	defer grpcInFlight.Add(-1)
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	grpcRequests.Add(info.FullMethod+" "+status.Code(err).String(), 1)
+	grpcDuration.Add(info.FullMethod, time.Since(start).Milliseconds())
+	return resp, err
+}
+
+// statusInterceptor превращает ошибки без gRPC-статуса в статусы, чтобы клиенту не уходил codes.Unknown
+// с текстом внутренней ошибки
+func statusInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return resp, err
+	}
+
+	var ve *jwt.ValidationError
+	switch {
+	case errors.As(err, &ve), errors.Is(err, ErrUnknownKey), errors.Is(err, ErrRetiredKey):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, core.ErrStorageUnavailable):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+	log.Error().Err(err).Str("method", info.FullMethod).Msg("internal error")
+	return nil, status.Error(codes.Internal, "internal error")
+}
+
+// recoverInterceptor отвечает codes.Internal вместо падения всего сервиса
+func recoverInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Str("method", info.FullMethod).Bytes("stack", debug.Stack()).Msg("panic in grpc handler")
+			resp, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// deadlineInterceptor ограничивает дедлайн запроса сверху и не запускает обработчик, если дедлайн уже истек
+func deadlineInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+	mocks "github.com/sgsoul/internal/auth/mocks"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/Test"}
+
+func failing(err error) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, err
+	}
+}
+
+func TestStatusInterceptor(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&jwt.ValidationError{Errors: jwt.ValidationErrorExpired}, codes.Unauthenticated},
+		{fmt.Errorf("%w %q", ErrUnknownKey, "kid"), codes.Unauthenticated},
+		{fmt.Errorf("%w: connection refused", core.ErrStorageUnavailable), codes.Unavailable},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{status.Error(codes.PermissionDenied, "administration rights required"), codes.PermissionDenied},
+		{errors.New("disk full"), codes.Internal},
+	}
+	for _, tt := range tests {
+		_, err := statusInterceptor(context.Background(), nil, testInfo, failing(tt.err))
+		assert.Equal(t, tt.code, status.Code(err), tt.err.Error())
+	}
+
+	// текст внутренней ошибки клиенту не уходит
+	_, err := statusInterceptor(context.Background(), nil, testInfo, failing(errors.New("disk full")))
+	assert.Equal(t, "internal error", status.Convert(err).Message())
+}
+
+func TestRecoverInterceptor(t *testing.T) {
+	_, err := recoverInterceptor(context.Background(), nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	interceptor := deadlineInterceptor(time.Second)
+
+	_, err := interceptor(context.Background(), nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	// дедлайн клиента короче - он и остается
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = interceptor(ctx, nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, _ := ctx.Deadline()
+		assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), deadline, 100*time.Millisecond)
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	// истекший запрос не обрабатывается
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = interceptor(expired, nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Error("handler called after deadline")
+		return nil, nil
+	})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestMetricsInterceptor(t *testing.T) {
+	count := func(key string) int64 {
+		if v, ok := grpcRequests.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	ok, denied := count(testInfo.FullMethod+" OK"), count(testInfo.FullMethod+" PermissionDenied")
+
+	_, _ = metricsInterceptor(context.Background(), nil, testInfo, failing(nil))
+	_, _ = metricsInterceptor(context.Background(), nil, testInfo, failing(status.Error(codes.PermissionDenied, "")))
+	_, _ = metricsInterceptor(context.Background(), nil, testInfo, failing(status.Error(codes.PermissionDenied, "")))
+
+	assert.Equal(t, ok+1, count(testInfo.FullMethod+" OK"))
+	assert.Equal(t, denied+2, count(testInfo.FullMethod+" PermissionDenied"))
+	assert.Equal(t, int64(0), grpcInFlight.Value())
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	check := func(s *Server) healthpb.HealthCheckResponse_ServingStatus {
+		hs := health.NewServer()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.StartHealthCheck(ctx, hs, time.Hour)
+			close(done)
+		}()
+
+		var res *healthpb.HealthCheckResponse
+		assert.Eventually(t, func() bool {
+			var err error
+			res, err = hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: pb.AuthService_ServiceDesc.ServiceName})
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		<-done
+		return res.GetStatus()
+	}
+
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(newTestServer(t)))
+
+	s := newTestServer(t)
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockStorage.EXPECT().Health(gomock.Any()).Return(core.StorageHealth{Backend: "mysql", Error: "connection refused"}).AnyTimes()
+	s.store = mockStorage
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(s))
+}
@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sgsoul/internal/core"
+)
+
+// minSecretLen - минимальная длина секрета HS256 в байтах
+const minSecretLen = 32
+
+// rsaBits - размер ключей RS256, выпускаемых ротацией
+const rsaBits = 2048
+
+// Алгоритмы подписи; токены асимметричных ключей проверяются без обращения к сервису
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// DefaultAlg - алгоритм ключей, выпускаемых ротацией, если в конфиге не задан другой
+const DefaultAlg = AlgEdDSA
+
+var (
+	ErrNoKeys     = errors.New("no signing keys configured")
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("signing key is retired")
+	ErrManagedKey = errors.New("signing key is managed by config")
+	ErrUnknownAlg = errors.New("unknown signing algorithm")
+)
+
+// Key - ключ подписи токенов, kid попадает в заголовок токена
+type Key struct {
+	ID string `json:"kid"`
+	// Alg - алгоритм подписи, пустой в файлах старых версий означает HS256
+	Alg string `json:"alg,omitempty"`
+	// Secret - секрет HS256 или закрытый ключ в DER PKCS#8
+	Secret    []byte    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+	Retired   bool      `json:"retired"`
+	// Managed - ключ задан в конфиге, через RotateKeys его не вывести
+	Managed bool `json:"-"`
+
+	private crypto.Signer
+}
+
+// Method возвращает метод подписи jwt для алгоритма ключа
+func (k Key) Method() jwt.SigningMethod {
+	switch k.Alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// signingKey - ключ в том виде, в каком его ждет jwt при подписи
+func (k Key) signingKey() interface{} {
+	if k.private != nil {
+		return k.private
+	}
+	return k.Secret
+}
+
+// verifyingKey - ключ в том виде, в каком его ждет jwt при проверке
+func (k Key) verifyingKey() interface{} {
+	if k.private != nil {
+		return k.private.Public()
+	}
+	return k.Secret
+}
+
+// Public возвращает открытый ключ в DER PKIX; у ключей HS256 его нет
+func (k Key) Public() ([]byte, bool) {
+	if k.private == nil {
+		return nil, false
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.private.Public())
+	if err != nil {
+		return nil, false
+	}
+	return der, true
+}
+
+// Keyring хранит ключи от старых к новым: сначала из конфига, затем выпущенные ротацией
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []Key
+	file string
+	alg  string
+}
+
+// LoadKeyring собирает ключи из конфига и файла ротации; если активных ключей нет, выпускает первый.
+// alg - алгоритм ключей, выпускаемых ротацией, пустой - DefaultAlg
+func LoadKeyring(cfg []core.AuthKey, file, alg string) (*Keyring, error) {
+	if alg == "" {
+		alg = DefaultAlg
+	}
+	if !validAlg(alg) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlg, alg)
+	}
+	k := &Keyring{file: file, alg: alg}
+
+	for _, ck := range cfg {
+		key, err := configKey(ck)
+		if err != nil {
+			return nil, err
+		}
+		if err := k.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			var stored []Key
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return nil, fmt.Errorf("keys file %s: %w", file, err)
+			}
+			for _, key := range stored {
+				if err := k.add(key); err != nil {
+					return nil, fmt.Errorf("keys file %s: %w", file, err)
+				}
+			}
+		}
+	}
+
+	if _, err := k.Signing(); err == nil {
+		return k, nil
+	}
+	if file == "" {
+		return nil, ErrNoKeys
+	}
+	if _, err := k.Rotate(nil); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func validAlg(alg string) bool {
+	return alg == AlgHS256 || alg == AlgRS256 || alg == AlgEdDSA
+}
+
+func configKey(ck core.AuthKey) (Key, error) {
+	if ck.ID == "" {
+		return Key{}, errors.New("signing key without kid")
+	}
+
+	alg := ck.Alg
+	if alg == "" {
+		alg = AlgHS256
+	}
+	if !validAlg(alg) {
+		return Key{}, fmt.Errorf("key %q: %w %q", ck.ID, ErrUnknownAlg, alg)
+	}
+	if alg != AlgHS256 {
+		return privateConfigKey(ck, alg)
+	}
+	if ck.PrivateKeyFile != "" {
+		return Key{}, fmt.Errorf("key %q: private_key_file needs alg %s or %s", ck.ID, AlgRS256, AlgEdDSA)
+	}
+
+	secret := ck.Secret
+	if ck.SecretFile != "" {
+		if secret != "" {
+			return Key{}, fmt.Errorf("key %q: both secret and secret_file are set", ck.ID)
+		}
+		data, err := os.ReadFile(ck.SecretFile)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", ck.ID, err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+
+	return Key{ID: ck.ID, Alg: alg, Secret: []byte(secret), Retired: ck.Retired, Managed: true}, nil
+}
+
+// privateConfigKey читает закрытый ключ RS256 или EdDSA из PEM-файла
+func privateConfigKey(ck core.AuthKey, alg string) (Key, error) {
+	if ck.PrivateKeyFile == "" || ck.Secret != "" || ck.SecretFile != "" {
+		return Key{}, fmt.Errorf("key %q: %s key needs private_key_file and no secret", ck.ID, alg)
+	}
+	data, err := os.ReadFile(ck.PrivateKeyFile)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %q: %w", ck.ID, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %q: %s is not a PEM file", ck.ID, ck.PrivateKeyFile)
+	}
+
+	der := block.Bytes
+	if block.Type == "RSA PRIVATE KEY" {
+		rsaKey, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", ck.ID, err)
+		}
+		if der, err = x509.MarshalPKCS8PrivateKey(rsaKey); err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", ck.ID, err)
+		}
+	}
+
+	return Key{ID: ck.ID, Alg: alg, Secret: der, Retired: ck.Retired, Managed: true}, nil
+}
+
+// parsePrivate разбирает закрытый ключ асимметричного алгоритма и проверяет, что он подходит алгоритму
+func parsePrivate(key *Key) error {
+	if key.Alg == "" {
+		key.Alg = AlgHS256
+	}
+	if !validAlg(key.Alg) {
+		return fmt.Errorf("key %q: %w %q", key.ID, ErrUnknownAlg, key.Alg)
+	}
+	if key.Alg == AlgHS256 {
+		if len(key.Secret) < minSecretLen && !key.Retired {
+			return fmt.Errorf("key %q: secret must be at least %d bytes", key.ID, minSecretLen)
+		}
+		return nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(key.Secret)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", key.ID, err)
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.Alg == AlgRS256 {
+			key.private = private
+			return nil
+		}
+	case ed25519.PrivateKey:
+		if key.Alg == AlgEdDSA {
+			key.private = private
+			return nil
+		}
+	}
+	return fmt.Errorf("key %q: private key does not match alg %s", key.ID, key.Alg)
+}
+
+func (k *Keyring) add(key Key) error {
+	if key.ID == "" {
+		return errors.New("signing key without kid")
+	}
+	if err := parsePrivate(&key); err != nil {
+		return err
+	}
+	if _, ok := k.find(key.ID); ok {
+		return fmt.Errorf("duplicate signing key %q", key.ID)
+	}
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+func (k *Keyring) find(kid string) (int, bool) {
+	for i := range k.keys {
+		if k.keys[i].ID == kid {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Signing возвращает самый новый активный ключ
+func (k *Keyring) Signing() (Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !k.keys[i].Retired {
+			return k.keys[i], nil
+		}
+	}
+	return Key{}, ErrNoKeys
+}
+
+// Verifying возвращает ключ для проверки токена с заголовком kid
+func (k *Keyring) Verifying(kid string) (Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	i, ok := k.find(kid)
+	if !ok {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if k.keys[i].Retired {
+		return Key{}, fmt.Errorf("%w %q", ErrRetiredKey, kid)
+	}
+	return k.keys[i], nil
+}
+
+// Keys возвращает все ключи от старых к новым
+func (k *Keyring) Keys() []Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]Key(nil), k.keys...)
+}
+
+// Rotate выпускает новый ключ подписи и выводит ключи retire.
+// Прежние ключи остаются действительными, пока их не выведут, поэтому выданные токены продолжают работать
+func (k *Keyring) Rotate(retire []string) (Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.file == "" {
+		return Key{}, errors.New("keys file is not configured, rotated keys can't be stored")
+	}
+
+	keys := append([]Key(nil), k.keys...)
+	for _, kid := range retire {
+		i, ok := k.find(kid)
+		if !ok {
+			return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+		}
+		if keys[i].Managed {
+			return Key{}, fmt.Errorf("%w: %q", ErrManagedKey, kid)
+		}
+		keys[i].Retired = true
+	}
+
+	key, err := newKey(k.alg)
+	if err != nil {
+		return Key{}, err
+	}
+	keys = append(keys, key)
+
+	if err := saveKeys(k.file, keys); err != nil {
+		return Key{}, err
+	}
+	k.keys = keys
+	return key, nil
+}
+
+func newKey(alg string) (Key, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	key := Key{ID: hex.EncodeToString(id), Alg: alg, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+
+	var private crypto.Signer
+	switch alg {
+	case AlgRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return Key{}, err
+		}
+		private = rsaKey
+	case AlgEdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Key{}, err
+		}
+		private = edKey
+	default:
+		key.Secret = make([]byte, minSecretLen)
+		if _, err := rand.Read(key.Secret); err != nil {
+			return Key{}, err
+		}
+		return key, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return Key{}, err
+	}
+	key.Secret = der
+	key.private = private
+	return key, nil
+}
+
+// saveKeys атомарно записывает ключи, выпущенные ротацией; ключи из конфига в файл не попадают
+func saveKeys(file string, keys []Key) error {
+	stored := []Key{}
+	for _, key := range keys {
+		if !key.Managed {
+			stored = append(stored, key)
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
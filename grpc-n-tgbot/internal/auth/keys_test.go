@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = strings.Repeat("s", minSecretLen)
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	assert.NoError(t, os.WriteFile(secretFile, []byte(testSecret+"\n"), 0o600))
+
+	keys, err := LoadKeyring([]core.AuthKey{
+		{ID: "old", Secret: "short", Retired: true},
+		{ID: "file", SecretFile: secretFile},
+	}, "", AlgHS256)
+	assert.NoError(t, err)
+
+	key, err := keys.Signing()
+	assert.NoError(t, err)
+	assert.Equal(t, "file", key.ID)
+	assert.Equal(t, testSecret, string(key.Secret))
+
+	_, err = keys.Verifying("old")
+	assert.ErrorIs(t, err, ErrRetiredKey)
+	_, err = keys.Verifying("missing")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// без файла ротации выпустить ключ некуда
+	_, err = keys.Rotate(nil)
+	assert.Error(t, err)
+
+	for _, cfg := range [][]core.AuthKey{
+		nil,
+		{{ID: "a", Secret: "short"}},
+		{{Secret: testSecret}},
+		{{ID: "a", Secret: testSecret}, {ID: "a", Secret: testSecret}},
+		{{ID: "a", Secret: testSecret, SecretFile: secretFile}},
+	} {
+		_, err := LoadKeyring(cfg, "", AlgHS256)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+
+	// без ключей в конфиге первый ключ выпускается сам
+	keys, err := LoadKeyring([]core.AuthKey{{ID: "config", Secret: testSecret, Retired: true}}, file, AlgHS256)
+	assert.NoError(t, err)
+	first, err := keys.Signing()
+	assert.NoError(t, err)
+	assert.NotEqual(t, "config", first.ID)
+
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	second, err := keys.Rotate(nil)
+	assert.NoError(t, err)
+	signing, _ := keys.Signing()
+	assert.Equal(t, second.ID, signing.ID)
+	// прежний ключ продолжает принимать токены
+	_, err = keys.Verifying(first.ID)
+	assert.NoError(t, err)
+
+	_, err = keys.Rotate([]string{"config"})
+	assert.ErrorIs(t, err, ErrManagedKey)
+	_, err = keys.Rotate([]string{"missing"})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Len(t, keys.Keys(), 3)
+
+	third, err := keys.Rotate([]string{first.ID})
+	assert.NoError(t, err)
+	_, err = keys.Verifying(first.ID)
+	assert.ErrorIs(t, err, ErrRetiredKey)
+
+	// после перезапуска ключи читаются из файла, ключ конфига туда не попадает
+	reloaded, err := LoadKeyring([]core.AuthKey{{ID: "config", Secret: testSecret, Retired: true}}, file, AlgHS256)
+	assert.NoError(t, err)
+	assert.Len(t, reloaded.Keys(), 4)
+	signing, _ = reloaded.Signing()
+	assert.Equal(t, third.ID, signing.ID)
+	assert.Equal(t, third.Secret, signing.Secret)
+	_, err = reloaded.Verifying(first.ID)
+	assert.ErrorIs(t, err, ErrRetiredKey)
+}
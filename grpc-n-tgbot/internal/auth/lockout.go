@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	DefaultLoginMaxFailures   = 5
+	DefaultLoginIPMaxFailures = 20
+	DefaultLoginLockout       = 15 * time.Minute
+	// maxLoginDelay - предел паузы между попытками до блокировки, maxLoginLockout - предел самой блокировки
+	maxLoginDelay   = 30 * time.Second
+	maxLoginLockout = 24 * time.Hour
+	// loginFailureWindow - счет неудач начинается заново, если их не было дольше этого
+	loginFailureWindow = 24 * time.Hour
+)
+
+// loginLockouts - число блокировок входа с запуска сервиса
+var loginLockouts = expvar.NewInt("auth_login_lockouts")
+
+// LoginPolicy - ограничения на неудачные входы; нулевые поля заменяются значениями по умолчанию
+type LoginPolicy struct {
+	// MaxFailures - неудач подряд на одно имя пользователя до блокировки
+	MaxFailures int
+	// IPMaxFailures - неудач подряд с одного адреса; порог выше, потому что за адресом бывает много людей
+	IPMaxFailures int
+	// Lockout - первая блокировка, каждая следующая неудача удваивает ее
+	Lockout time.Duration
+}
+
+func (p LoginPolicy) withDefaults() LoginPolicy {
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = DefaultLoginMaxFailures
+	}
+	if p.IPMaxFailures <= 0 {
+		p.IPMaxFailures = DefaultLoginIPMaxFailures
+	}
+	if p.Lockout <= 0 {
+		p.Lockout = DefaultLoginLockout
+	}
+	return p
+}
+
+// delay - сколько ждать следующей попытки после failures неудач подряд при пороге limit.
+// До порога пауза растет с секунды вдвое за неудачу, с порога вход блокируется на Lockout и дольше
+func (p LoginPolicy) delay(failures, limit int) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+	d, ceiling, from := time.Second, maxLoginDelay, 2
+	if failures >= limit {
+		d, ceiling, from = p.Lockout, maxLoginLockout, limit
+	}
+	for i := from; i < failures && d < ceiling; i++ {
+		d *= 2
+	}
+	return min(d, ceiling)
+}
+
+// loginSubject - счетчик неудач: по имени пользователя или по адресу клиента
+type loginSubject struct {
+	key   string
+	limit int
+}
+
+func (s *Server) loginSubjects(req *pb.LoginRequest) []loginSubject {
+	subjects := []loginSubject{{key: "user:" + req.Username, limit: s.login.MaxFailures}}
+	if req.Ip != "" {
+		subjects = append(subjects, loginSubject{key: "ip:" + req.Ip, limit: s.login.IPMaxFailures})
+	}
+	return subjects
+}
+
+// checkLoginLock отказывает во входе, пока имя или адрес заблокированы; пароль при этом не проверяется,
+// чтобы подбор во время блокировки ничего не давал
+func (s *Server) checkLoginLock(ctx context.Context, subjects []loginSubject) error {
+	now := time.Now()
+	for _, subject := range subjects {
+		f, err := s.store.GetLoginFailures(ctx, subject.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return storageError(err)
+		}
+		if wait := f.LockedUntil.Sub(now); wait > 0 {
+			return lockedError(wait)
+		}
+	}
+	return nil
+}
+
+// lockedError - ответ на попытку входа во время блокировки; RetryInfo подсказывает клиенту, сколько ждать
+func lockedError(wait time.Duration) error {
+	wait = max(wait.Round(time.Second), time.Second)
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("too many failed login attempts, try again in %s", wait))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// loginFailed считает неудачу по имени и адресу и назначает паузу или блокировку.
+// Пароль уже неверен, поэтому ошибка базы только записывается в лог
+func (s *Server) loginFailed(ctx context.Context, subjects []loginSubject) {
+	now := time.Now().UTC()
+	for _, subject := range subjects {
+		failures, err := s.store.RecordLoginFailure(ctx, subject.key, now, now.Add(-loginFailureWindow))
+		if err != nil {
+			log.Error().Err(err).Str("subject", subject.key).Msg("error recording failed login")
+			continue
+		}
+		d := s.login.delay(failures, subject.limit)
+		if d == 0 {
+			continue
+		}
+		// база может хранить время с точностью до секунды, поэтому округляем вверх
+		until := now.Add(d + time.Second).Truncate(time.Second)
+		if err := s.store.LockLogin(ctx, subject.key, until); err != nil {
+			log.Error().Err(err).Str("subject", subject.key).Msg("error locking login")
+			continue
+		}
+		if failures >= subject.limit {
+			loginLockouts.Add(1)
+			log.Warn().Str("subject", subject.key).Int("failures", failures).Time("until", until).Msg("login locked")
+		}
+	}
+}
+
+// loginSucceeded сбрасывает счетчик имени пользователя. Счетчик адреса не сбрасывается:
+// иначе подбирающий мог бы обнулять его входом в собственную учетную запись
+func (s *Server) loginSucceeded(ctx context.Context, username string) {
+	err := s.store.ClearLoginFailures(ctx, "user:"+username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Str("username", username).Msg("error clearing failed logins")
+	}
+}
+
+// ListLoginLocks возвращает действующие блокировки входа, включая короткие паузы между попытками
+func (s *Server) ListLoginLocks(ctx context.Context, _ *pb.ListLoginLocksRequest) (*pb.ListLoginLocksResponse, error) {
+	if _, err := s.requirePermission(ctx, core.PermUsersManage); err != nil {
+		return nil, err
+	}
+
+	locks, err := s.store.ListLoginLocks(ctx, time.Now().UTC())
+	if err != nil {
+		return nil, storageError(err)
+	}
+	res := &pb.ListLoginLocksResponse{}
+	for _, f := range locks {
+		res.Locks = append(res.Locks, &pb.LoginLock{
+			Subject:      f.Subject,
+			Failures:     int32(f.Failures),
+			LastFailedAt: f.LastFailedAt.Unix(),
+			LockedUntil:  unixTime(f.LockedUntil),
+		})
+	}
+	return res, nil
+}
+
+// UnlockLogin снимает блокировку и обнуляет счетчик неудач пользователя или адреса
+func (s *Server) UnlockLogin(ctx context.Context, req *pb.UnlockLoginRequest) (*pb.UnlockLoginResponse, error) {
+	claims, err := s.requirePermission(ctx, core.PermUsersManage)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject string
+	switch {
+	case req.Username != "" && req.Ip == "":
+		subject = "user:" + req.Username
+	case req.Ip != "" && req.Username == "":
+		subject = "ip:" + req.Ip
+	default:
+		return nil, status.Error(codes.InvalidArgument, "exactly one of username and ip is required")
+	}
+
+	err = s.store.ClearLoginFailures(ctx, subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "no failed logins")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	log.Info().Str("subject", subject).Str("by", claims.Username).Msg("login unlocked")
+	return &pb.UnlockLoginResponse{}, nil
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := LoginPolicy{}.withDefaults()
+
+	tests := []struct {
+		failures, limit int
+		want            time.Duration
+	}{
+		{1, 5, 0},
+		{2, 5, time.Second},
+		{4, 5, 4 * time.Second},
+		{5, 5, 15 * time.Minute},
+		{6, 5, 30 * time.Minute},
+		{50, 5, maxLoginLockout},
+		{15, 20, maxLoginDelay},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.delay(tt.failures, tt.limit), "%d of %d", tt.failures, tt.limit)
+	}
+}
+
+// retryDelay достает из ошибки подсказку, сколько ждать
+func retryDelay(t *testing.T, err error) time.Duration {
+	t.Helper()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err), err)
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	t.Fatal("no RetryInfo in error")
+	return 0
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.login = LoginPolicy{MaxFailures: 3, IPMaxFailures: 5, Lockout: time.Minute}
+	ctx := context.Background()
+	wrong := &pb.LoginRequest{Username: "user1", Password: "wrong", Ip: "10.0.0.1"}
+	// skipDelay снимает паузу между попытками, как будто пользователь подождал
+	skipDelay := func(subject string) {
+		assert.NoError(t, s.store.LockLogin(ctx, subject, time.Time{}))
+	}
+
+	_, err := s.Login(ctx, wrong)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.Login(ctx, wrong)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// после второй неудачи пауза, и верный пароль тоже не принимается
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.LessOrEqual(t, retryDelay(t, err), 2*time.Second)
+
+	skipDelay("user:user1")
+	skipDelay("ip:10.0.0.1")
+	_, err = s.Login(ctx, wrong)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password", Ip: "10.0.0.2"})
+	assert.Greater(t, retryDelay(t, err), 50*time.Second)
+
+	// неизвестные имена считаются так же, и адрес блокируется целиком
+	skipDelay("ip:10.0.0.1")
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "password", Ip: "10.0.0.1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	skipDelay("ip:10.0.0.1")
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "password", Ip: "10.0.0.1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "admin", Password: "adminpassword", Ip: "10.0.0.1"})
+	assert.Greater(t, retryDelay(t, err), 50*time.Second)
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "admin", Password: "adminpassword", Ip: "10.0.0.2"})
+	require.NoError(t, err)
+
+	admin := asUser(generate(t, s, "admin", core.RoleAdmin))
+	locks, err := s.ListLoginLocks(admin, &pb.ListLoginLocksRequest{})
+	require.NoError(t, err)
+	subjects := []string{}
+	for _, lock := range locks.Locks {
+		subjects = append(subjects, lock.Subject)
+	}
+	assert.Contains(t, subjects, "user:user1")
+	assert.Contains(t, subjects, "ip:10.0.0.1")
+
+	_, err = s.UnlockLogin(admin, &pb.UnlockLoginRequest{Username: "user1"})
+	assert.NoError(t, err)
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.NoError(t, err)
+	_, err = s.UnlockLogin(admin, &pb.UnlockLoginRequest{Ip: "10.0.0.1"})
+	assert.NoError(t, err)
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "admin", Password: "adminpassword", Ip: "10.0.0.1"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		ctx  context.Context
+		req  *pb.UnlockLoginRequest
+		code codes.Code
+	}{
+		{asUser(generate(t, s, "user1", core.RoleUser)), &pb.UnlockLoginRequest{Username: "nobody"}, codes.PermissionDenied},
+		{admin, &pb.UnlockLoginRequest{}, codes.InvalidArgument},
+		{admin, &pb.UnlockLoginRequest{Username: "nobody", Ip: "10.0.0.1"}, codes.InvalidArgument},
+		// успешный вход уже сбросил счетчик
+		{admin, &pb.UnlockLoginRequest{Username: "user1"}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		_, err := s.UnlockLogin(tt.ctx, tt.req)
+		assert.Equal(t, tt.code, status.Code(err), tt.req.String())
+	}
+}
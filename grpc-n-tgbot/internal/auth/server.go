@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate mockgen -source=server.go -destination=mocks/mock.go
+
+// DefaultRefreshTTL - срок жизни refresh-токена, если в конфиге не задан другой
+const DefaultRefreshTTL = 30 * 24 * time.Hour
+
+// Storage хранит пользователей, refresh-токены, отзывы токенов, ключи API и неудачные входы
+type Storage interface {
+	GetUserByUsername(ctx context.Context, username string) (core.User, error)
+	GetRole(ctx context.Context, name string) (core.Role, error)
+	CreateUser(ctx context.Context, username, password, role string) error
+	UpdateUserPassword(ctx context.Context, username, password string) error
+	SaveRefreshToken(ctx context.Context, token core.RefreshToken) error
+	UseRefreshToken(ctx context.Context, id string) (core.RefreshToken, error)
+	RevokeRefreshFamily(ctx context.Context, family string) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, username string, before time.Time) error
+	TokenRevoked(ctx context.Context, jti, username string, issuedAt time.Time) (bool, error)
+	DeleteExpiredTokens(ctx context.Context, now time.Time) error
+	SaveAPIKey(ctx context.Context, key core.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (core.APIKey, error)
+	ListAPIKeys(ctx context.Context, username string) ([]core.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+	GetLoginFailures(ctx context.Context, subject string) (core.LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, subject string, at, resetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, subject string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, subject string) error
+	ListLoginLocks(ctx context.Context, now time.Time) ([]core.LoginFailures, error)
+	DeleteLoginFailures(ctx context.Context, before, now time.Time) error
+	Health(ctx context.Context) core.StorageHealth
+}
+
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// Permissions - права роли на момент выдачи; новые права роли действуют с токенов, выданных после изменения
+	Permissions []string `json:"permissions,omitempty"`
+	jwt.StandardClaims
+}
+
+// HasPermission сообщает, есть ли у владельца токена право permission
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
+
+// Server - gRPC-сервис выдачи и проверки токенов
+type Server struct {
+	pb.UnimplementedAuthServiceServer
+	keys       *Keyring
+	store      Storage
+	refreshTTL time.Duration
+	login      LoginPolicy
+}
+
+// NewServer создает сервис; refreshTTL <= 0 - DefaultRefreshTTL, нулевые поля login - значения по умолчанию
+func NewServer(keys *Keyring, store Storage, refreshTTL time.Duration, login LoginPolicy) *Server {
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+	return &Server{keys: keys, store: store, refreshTTL: refreshTTL, login: login.withDefaults()}
+}
+
+// GenerateJWT выдает пару токенов для любого пользователя без пароля, поэтому требует право users:manage
+func (s *Server) GenerateJWT(ctx context.Context, req *pb.GenerateJWTRequest) (*pb.GenerateJWTResponse, error) {
+	if _, err := s.requirePermission(ctx, core.PermUsersManage); err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, req.Username, req.Role, req.ExpiryMinutes, "")
+}
+
+func (s *Server) sign(username, role string, permissions []string, expiryMinutes int32) (string, error) {
+	key, err := s.keys.Signing()
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		Username:    username,
+		Role:        role,
+		Permissions: permissions,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(expiryMinutes) * time.Minute).Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// ValidateJWT проверяет подпись, срок и отзыв токена
+func (s *Server) ValidateJWT(ctx context.Context, req *pb.ValidateJWTRequest) (*pb.ValidateJWTResponse, error) {
+	claims, err := s.validate(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ValidateJWTResponse{
+		Valid:       true,
+		Username:    claims.Username,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+	}, nil
+}
+
+// parse проверяет подпись ключом из заголовка kid; токены без kid и с выведенным ключом не принимаются
+func (s *Server) parse(tokenString string) (*Claims, error) {
+	return parseClaims(tokenString, func(kid string) (string, interface{}, error) {
+		key, err := s.keys.Verifying(kid)
+		if err != nil {
+			return "", nil, err
+		}
+		return key.Method().Alg(), key.verifyingKey(), nil
+	})
+}
+
+// validate проверяет токен и сверяется со списком отзывов
+func (s *Server) validate(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := s.parse(tokenString)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	revoked, err := s.store.TokenRevoked(ctx, claims.Id, claims.Username, time.Unix(claims.IssuedAt, 0).UTC())
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if revoked {
+		return nil, status.Error(codes.Unauthenticated, "token revoked")
+	}
+	return claims, nil
+}
+
+// GetPublicKeys отдает открытые ключи активных асимметричных ключей; ключи HS256 не раскрываются
+func (s *Server) GetPublicKeys(ctx context.Context, req *pb.GetPublicKeysRequest) (*pb.GetPublicKeysResponse, error) {
+	res := &pb.GetPublicKeysResponse{}
+	for _, k := range s.keys.Keys() {
+		if k.Retired {
+			continue
+		}
+		if der, ok := k.Public(); ok {
+			res.Keys = append(res.Keys, &pb.PublicKey{Kid: k.ID, Alg: k.Alg, Key: der})
+		}
+	}
+	return res, nil
+}
+
+// RotateKeys выпускает новый ключ подписи; токен с правом keys:manage передается в metadata authorization
+func (s *Server) RotateKeys(ctx context.Context, req *pb.RotateKeysRequest) (*pb.RotateKeysResponse, error) {
+	if _, err := s.requirePermission(ctx, core.PermKeysManage); err != nil {
+		return nil, err
+	}
+
+	key, err := s.keys.Rotate(req.Retire)
+	if errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrManagedKey) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.RotateKeysResponse{Kid: key.ID}
+	for _, k := range s.keys.Keys() {
+		// у ключей из конфига даты выпуска нет
+		var created int64
+		if !k.CreatedAt.IsZero() {
+			created = k.CreatedAt.Unix()
+		}
+		res.Keys = append(res.Keys, &pb.SigningKey{Kid: k.ID, CreatedAt: created, Retired: k.Retired, Managed: k.Managed, Alg: k.Alg})
+	}
+	return res, nil
+}
+
+// caller возвращает данные токена или ключа API из metadata authorization
+func (s *Server) caller(ctx context.Context) (*Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "no token provided")
+	}
+
+	token := strings.TrimPrefix(values[0], "Bearer ")
+	validate := s.validate
+	if IsAPIKey(token) {
+		validate = s.validateAPIKey
+	}
+	claims, err := validate(ctx, token)
+	if status.Code(err) == codes.Unauthenticated {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return claims, err
+}
+
+// requirePermission возвращает данные токена из metadata, если у его владельца есть право permission
+func (s *Server) requirePermission(ctx context.Context, permission string) (*Claims, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.HasPermission(permission) {
+		return nil, status.Errorf(codes.PermissionDenied, "permission %s required", permission)
+	}
+	return claims, nil
+}
+
+// permissions возвращает права роли из базы; токен с неизвестной ролью не выдается
+func (s *Server) permissions(ctx context.Context, role string) ([]string, error) {
+	r, err := s.store.GetRole(ctx, role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.InvalidArgument, "%v %q", core.ErrUnknownRole, role)
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return r.Permissions, nil
+}
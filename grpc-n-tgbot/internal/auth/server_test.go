@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/sgsoul/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func openStorage(t *testing.T) storage.Storage {
+	st, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func newTestServer(t *testing.T) *Server {
+	keys, err := LoadKeyring([]core.AuthKey{{ID: "config", Secret: testSecret}}, filepath.Join(t.TempDir(), "keys.json"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(keys, openStorage(t), time.Hour, LoginPolicy{})
+}
+
+func generate(t *testing.T, s *Server, username, role string) string {
+	res, err := s.issue(context.Background(), username, role, 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Token
+}
+
+func TestValidateJWT(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	token := generate(t, s, "user1", core.RoleUser)
+	res, err := s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: token})
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", res.Username)
+	assert.Equal(t, core.RoleUser, res.Role)
+
+	// токен, подписанный старым общим секретом без kid, не принимается
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "user1"}).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: legacy})
+	assert.Error(t, err)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "admin", Role: core.RoleAdmin})
+	forged.Header["kid"] = "config"
+	forgedToken, err := forged.SignedString([]byte("another secret of the same length"))
+	assert.NoError(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: forgedToken})
+	assert.Error(t, err)
+}
+
+func TestRotateKeys(t *testing.T) {
+	s := newTestServer(t)
+
+	_, err := s.RotateKeys(context.Background(), &pb.RotateKeysRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	asUser := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+generate(t, s, "user1", core.RoleUser)))
+	_, err = s.RotateKeys(asUser, &pb.RotateKeysRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	old := generate(t, s, "user1", core.RoleUser)
+	asAdmin := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+generate(t, s, "admin", core.RoleAdmin)))
+
+	_, err = s.RotateKeys(asAdmin, &pb.RotateKeysRequest{Retire: []string{"config"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := s.RotateKeys(asAdmin, &pb.RotateKeysRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, res.Keys, 2)
+	assert.True(t, res.Keys[0].Managed)
+
+	// новые токены подписываются новым ключом, старые остаются в силе
+	token, _, err := new(jwt.Parser).ParseUnverified(generate(t, s, "user1", core.RoleUser), &Claims{})
+	assert.NoError(t, err)
+	assert.Equal(t, res.Kid, token.Header["kid"])
+	_, err = s.ValidateJWT(context.Background(), &pb.ValidateJWTRequest{Token: old})
+	assert.NoError(t, err)
+}
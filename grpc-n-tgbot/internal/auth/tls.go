@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	ErrNoCertificate  = errors.New("TLS certificate and key are not configured")
+	ErrIncompletePair = errors.New("cert_file and key_file must be set together")
+)
+
+// certCheckInterval - не чаще этого файлы сертификатов проверяются на замену
+var certCheckInterval = 10 * time.Second
+
+// ServerCredentials - TLS сервиса авторизации; с CAFile клиенты обязаны предъявить сертификат этого CA
+func ServerCredentials(cfg core.TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		log.Warn().Msg("auth service accepts plaintext connections")
+		return insecure.NewCredentials(), nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	src, err := newCertSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// конфиг собирается на каждое соединение, чтобы подхватить замененные сертификаты
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := src.current()
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2"},
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				conf.ClientCAs = pool
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return conf, nil
+		},
+	}), nil
+}
+
+// ClientCredentials - TLS клиента сервиса авторизации; без CAFile сервер проверяется системными корнями
+func ClientCredentials(cfg core.TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		log.Warn().Msg("connecting to auth service without TLS")
+		return insecure.NewCredentials(), nil
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, ErrIncompletePair
+	}
+
+	conf := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.ServerName}
+	if cfg.CertFile == "" && cfg.CAFile == "" {
+		return credentials.NewTLS(conf), nil
+	}
+	src, err := newCertSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.CertFile != "" {
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := src.current()
+			return cert, nil
+		}
+	}
+	if cfg.CAFile != "" {
+		// RootCAs не перечитывается, поэтому цепочку сервера проверяем сами по текущему CA
+		conf.InsecureSkipVerify = true
+		return newCACredentials(conf, src), nil
+	}
+	return credentials.NewTLS(conf), nil
+}
+
+// caCredentials - TLS клиента, проверяющий сервер по текущему CA из certSource
+type caCredentials struct {
+	credentials.TransportCredentials
+	conf *tls.Config
+	src  *certSource
+}
+
+func newCACredentials(conf *tls.Config, src *certSource) *caCredentials {
+	return &caCredentials{TransportCredentials: credentials.NewTLS(conf), conf: conf, src: src}
+}
+
+// ClientHandshake проверяет сертификат на server_name, а без него - на хост из адреса подключения.
+// Для IP crypto/tls оставляет ConnectionState.ServerName пустым, поэтому хост берется отсюда
+func (c *caCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host := c.conf.ServerName
+	if host == "" {
+		host = authority
+		if h, _, err := net.SplitHostPort(authority); err == nil {
+			host = h
+		}
+	}
+
+	conf := c.conf.Clone()
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		_, pool := c.src.current()
+		return verifyServer(cs, host, pool)
+	}
+	return credentials.NewTLS(conf).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *caCredentials) Clone() credentials.TransportCredentials {
+	return newCACredentials(c.conf.Clone(), c.src)
+}
+
+func (c *caCredentials) OverrideServerName(name string) error {
+	c.conf.ServerName = name
+	return nil
+}
+
+// verifyServer повторяет стандартную проверку сертификата сервера: цепочка до pool и имя хоста,
+// которое может быть и IP-адресом - тогда оно сверяется с IP из сертификата
+func verifyServer(cs tls.ConnectionState, host string, pool *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	if host == "" {
+		return errors.New("server name is not set")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// certSource держит сертификат и CA из файлов и перечитывает их, когда файлы меняются на диске
+type certSource struct {
+	cfg core.TLSConfig
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertSource(cfg core.TLSConfig) (*certSource, error) {
+	c := &certSource{cfg: cfg}
+	modTime, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+// modified возвращает время последнего изменения файлов сертификатов
+func (c *certSource) modified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.cfg.CertFile, c.cfg.KeyFile, c.cfg.CAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certSource) load(modTime time.Time) error {
+	var cert *tls.Certificate
+	if c.cfg.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if c.cfg.CAFile != "" {
+		data, err := os.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", c.cfg.CAFile)
+		}
+	}
+
+	c.cert, c.pool, c.modTime = cert, pool, modTime
+	return nil
+}
+
+// current возвращает сертификат и CA; если новые файлы не читаются, например записаны не до конца,
+// остаются прежние, а попытка повторится при следующей проверке
+func (c *certSource) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, c.pool
+	}
+	c.checked = time.Now()
+
+	modTime, err := c.modified()
+	if err == nil && !modTime.Equal(c.modTime) {
+		if err = c.load(modTime); err == nil {
+			log.Info().Str("cert", c.cfg.CertFile).Str("ca", c.cfg.CAFile).Msg("TLS certificates reloaded")
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("error reloading TLS certificates")
+	}
+	return c.cert, c.pool
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// testCA - одноразовый CA, выпускающий сертификаты для localhost
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, der: der}
+}
+
+// writeCA записывает сертификат CA в файл
+func (ca *testCA) writeCA(t *testing.T, file string) string {
+	t.Helper()
+	writePEM(t, file, "CERTIFICATE", ca.der)
+	return file
+}
+
+// issue выпускает сертификат сервера или клиента и записывает пару в dir/name.crt и dir/name.key
+func (ca *testCA) issue(t *testing.T, dir, name string, client bool) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	usage := x509.ExtKeyUsageServerAuth
+	if client {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+func serveTLS(t *testing.T, cfg core.TLSConfig) string {
+	t.Helper()
+	creds, err := ServerCredentials(cfg)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer(grpc.Creds(creds))
+	pb.RegisterAuthServiceServer(s, newTestServer(t))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func callTLS(t *testing.T, address string, cfg core.TLSConfig) error {
+	t.Helper()
+	creds, err := ClientCredentials(cfg)
+	require.NoError(t, err)
+	return call(t, address, creds)
+}
+
+// call открывает новое соединение, чтобы каждый вызов проходил TLS-рукопожатие
+func call(t *testing.T, address string, creds credentials.TransportCredentials) error {
+	t.Helper()
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = pb.NewAuthServiceClient(conn).GetPublicKeys(ctx, &pb.GetPublicKeysRequest{})
+	return err
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", false)
+	address := serveTLS(t, core.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+
+	caFile := ca.writeCA(t, filepath.Join(dir, "ca.crt"))
+	assert.NoError(t, callTLS(t, address, core.TLSConfig{CAFile: caFile, ServerName: "localhost"}))
+
+	// сервер с сертификатом чужого CA
+	other := newTestCA(t).writeCA(t, filepath.Join(dir, "other.crt"))
+	assert.Equal(t, codes.Unavailable, status.Code(callTLS(t, address, core.TLSConfig{CAFile: other, ServerName: "localhost"})))
+
+	// имя не совпадает с сертификатом
+	assert.Equal(t, codes.Unavailable, status.Code(callTLS(t, address, core.TLSConfig{CAFile: caFile, ServerName: "auth.example.com"})))
+
+	// без server_name сертификат сверяется с IP из адреса подключения
+	assert.NoError(t, callTLS(t, address, core.TLSConfig{CAFile: caFile}))
+	_, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	assert.NoError(t, callTLS(t, "localhost:"+port, core.TLSConfig{CAFile: caFile}))
+
+	// клиент без TLS
+	assert.Equal(t, codes.Unavailable, status.Code(callTLS(t, address, core.TLSConfig{Insecure: true})))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := ca.writeCA(t, filepath.Join(dir, "ca.crt"))
+	certFile, keyFile := ca.issue(t, dir, "server", false)
+	address := serveTLS(t, core.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile})
+
+	// без сертификата клиента
+	assert.Equal(t, codes.Unavailable, status.Code(callTLS(t, address, core.TLSConfig{CAFile: caFile, ServerName: "localhost"})))
+
+	clientCert, clientKey := ca.issue(t, dir, "client", true)
+	assert.NoError(t, callTLS(t, address, core.TLSConfig{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "localhost"}))
+
+	// сертификат клиента от чужого CA
+	otherCert, otherKey := newTestCA(t).issue(t, dir, "other", true)
+	assert.Equal(t, codes.Unavailable, status.Code(callTLS(t, address, core.TLSConfig{CertFile: otherCert, KeyFile: otherKey, CAFile: caFile, ServerName: "localhost"})))
+}
+
+func TestTLSReload(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	t.Cleanup(func() { certCheckInterval = interval })
+
+	dir := t.TempDir()
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	certFile, keyFile := oldCA.issue(t, dir, "server", false)
+	address := serveTLS(t, core.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+
+	caFile := newCA.writeCA(t, filepath.Join(dir, "ca.crt"))
+	client, err := ClientCredentials(core.TLSConfig{CAFile: caFile, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(call(t, address, client)))
+
+	// сервер получил сертификат нового CA без перезапуска
+	newCA.issue(t, dir, "server", false)
+	assert.NoError(t, call(t, address, client))
+
+	// клиент подхватил замену CA
+	oldCA.writeCA(t, caFile)
+	assert.Equal(t, codes.Unavailable, status.Code(call(t, address, client)))
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	_, err := ServerCredentials(core.TLSConfig{})
+	assert.ErrorIs(t, err, ErrNoCertificate)
+
+	_, err = ClientCredentials(core.TLSConfig{CertFile: "client.crt"})
+	assert.ErrorIs(t, err, ErrIncompletePair)
+
+	_, err = ServerCredentials(core.TLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"})
+	assert.Error(t, err)
+}
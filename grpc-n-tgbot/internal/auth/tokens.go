@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// issue выдает access-токен и refresh-токен цепочки family; пустая family начинает новую цепочку
+func (s *Server) issue(ctx context.Context, username, role string, expiryMinutes int32, family string) (*pb.GenerateJWTResponse, error) {
+	permissions, err := s.permissions(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	access, err := s.sign(username, role, permissions, expiryMinutes)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	if family == "" {
+		if family, err = randomString(16); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(s.refreshTTL)
+	err = s.store.SaveRefreshToken(ctx, core.RefreshToken{
+		ID:        hashToken(refresh),
+		Username:  username,
+		Role:      role,
+		Family:    family,
+		CreatedAt: now,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return &pb.GenerateJWTResponse{Token: access, RefreshToken: refresh, RefreshExpiresAt: expires.Unix()}, nil
+}
+
+// RefreshToken обменивает refresh-токен на новую пару. Каждый refresh-токен действует один раз:
+// повторное предъявление значит, что токен утек, и вся цепочка отзывается
+func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.GenerateJWTResponse, error) {
+	token, err := s.store.UseRefreshToken(ctx, hashToken(req.RefreshToken))
+	switch {
+	case errors.Is(err, core.ErrTokenReused):
+		log.Warn().Str("username", token.Username).Msg("refresh token reused, revoking the token family")
+		if err := s.store.RevokeRefreshFamily(ctx, token.Family); err != nil {
+			return nil, storageError(err)
+		}
+		return nil, status.Error(codes.Unauthenticated, "refresh token reused")
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	case err != nil:
+		return nil, storageError(err)
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "refresh token expired")
+	}
+
+	// роль и ее права берутся из базы: за время жизни цепочки их могли поменять
+	user, err := s.store.GetUserByUsername(ctx, token.Username)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.Disabled) {
+		return nil, status.Error(codes.Unauthenticated, "account disabled")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return s.issue(ctx, user.Username, user.Role, req.ExpiryMinutes, token.Family)
+}
+
+// RevokeToken отзывает access-токен до истечения его срока и цепочку refresh-токена.
+// Недействительные и уже истекшие токены отзывать не нужно, они молча пропускаются
+func (s *Server) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	if req.Token == "" && req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "no token provided")
+	}
+
+	if req.Token != "" {
+		claims, err := s.parse(req.Token)
+		if err == nil && claims.Id != "" {
+			if err := s.store.RevokeToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0).UTC()); err != nil {
+				return nil, storageError(err)
+			}
+		}
+	}
+
+	if req.RefreshToken != "" {
+		token, err := s.store.UseRefreshToken(ctx, hashToken(req.RefreshToken))
+		if err != nil && !errors.Is(err, core.ErrTokenReused) && !errors.Is(err, sql.ErrNoRows) {
+			return nil, storageError(err)
+		}
+		if token.Family != "" {
+			if err := s.store.RevokeRefreshFamily(ctx, token.Family); err != nil {
+				return nil, storageError(err)
+			}
+		}
+	}
+
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+// RevokeAllForUser отзывает все выданные пользователю токены: refresh-токены удаляются,
+// access-токены, выданные до этого момента, перестают проходить ValidateJWT
+func (s *Server) RevokeAllForUser(ctx context.Context, req *pb.RevokeAllForUserRequest) (*pb.RevokeAllForUserResponse, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.HasPermission(core.PermUsersManage) && claims.Username != req.Username {
+		return nil, status.Errorf(codes.PermissionDenied, "permission %s required", core.PermUsersManage)
+	}
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "no username provided")
+	}
+
+	// iat хранится с точностью до секунды, поэтому отзываются и токены, выданные в текущую секунду
+	before := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	if err := s.store.RevokeUserTokens(ctx, req.Username, before); err != nil {
+		return nil, storageError(err)
+	}
+	return &pb.RevokeAllForUserResponse{}, nil
+}
+
+// StartCleanup раз в interval удаляет истекшие refresh-токены и отзывы и давние неудачные входы, пока не отменен ctx
+func (s *Server) StartCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC().Truncate(time.Second)
+			if err := s.store.DeleteExpiredTokens(ctx, now); err != nil {
+				log.Error().Err(err).Msg("error deleting expired tokens")
+			}
+			if err := s.store.DeleteLoginFailures(ctx, now.Add(-loginFailureWindow), now); err != nil {
+				log.Error().Err(err).Msg("error deleting old failed logins")
+			}
+		}
+	}
+}
+
+// StartHealthCheck раз в interval проверяет базу и переключает статус сервиса в hs:
+// без базы сервис не может ни выдать, ни проверить токен
+func (s *Server) StartHealthCheck(ctx context.Context, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		state := healthpb.HealthCheckResponse_SERVING
+		if !s.store.Health(ctx).Available {
+			state = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", state)
+		hs.SetServingStatus(pb.AuthService_ServiceDesc.ServiceName, state)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// hashToken - под каким id refresh-токен хранится в базе; сам токен не сохраняется
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	mocks "github.com/sgsoul/internal/auth/mocks"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func login(t *testing.T, s *Server, username, role string) *pb.GenerateJWTResponse {
+	res, err := s.issue(context.Background(), username, role, 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func asUser(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	first := login(t, s, "user1", core.RoleUser)
+	assert.NotEmpty(t, first.RefreshToken)
+
+	second, err := s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: first.RefreshToken, ExpiryMinutes: 5})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	res, err := s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: second.Token})
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", res.Username)
+
+	// повторное предъявление старого токена отзывает всю цепочку, включая новый токен
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: first.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: second.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: "unknown"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRevokeToken(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens := login(t, s, "user1", core.RoleUser)
+	other := login(t, s, "user1", core.RoleUser)
+
+	_, err := s.RevokeToken(ctx, &pb.RevokeTokenRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.RevokeToken(ctx, &pb.RevokeTokenRequest{Token: tokens.Token, RefreshToken: tokens.RefreshToken})
+	assert.NoError(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: tokens.Token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// другие сессии пользователя не затронуты, повторный отзыв не ошибка
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: other.Token})
+	assert.NoError(t, err)
+	_, err = s.RevokeToken(ctx, &pb.RevokeTokenRequest{Token: tokens.Token, RefreshToken: tokens.RefreshToken})
+	assert.NoError(t, err)
+}
+
+func TestRevokeAllForUser(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	user1 := login(t, s, "user1", core.RoleUser)
+	user2 := login(t, s, "user2", core.RoleUser)
+	admin := login(t, s, "admin", core.RoleAdmin)
+
+	_, err := s.RevokeAllForUser(asUser(user2.Token), &pb.RevokeAllForUserRequest{Username: "user1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = s.RevokeAllForUser(asUser(admin.Token), &pb.RevokeAllForUserRequest{Username: "user1"})
+	assert.NoError(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: user1.Token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: user1.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// пользователь может выйти из всех сессий сам, но уже отозванным токеном - нет
+	_, err = s.RevokeAllForUser(asUser(user1.Token), &pb.RevokeAllForUserRequest{Username: "user1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RevokeAllForUser(asUser(user2.Token), &pb.RevokeAllForUserRequest{Username: "user2"})
+	assert.NoError(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: user2.Token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: admin.Token})
+	assert.NoError(t, err)
+}
+
+func TestValidateStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := newTestServer(t)
+	mockStorage := mocks.NewMockStorage(ctrl)
+	s.store = mockStorage
+	token, err := s.sign("user1", core.RoleUser, nil, 5)
+	assert.NoError(t, err)
+
+	// без списка отзывов токен не принимается, клиент получает Unavailable и может повторить позже
+	mockStorage.EXPECT().TokenRevoked(gomock.Any(), gomock.Any(), "user1", gomock.Any()).Return(false, core.ErrStorageUnavailable)
+	_, err = s.ValidateJWT(context.Background(), &pb.ValidateJWTRequest{Token: token})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	mockStorage.EXPECT().GetRole(gomock.Any(), core.RoleUser).Return(core.Role{Name: core.RoleUser, Permissions: []string{core.PermSearchRead}}, nil)
+	mockStorage.EXPECT().SaveRefreshToken(gomock.Any(), gomock.Any()).Return(errors.New("disk full"))
+	_, err = s.issue(context.Background(), "user1", core.RoleUser, 5, "")
+	assert.Error(t, err)
+}
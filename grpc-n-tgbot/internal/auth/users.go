@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dummyHash сравнивается с паролем неизвестного пользователя, чтобы по времени ответа нельзя было узнать, есть ли он
+const dummyHash = "$2a$10$YYUUgKpR8ERRdBLGlFZtz.Z6v6kJo4ndsoCedpXYVVUQBFtqXBquK"
+
+var errInvalidCredentials = status.Error(codes.Unauthenticated, "invalid username or password")
+
+// Login проверяет пароль и выдает пару токенов с ролью пользователя из базы.
+// Неудачи считаются и для несуществующих имен, чтобы блокировка не выдавала, есть ли пользователь
+func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.GenerateJWTResponse, error) {
+	subjects := s.loginSubjects(req)
+	if err := s.checkLoginLock(ctx, subjects); err != nil {
+		return nil, err
+	}
+
+	user, err := s.store.GetUserByUsername(ctx, req.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(req.Password))
+		s.loginFailed(ctx, subjects)
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		s.loginFailed(ctx, subjects)
+		return nil, errInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "account disabled")
+	}
+
+	s.loginSucceeded(ctx, user.Username)
+	return s.issue(ctx, user.Username, user.Role, req.ExpiryMinutes, "")
+}
+
+// Register создает пользователя; назначить роль, отличную от user, может только владелец права users:manage
+func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	role := req.Role
+	if role == "" {
+		role = core.RoleUser
+	}
+	if _, err := s.permissions(ctx, role); err != nil {
+		return nil, err
+	}
+	if role != core.RoleUser {
+		if _, err := s.requirePermission(ctx, core.PermUsersManage); err != nil {
+			return nil, err
+		}
+	}
+	if req.Username == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "username and password are required")
+	}
+
+	_, err := s.store.GetUserByUsername(ctx, req.Username)
+	if err == nil {
+		return nil, status.Error(codes.AlreadyExists, "user already exists")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, storageError(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateUser(ctx, req.Username, string(hash), role); err != nil {
+		return nil, storageError(err)
+	}
+	return &pb.RegisterResponse{}, nil
+}
+
+// ChangePassword меняет пароль владельца токена после проверки старого
+func (s *Server) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.NewPassword == "" {
+		return nil, status.Error(codes.InvalidArgument, "new password is required")
+	}
+
+	user, err := s.store.GetUserByUsername(ctx, claims.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)); err != nil {
+		return nil, status.Error(codes.PermissionDenied, core.ErrWrongPassword.Error())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateUserPassword(ctx, user.Username, string(hash)); err != nil {
+		return nil, storageError(err)
+	}
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+// storageError превращает недоступность базы в codes.Unavailable, чтобы клиент мог повторить запрос позже
+func storageError(err error) error {
+	if errors.Is(err, core.ErrStorageUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
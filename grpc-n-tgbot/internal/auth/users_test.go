@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/sgsoul/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// пароли пользователей из миграции 005
+	res, err := s.Login(ctx, &pb.LoginRequest{Username: "admin", Password: "adminpassword", ExpiryMinutes: 5})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res.RefreshToken)
+	claims, err := s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: res.Token})
+	assert.NoError(t, err)
+	assert.Equal(t, core.RoleAdmin, claims.Role)
+
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "wrong"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "nobody", Password: "password"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// заблокированный пользователь не входит и не обновляет токены
+	user1, err := s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.store.(storage.Storage).SetUserDisabled(ctx, "user1", true))
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: user1.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// выдать токен без пароля может только администратор
+	_, err = s.GenerateJWT(ctx, &pb.GenerateJWTRequest{Username: "user2", Role: core.RoleAdmin})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.GenerateJWT(asUser(res.Token), &pb.GenerateJWTRequest{Username: "user2", Role: core.RoleUser})
+	assert.NoError(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.Register(ctx, &pb.RegisterRequest{Username: "newuser", Password: "secret"})
+	assert.NoError(t, err)
+	res, err := s.Login(ctx, &pb.LoginRequest{Username: "newuser", Password: "secret"})
+	assert.NoError(t, err)
+	claims, err := s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: res.Token})
+	assert.NoError(t, err)
+	assert.Equal(t, core.RoleUser, claims.Role)
+
+	_, err = s.Register(ctx, &pb.RegisterRequest{Username: "newuser", Password: "secret"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = s.Register(ctx, &pb.RegisterRequest{Username: "", Password: "secret"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.Register(ctx, &pb.RegisterRequest{Username: "root", Password: "secret", Role: "root"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// администратора создает только администратор
+	_, err = s.Register(asUser(res.Token), &pb.RegisterRequest{Username: "boss", Password: "secret", Role: core.RoleAdmin})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.Register(asUser(generate(t, s, "admin", core.RoleAdmin)), &pb.RegisterRequest{Username: "boss", Password: "secret", Role: core.RoleAdmin})
+	assert.NoError(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	res, err := s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.NoError(t, err)
+
+	_, err = s.ChangePassword(ctx, &pb.ChangePasswordRequest{OldPassword: "password", NewPassword: "new"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.ChangePassword(asUser(res.Token), &pb.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "new"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.ChangePassword(asUser(res.Token), &pb.ChangePasswordRequest{OldPassword: "password"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.ChangePassword(asUser(res.Token), &pb.ChangePasswordRequest{OldPassword: "password", NewPassword: "new"})
+	assert.NoError(t, err)
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "password"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "new"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	log "github.com/rs/zerolog/log"
+	pb "github.com/sgsoul/internal/proto"
+)
+
+const (
+	// keysTTL - как долго Verifier доверяет полученным ключам; выведенный ключ перестает приниматься не позже
+	keysTTL = 5 * time.Minute
+	// refreshInterval - не чаще этого ключи перезапрашиваются из-за незнакомого kid
+	refreshInterval = 10 * time.Second
+)
+
+// keyLookup находит алгоритм и ключ проверки по kid
+type keyLookup func(kid string) (alg string, key interface{}, err error)
+
+// parseClaims проверяет токен ключом из заголовка kid; алгоритм задает ключ, а не заголовок токена
+func parseClaims(tokenString string, lookup keyLookup) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token without kid")
+		}
+		alg, key, err := lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return key, nil
+	})
+
+	// jwt прячет ошибку поиска ключа внутрь ValidationError
+	var ve *jwt.ValidationError
+	if errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorUnverifiable != 0 && ve.Inner != nil {
+		return nil, ve.Inner
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// PublicKeySource запрашивает открытые ключи у сервиса авторизации
+type PublicKeySource func(ctx context.Context) ([]*pb.PublicKey, error)
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// Verifier проверяет токены асимметричных ключей на месте, без обращения к сервису авторизации.
+// Ключи кешируются и перезапрашиваются, когда устарели или встретился незнакомый kid
+type Verifier struct {
+	fetch PublicKeySource
+	// fetching не дает нескольким запросам одновременно ходить за ключами
+	fetching sync.Mutex
+
+	mu      sync.Mutex
+	raw     []*pb.PublicKey
+	keys    map[string]publicKey
+	fetched time.Time
+	// failed и err - время и ошибка последней неудачной попытки; после нее ключи не запрашиваются refreshInterval
+	failed time.Time
+	err    error
+}
+
+func NewVerifier(fetch PublicKeySource) *Verifier {
+	return &Verifier{fetch: fetch, keys: map[string]publicKey{}}
+}
+
+// Verify проверяет токен; ErrUnknownKey значит, что проверить его на месте нельзя, например это ключ HS256
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	return parseClaims(tokenString, func(kid string) (string, interface{}, error) {
+		key, err := v.lookup(ctx, kid)
+		if err != nil {
+			return "", nil, err
+		}
+		return key.alg, key.key, nil
+	})
+}
+
+// PublicKeys возвращает закешированные ключи, обновив их, если они устарели
+func (v *Verifier) PublicKeys(ctx context.Context) ([]*pb.PublicKey, error) {
+	v.mu.Lock()
+	stale, empty := v.due(time.Since(v.fetched) > keysTTL), v.fetched.IsZero()
+	v.mu.Unlock()
+	if stale {
+		v.refresh(ctx, empty)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.fetched.IsZero() && v.err != nil {
+		return nil, v.err
+	}
+	return v.raw, nil
+}
+
+func (v *Verifier) lookup(ctx context.Context, kid string) (publicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	since := time.Since(v.fetched)
+	stale := v.due(since > keysTTL || (!ok && since > refreshInterval))
+	v.mu.Unlock()
+
+	if stale {
+		// знакомый ключ проверяет и пока идет обновление, а незнакомого kid стоит дождаться
+		v.refresh(ctx, !ok)
+		v.mu.Lock()
+		key, ok = v.keys[kid]
+		v.mu.Unlock()
+	}
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// due сообщает, пора ли перезапрашивать устаревшие ключи: после неудачи
+// сервис не дергается refreshInterval, а запросы проверяются уже полученными ключами. Вызывается под v.mu
+func (v *Verifier) due(stale bool) bool {
+	return stale && time.Since(v.failed) > refreshInterval
+}
+
+// refresh запрашивает ключи вне v.mu, так что проверки токенов его не ждут.
+// Одновременно идет только один запрос; без wait refresh не ждет чужой запрос и сразу возвращается
+func (v *Verifier) refresh(ctx context.Context, wait bool) {
+	if wait {
+		v.fetching.Lock()
+	} else if !v.fetching.TryLock() {
+		return
+	}
+	defer v.fetching.Unlock()
+
+	// пока ждали, ключи мог обновить другой запрос
+	v.mu.Lock()
+	recent := time.Since(v.fetched) <= refreshInterval || time.Since(v.failed) <= refreshInterval
+	v.mu.Unlock()
+	if recent {
+		return
+	}
+
+	raw, keys, err := v.load(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		// если сервис недоступен, проверяем тем, что уже есть
+		log.Warn().Err(err).Msg("error refreshing auth public keys")
+		v.failed, v.err = time.Now(), err
+		return
+	}
+	v.raw, v.keys, v.fetched = raw, keys, time.Now()
+	v.failed, v.err = time.Time{}, nil
+}
+
+func (v *Verifier) load(ctx context.Context) ([]*pb.PublicKey, map[string]publicKey, error) {
+	raw, err := v.fetch(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]publicKey, len(raw))
+	for _, k := range raw {
+		key, err := x509.ParsePKIXPublicKey(k.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+	return raw, keys, nil
+}
+
+// JWK - открытый ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS собирает документ JWKS из открытых ключей сервиса авторизации
+func JWKS(keys []*pb.PublicKey) (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	enc := base64.RawURLEncoding
+
+	for _, k := range keys {
+		parsed, err := x509.ParsePKIXPublicKey(k.Key)
+		if err != nil {
+			return JWKSet{}, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+
+		jwk := JWK{Kid: k.Kid, Alg: k.Alg, Use: "sig"}
+		switch key := parsed.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = enc.EncodeToString(key.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = enc.EncodeToString(key)
+		default:
+			return JWKSet{}, fmt.Errorf("key %q: unsupported key type %T", k.Kid, parsed)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSource отдает ключи сервера и считает запросы
+func countingSource(s *Server, calls *int) PublicKeySource {
+	return func(ctx context.Context) ([]*pb.PublicKey, error) {
+		*calls++
+		res, err := s.GetPublicKeys(ctx, &pb.GetPublicKeysRequest{})
+		if err != nil {
+			return nil, err
+		}
+		return res.Keys, nil
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	calls := 0
+	v := NewVerifier(countingSource(s, &calls))
+
+	// первый ключ из конфига - HS256, на месте его не проверить
+	_, err := v.Verify(ctx, generate(t, s, "user1", core.RoleUser))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, calls)
+
+	first, err := s.keys.Rotate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, AlgEdDSA, first.Alg)
+
+	// незнакомый kid сразу после обновления не приводит к новому запросу
+	token := generate(t, s, "user1", core.RoleUser)
+	_, err = v.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, calls)
+
+	v.fetched = v.fetched.Add(-refreshInterval)
+	claims, err := v.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", claims.Username)
+	assert.Equal(t, 2, calls)
+
+	// знакомые ключи берутся из кеша
+	_, err = v.Verify(ctx, generate(t, s, "admin", core.RoleAdmin))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// подпись HS256 открытым ключом как секретом не принимается
+	public, _ := first.Public()
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "admin", Role: core.RoleAdmin})
+	forged.Header["kid"] = first.ID
+	forgedToken, err := forged.SignedString(public)
+	assert.NoError(t, err)
+	_, err = v.Verify(ctx, forgedToken)
+	assert.Error(t, err)
+	_, err = s.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: forgedToken})
+	assert.Error(t, err)
+
+	// выведенный ключ пропадает из кеша после обновления
+	_, err = s.keys.Rotate([]string{first.ID})
+	assert.NoError(t, err)
+	v.fetched = v.fetched.Add(-keysTTL)
+	_, err = v.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestVerifierRefreshFailure(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	_, err := s.keys.Rotate(nil)
+	assert.NoError(t, err)
+
+	calls, fail := 0, false
+	source := countingSource(s, &calls)
+	v := NewVerifier(func(ctx context.Context) ([]*pb.PublicKey, error) {
+		if fail {
+			calls++
+			return nil, errors.New("auth service unavailable")
+		}
+		return source(ctx)
+	})
+
+	token := generate(t, s, "user1", core.RoleUser)
+	_, err = v.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// сервис недоступен: устаревшие ключи продолжают работать, а повторный запрос ждет refreshInterval
+	fail = true
+	v.fetched = v.fetched.Add(-keysTTL)
+	for i := 0; i < 3; i++ {
+		_, err = v.Verify(ctx, token)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls)
+	keys, err := v.PublicKeys(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, keys)
+	assert.Equal(t, 2, calls)
+
+	fail = false
+	v.failed = v.failed.Add(-refreshInterval)
+	_, err = v.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.True(t, v.failed.IsZero())
+
+	// без единого удачного запроса ключей нет, и ошибка возвращается до конца паузы
+	empty := NewVerifier(func(ctx context.Context) ([]*pb.PublicKey, error) {
+		calls++
+		return nil, errors.New("auth service unavailable")
+	})
+	_, err = empty.PublicKeys(ctx)
+	assert.Error(t, err)
+	_, err = empty.PublicKeys(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 4, calls)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	assert.NoError(t, err)
+	pemFile := filepath.Join(t.TempDir(), "rsa.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	assert.NoError(t, os.WriteFile(pemFile, data, 0o600))
+
+	keys, err := LoadKeyring([]core.AuthKey{{ID: "rsa", Alg: AlgRS256, PrivateKeyFile: pemFile}}, filepath.Join(t.TempDir(), "keys.json"), "")
+	assert.NoError(t, err)
+	s := NewServer(keys, openStorage(t), time.Hour, LoginPolicy{})
+
+	token := generate(t, s, "user1", core.RoleUser)
+	_, err = s.keys.Rotate(nil)
+	assert.NoError(t, err)
+
+	res, err := s.GetPublicKeys(context.Background(), &pb.GetPublicKeysRequest{})
+	assert.NoError(t, err)
+	set, err := JWKS(res.Keys)
+	assert.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, AlgRS256, set.Keys[0].Alg)
+	n, err := base64.RawURLEncoding.DecodeString(set.Keys[0].N)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, new(big.Int).SetBytes(n).Cmp(rsaKey.N))
+
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+
+	// токен ключа RS256 из конфига проверяется на месте
+	calls := 0
+	claims, err := NewVerifier(countingSource(s, &calls)).Verify(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", claims.Username)
+
+	for _, cfg := range []core.AuthKey{
+		{ID: "a", Alg: AlgRS256},
+		{ID: "a", Alg: AlgEdDSA, PrivateKeyFile: pemFile},
+		{ID: "a", Alg: "none", Secret: testSecret},
+		{ID: "a", Secret: testSecret, PrivateKeyFile: pemFile},
+	} {
+		_, err := LoadKeyring([]core.AuthKey{cfg}, "", "")
+		assert.Error(t, err, cfg)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"os"
+	"time"
 
 	log "github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
@@ -33,3 +34,12 @@ func New(configPath string) *Config {
 	}
 	return cfg
 }
+
+// EndpointTimeout возвращает дедлайн запроса для пути, зарегистрированного как pattern
+func (c *Config) EndpointTimeout(pattern string) time.Duration {
+	seconds, ok := c.EndpointTimeouts[pattern]
+	if !ok {
+		seconds = c.RequestTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
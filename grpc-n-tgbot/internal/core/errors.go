@@ -0,0 +1,37 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+)
+
+var (
+	// ErrWrongPassword - старый пароль не совпал при смене пароля
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrUnknownRole - роли нет в таблице roles
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrUnknownPermission - права нет в таблице permissions
+	ErrUnknownPermission = errors.New("unknown permission")
+	// ErrBuiltinRole - права admin не меняются, а admin и user не удаляются
+	ErrBuiltinRole = errors.New("built-in role cannot be changed or deleted")
+	// ErrRoleInUse - роль назначена пользователям
+	ErrRoleInUse = errors.New("role is assigned to users")
+	// ErrStorageUnavailable - база недоступна, запросы к ней не выполняются до восстановления связи
+	ErrStorageUnavailable = errors.New("storage is unavailable")
+	// ErrLocked - ту же работу уже выполняет другая реплика
+	ErrLocked = errors.New("locked by another instance")
+	// ErrTokenReused - refresh-токен уже обменяли; повторное предъявление означает, что его украли
+	ErrTokenReused = errors.New("refresh token already used")
+)
+
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// ValidRoleName проверяет имя роли; есть ли такая роль, знает только база
+func ValidRoleName(role string) bool {
+	return roleName.MatchString(role)
+}
+
+// BuiltinRole сообщает, что роль встроенная и удалить ее нельзя
+func BuiltinRole(role string) bool {
+	return role == RoleAdmin || role == RoleUser
+}
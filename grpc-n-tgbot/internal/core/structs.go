@@ -1,33 +1,307 @@
 package core
 
+import "time"
+
 type ComicWithID struct {
 	ID    int
 	Comic Comic
 }
 
 type ComicCount struct {
-	UpdatedComics int `json:"updated_comics"`
-	TotalComics   int `json:"total_comics"`
+	UpdatedComics int            `json:"updated_comics"`
+	TotalComics   int            `json:"total_comics"`
+	Statuses      map[string]int `json:"statuses,omitempty"`
+}
+
+// исходы загрузки комикса по номеру
+const (
+	StatusOK          = "ok"
+	StatusNotFound    = "not_found"
+	StatusNoImage     = "no_image"
+	StatusDecodeError = "decode_error"
+	StatusFetchError  = "fetch_error"
+)
+
+type IngestionStatus struct {
+	Num         int       `json:"num"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	NextRetry   time.Time `json:"next_retry,omitempty"`
+}
+
+// ComicGap - диапазон номеров подряд, отсутствующих в базе
+type ComicGap struct {
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type IngestionReport struct {
+	LatestComic  int            `json:"latest_comic"`
+	StoredComics int            `json:"stored_comics"`
+	Statuses     map[string]int `json:"statuses"`
+	Gaps         []ComicGap     `json:"gaps"`
+}
+
+type NormalizeCount struct {
+	NormalizedComics int `json:"normalized_comics"`
+	SkippedComics    int `json:"skipped_comics"`
+	Version          int `json:"normalizer_version"`
 }
 
 type Comic struct {
-	ID       int
-	URL      string
-	Keywords string
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Keywords    string    `json:"keywords"`
+	Title       string    `json:"title"`
+	Alt         string    `json:"alt"`
+	Transcript  string    `json:"transcript"`
+	NormVersion int       `json:"normalizer_version"`
+	ContentHash string    `json:"content_hash"`
+	SafeTitle   string    `json:"safe_title"`
+	Link        string    `json:"link"`
+	News        string    `json:"news"`
+	Published   time.Time `json:"published"`
+	// Hidden - комикс скрыт администратором и не попадает в поиск
+	Hidden bool `json:"hidden"`
+	// EditedAt - время ручной правки; такие комиксы не трогают resync и нормализация
+	EditedAt time.Time `json:"edited_at"`
+	// Terms - нормализованные слова по полям, сохраняются в comic_terms вместе с комиксом
+	Terms []ComicTerm `json:"-"`
+}
+
+// ComicPatch - ручная правка комикса администратором, nil-поля не меняются
+type ComicPatch struct {
+	Title      *string   `json:"title"`
+	Alt        *string   `json:"alt"`
+	Transcript *string   `json:"transcript"`
+	Keywords   *[]string `json:"keywords"`
+	Hidden     *bool     `json:"hidden"`
+}
+
+// поля комикса, из которых берутся слова для comic_terms
+const (
+	FieldTitle      = "title"
+	FieldAlt        = "alt"
+	FieldTranscript = "transcript"
+	// FieldKeywords - ключевые слова, заданные администратором вместо текста комикса
+	FieldKeywords = "keywords"
+)
+
+// ComicTerm - нормализованное слово в одном поле комикса и число его вхождений
+type ComicTerm struct {
+	Term  string
+	Field string
+	TF    int
+}
+
+type ComicRevision struct {
+	ComicID     int       `json:"comic_id"`
+	Revision    int       `json:"revision"`
+	ContentHash string    `json:"content_hash"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Alt         string    `json:"alt"`
+	Transcript  string    `json:"transcript"`
+	Diff        string    `json:"diff"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ComicImage struct {
+	ComicID     int
+	SourceURL   string
+	Hash        string
+	ContentType string
+	ThumbHash   string
+}
+
+type ResyncCount struct {
+	CheckedComics int `json:"checked_comics"`
+	ChangedComics int `json:"changed_comics"`
+}
+
+// Встроенные роли: у admin все права, user получают новые пользователи; moderator и свои роли можно менять
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleUser      = "user"
+)
+
+// Права доступа; какие права есть у роли, хранится в таблице role_permissions
+const (
+	// PermSearchRead - поиск комиксов
+	PermSearchRead = "search:read"
+	// PermComicsUpdate - просмотр, правка, скрытие и перезагрузка отдельных комиксов
+	PermComicsUpdate = "comics:update"
+	// PermComicsIngest - загрузка и нормализация всей базы комиксов
+	PermComicsIngest = "comics:ingest"
+	// PermUsersManage - пользователи и их роли
+	PermUsersManage = "users:manage"
+	// PermAuditRead - журнал аудита
+	PermAuditRead = "audit:read"
+	// PermKeysManage - ротация ключей подписи токенов
+	PermKeysManage = "keys:manage"
+)
+
+// Role - роль и ее права
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleList - все роли и права, которые можно им назначить
+type RoleList struct {
+	Roles       []Role   `json:"roles"`
+	Permissions []string `json:"permissions"`
 }
 
 type User struct {
-	ID       int
-	Username string
-	Password string
-	Role     string
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// Password - bcrypt-хеш, наружу не отдается
+	Password string `json:"-"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
 }
 
-var ComicInfo struct {
-	Img        string `json:"img"`
-	Alt        string `json:"alt"`
+// UserList - страница списка пользователей
+type UserList struct {
+	Users  []User `json:"users"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// UserPatch - изменения пользователя; nil-поля не трогаются
+type UserPatch struct {
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// Действия, которые попадают в журнал аудита
+const (
+	AuditLogin          = "login"
+	AuditRegister       = "register"
+	AuditUpdate         = "update"
+	AuditNormalize      = "normalize"
+	AuditComicUpdate    = "comic_update"
+	AuditComicDelete    = "comic_delete"
+	AuditComicRefetch   = "comic_refetch"
+	AuditRoleGrant      = "role_grant"
+	AuditUserUpdate     = "user_update"
+	AuditUserDelete     = "user_delete"
+	AuditPasswordChange = "password_change"
+	AuditKeyRotate      = "key_rotate"
+	AuditLogout         = "logout"
+	AuditRoleUpdate     = "role_update"
+	AuditRoleDelete     = "role_delete"
+	AuditAPIKeyCreate   = "api_key_create"
+	AuditAPIKeyRevoke   = "api_key_revoke"
+	AuditLoginUnlock    = "login_unlock"
+)
+
+// AuditRecord - запись журнала аудита; Status - HTTP-код ответа
+type AuditRecord struct {
+	ID        int64     `json:"id"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Details   string    `json:"details,omitempty"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RefreshToken - выданный refresh-токен; в базе хранится только хеш, Family объединяет цепочку обменов
+type RefreshToken struct {
+	ID        string
+	Username  string
+	Role      string
+	Family    string
+	Used      bool
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// APIKey - долгоживущий ключ для автоматизации. В базе хранится только хеш ключа, Prefix - начало ключа,
+// чтобы его можно было узнать в списке. Права ключа - Scopes, но не больше, чем у роли пользователя;
+// нулевые ExpiresAt, LastUsedAt и RevokedAt - не истекает, не использовался, не отозван
+type APIKey struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"-"`
+	Username   string    `json:"username"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+// LoginFailures - неудачные входы по имени пользователя или адресу: Subject - "user:имя" или "ip:адрес".
+// До LockedUntil вход с этим именем или с этого адреса не принимается; нулевое LockedUntil - без блокировки
+type LoginFailures struct {
+	Subject      string    `json:"subject"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+	LockedUntil  time.Time `json:"locked_until"`
+}
+
+// AuditFilter - условия выборки журнала; пустые поля не фильтруют
+type AuditFilter struct {
+	Actor  string
+	IP     string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// Failed оставляет только запросы, завершившиеся ошибкой
+	Failed bool
+	Limit  int
+	Offset int
+}
+
+const (
+	HealthOK = "ok"
+	// HealthDegraded - база недоступна, поиск работает по последнему индексу в памяти
+	HealthDegraded = "degraded"
+)
+
+// StorageHealth - состояние соединения с базой
+type StorageHealth struct {
+	Backend   string `json:"backend"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+	// Since - с какого момента база недоступна
+	Since time.Time `json:"since,omitempty"`
+	// NextRetry - когда будет следующая попытка переподключения
+	NextRetry time.Time `json:"next_retry,omitempty"`
+}
+
+type Health struct {
+	Status  string        `json:"status"`
+	Storage StorageHealth `json:"storage"`
+}
+
+// ComicInfo - комикс в формате info.0.json с xkcd.com
+type ComicInfo struct {
+	Month      string `json:"month"`
+	Num        int    `json:"num"`
+	Link       string `json:"link"`
+	Year       string `json:"year"`
+	News       string `json:"news"`
+	SafeTitle  string `json:"safe_title"`
 	Transcript string `json:"transcript"`
+	Alt        string `json:"alt"`
+	Img        string `json:"img"`
 	Title      string `json:"title"`
+	Day        string `json:"day"`
 }
 
 type Config struct {
@@ -40,6 +314,82 @@ type Config struct {
 	TokenTime int    `yaml:"token_max_time"`
 	ConcLim   int    `yaml:"concurrency_limit"`
 	RateLim   int    `yaml:"rate_limit"`
-	WebPort	  int    `yaml:"webport"`
+	WebPort   int    `yaml:"webport"`
 	XKCDUrl   string `yaml:"xkcd_url"`
-}
\ No newline at end of file
+	NormBatch int    `yaml:"normalize_batch"`
+	// ImageOverrides - ручные адреса картинок для интерактивных и прочих особых комиксов
+	ImageOverrides map[int]string `yaml:"image_overrides"`
+	ImageDir       string         `yaml:"image_dir"`
+	ThumbWidth     int            `yaml:"thumb_width"`
+	// MirrorImageURLs - отдавать в /info.0.json ссылки на /images/{num} этого сервера
+	MirrorImageURLs bool `yaml:"mirror_image_urls"`
+	// RequestTimeout - дедлайн запроса в секундах по умолчанию, 0 - без дедлайна
+	RequestTimeout int `yaml:"request_timeout"`
+	// EndpointTimeouts переопределяет дедлайн для отдельных путей, например "/update": 0
+	EndpointTimeouts map[string]int `yaml:"endpoint_timeouts"`
+	// LockTTL - срок аренды блокировки загрузки в секундах; реплика продлевает его, пока работает
+	LockTTL int `yaml:"lock_ttl"`
+	// AuthListen - адрес, на котором слушает сервис авторизации
+	AuthListen string `yaml:"auth_listen"`
+	// AuthAddress - адрес сервиса авторизации для клиентов
+	AuthAddress string `yaml:"auth_address"`
+	// AuthKeys - ключи подписи токенов из конфига; последний активный подписывает новые токены
+	AuthKeys []AuthKey `yaml:"auth_keys"`
+	// AuthKeysFile - файл ключей, выпущенных через RotateKeys
+	AuthKeysFile string `yaml:"auth_keys_file"`
+	// RefreshTokenTime - срок жизни refresh-токена в минутах; TokenTime задает срок access-токена
+	RefreshTokenTime int `yaml:"refresh_token_time"`
+	// AuthKeyAlg - алгоритм ключей, выпускаемых ротацией: EdDSA (по умолчанию), RS256 или HS256
+	AuthKeyAlg string `yaml:"auth_key_alg"`
+	// AuthServerTLS - сертификат сервиса авторизации и CA клиентов для mTLS
+	AuthServerTLS TLSConfig `yaml:"auth_server_tls"`
+	// AuthClientTLS - CA сервиса авторизации и сертификат клиента для mTLS
+	AuthClientTLS TLSConfig `yaml:"auth_client_tls"`
+	// AuthTimeout - дедлайн запроса к сервису авторизации в миллисекундах, по умолчанию секунда
+	AuthTimeout int `yaml:"auth_timeout"`
+	// AuthRetries - сколько раз повторить безопасный запрос, если сервис авторизации недоступен
+	AuthRetries int `yaml:"auth_retries"`
+	// AuthHandlerTimeout - наибольший дедлайн обработки запроса в сервисе авторизации в миллисекундах
+	AuthHandlerTimeout int `yaml:"auth_handler_timeout"`
+	// AuthMetricsListen - адрес, на котором сервис авторизации отдает метрики в /debug/vars; пусто - не отдает
+	AuthMetricsListen string `yaml:"auth_metrics_listen"`
+	// SchedulerAPIKey - ключ API, с которым планировщик запускает /update; нужно право comics:ingest
+	SchedulerAPIKey string `yaml:"scheduler_api_key"`
+	// LoginMaxFailures и LoginIPMaxFailures - неудачных входов подряд на имя и на адрес до блокировки; 0 - 5 и 20
+	LoginMaxFailures   int `yaml:"login_max_failures"`
+	LoginIPMaxFailures int `yaml:"login_ip_max_failures"`
+	// LoginLockout - первая блокировка в минутах, каждая следующая вдвое дольше; 0 - 15 минут
+	LoginLockout int `yaml:"login_lockout"`
+	// LoginTrustedAddrs - адреса посредников вроде бота, которые сами называют клиента в X-Login-Client.
+	// Неудачные входы через них считаются по этому клиенту, а не по общему адресу посредника. Без
+	// LoginClientSecret заголовку верят только по адресу, поэтому здесь не должно быть адресов, с которых
+	// могут прийти чужие клиенты
+	LoginTrustedAddrs []string `yaml:"login_trusted_addrs"`
+	// LoginClientSecret - общий с посредниками секрет; если задан, X-Login-Client принимается только
+	// вместе с ним в X-Login-Client-Secret
+	LoginClientSecret string `yaml:"login_client_secret"`
+}
+
+// TLSConfig - сертификаты gRPC-соединения; замененные на диске файлы подхватываются без перезапуска
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CAFile - CA другой стороны: на сервере включает обязательную проверку клиентов (mTLS),
+	// на клиенте заменяет системные корневые сертификаты
+	CAFile string `yaml:"ca_file"`
+	// ServerName - имя из сертификата сервера, если оно отличается от хоста в адресе
+	ServerName string `yaml:"server_name"`
+	// Insecure разрешает соединение без TLS, только для локальной разработки
+	Insecure bool `yaml:"insecure"`
+}
+
+// AuthKey - ключ подписи токенов: секрет HS256 задается строкой или файлом, ключ RS256 и EdDSA - PEM-файлом
+type AuthKey struct {
+	ID             string `yaml:"kid"`
+	Alg            string `yaml:"alg"`
+	Secret         string `yaml:"secret"`
+	SecretFile     string `yaml:"secret_file"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// Retired - токены с этим ключом больше не принимаются
+	Retired bool `yaml:"retired"`
+}
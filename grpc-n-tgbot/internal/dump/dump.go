@@ -0,0 +1,189 @@
+package dump
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+)
+
+//go:generate mockgen -source=dump.go -destination=mocks/mock.go
+
+// FormatVersion - версия формата выгрузки; импорт не принимает выгрузки новее
+const FormatVersion = 1
+
+// batchSize - сколько комиксов и пользователей читается из базы за раз при выгрузке
+const batchSize = 500
+
+// progressEvery - как часто печатается прогресс, в записях
+const progressEvery = 500
+
+const (
+	typeHeader = "header"
+	typeComic  = "comic"
+	typeUser   = "user"
+)
+
+type Storage interface {
+	GetCount(ctx context.Context) (int, error)
+	GetComicsAfter(ctx context.Context, afterID, limit int) ([]core.Comic, error)
+	UpsertComic(ctx context.Context, comic core.Comic) error
+	ListUsers(ctx context.Context, limit, offset int) ([]core.User, error)
+	UpsertUser(ctx context.Context, user core.User) error
+	GetRole(ctx context.Context, name string) (core.Role, error)
+}
+
+// record - одна строка выгрузки JSON Lines: заголовок, комикс или пользователь
+type record struct {
+	Type       string      `json:"type"`
+	Version    int         `json:"version,omitempty"`
+	ExportedAt *time.Time  `json:"exported_at,omitempty"`
+	Comic      *core.Comic `json:"comic,omitempty"`
+	User       *user       `json:"user,omitempty"`
+}
+
+// user - пользователь в выгрузке; у core.User хеш пароля скрыт от JSON
+type user struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	Password string `json:"password,omitempty"`
+}
+
+// Count - сколько записей выгружено или загружено
+type Count struct {
+	Comics  int `json:"comics"`
+	Users   int `json:"users"`
+	Skipped int `json:"skipped"`
+}
+
+type ExportOptions struct {
+	// Users добавляет в выгрузку пользователей
+	Users bool
+	// Passwords выгружает хеши паролей; без него пользователей можно восстановить только поверх существующих
+	Passwords bool
+	// Gzip сжимает выгрузку
+	Gzip bool
+	// Progress получает отчет о ходе выгрузки, nil - молча
+	Progress io.Writer
+}
+
+// Export выгружает все комиксы, включая скрытые, и по желанию пользователей в JSON Lines
+func Export(ctx context.Context, st Storage, w io.Writer, opts ExportOptions) (Count, error) {
+	var count Count
+
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		defer zw.Close()
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	now := time.Now().UTC()
+	if err := enc.Encode(record{Type: typeHeader, Version: FormatVersion, ExportedAt: &now}); err != nil {
+		return count, err
+	}
+
+	total, err := st.GetCount(ctx)
+	if err != nil {
+		return count, err
+	}
+	progress := newProgress(opts.Progress, "exported", total)
+
+	lastID := 0
+	for {
+		comics, err := st.GetComicsAfter(ctx, lastID, batchSize)
+		if err != nil {
+			return count, err
+		}
+		if len(comics) == 0 {
+			break
+		}
+
+		for i := range comics {
+			if err := enc.Encode(record{Type: typeComic, Comic: &comics[i]}); err != nil {
+				return count, err
+			}
+			lastID = comics[i].ID
+			count.Comics++
+			progress.add(1)
+		}
+	}
+
+	if opts.Users {
+		if err := exportUsers(ctx, st, enc, opts.Passwords, &count); err != nil {
+			return count, err
+		}
+	}
+	progress.done(count)
+
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if zw != nil {
+		return count, zw.Close()
+	}
+	return count, nil
+}
+
+func exportUsers(ctx context.Context, st Storage, enc *json.Encoder, passwords bool, count *Count) error {
+	for offset := 0; ; offset += batchSize {
+		users, err := st.ListUsers(ctx, batchSize, offset)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		for _, u := range users {
+			out := user{Username: u.Username, Role: u.Role, Disabled: u.Disabled}
+			if passwords {
+				out.Password = u.Password
+			}
+			if err := enc.Encode(record{Type: typeUser, User: &out}); err != nil {
+				return err
+			}
+			count.Users++
+		}
+	}
+}
+
+// progress печатает число обработанных записей каждые progressEvery штук
+type progress struct {
+	out   io.Writer
+	verb  string
+	total int
+	n     int
+}
+
+func newProgress(out io.Writer, verb string, total int) *progress {
+	if out == nil {
+		out = io.Discard
+	}
+	return &progress{out: out, verb: verb, total: total}
+}
+
+func (p *progress) add(n int) {
+	before := p.n / progressEvery
+	p.n += n
+	if p.n/progressEvery == before {
+		return
+	}
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "%s %d/%d comics\n", p.verb, p.n, p.total)
+		return
+	}
+	fmt.Fprintf(p.out, "%s %d records\n", p.verb, p.n)
+}
+
+func (p *progress) done(count Count) {
+	fmt.Fprintf(p.out, "%s %d comics, %d users, skipped %d\n", p.verb, count.Comics, count.Users, count.Skipped)
+}
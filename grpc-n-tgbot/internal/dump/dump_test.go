@@ -0,0 +1,161 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sgsoul/internal/core"
+	mocks "github.com/sgsoul/internal/dump/mocks"
+	"github.com/sgsoul/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// хеш пароля "password" из миграции 005
+const passwordHash = "$2a$10$YYUUgKpR8ERRdBLGlFZtz.Z6v6kJo4ndsoCedpXYVVUQBFtqXBquK"
+
+func openStorage(t *testing.T) storage.Storage {
+	st, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestExportImport(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		src := openStorage(t)
+		ctx := context.Background()
+
+		published := time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.NoError(t, src.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Keywords: "barrel",
+			Title: "Barrel", Alt: "Don't we all.", NormVersion: 2, ContentHash: "hash", Published: published}))
+		assert.NoError(t, src.SaveComicToDatabase(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", Title: "Petit Trees"}))
+		assert.NoError(t, src.SetComicHidden(ctx, 2, true))
+		assert.NoError(t, src.SetUserRole(ctx, "user1", core.RoleAdmin))
+
+		var buf, progress bytes.Buffer
+		count, err := Export(ctx, src, &buf, ExportOptions{Users: true, Gzip: compressed, Progress: &progress})
+		assert.NoError(t, err)
+		assert.Equal(t, Count{Comics: 2, Users: 3}, count)
+		assert.Contains(t, progress.String(), "exported 2 comics, 3 users")
+		if !compressed {
+			assert.NotContains(t, buf.String(), passwordHash)
+		}
+
+		dst := openStorage(t)
+		// в новой базе user1 есть из миграции, а пользователя без пароля создать нельзя
+		assert.NoError(t, dst.DeleteUser(ctx, "user2"))
+		count, err = Import(ctx, dst, &buf, ImportOptions{Users: true, Workers: 4})
+		assert.NoError(t, err)
+		assert.Equal(t, Count{Comics: 2, Users: 2, Skipped: 1}, count)
+
+		comic, err := dst.GetComicByID(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Barrel", comic.Title)
+		assert.Equal(t, "hash", comic.ContentHash)
+		assert.True(t, published.Equal(comic.Published))
+
+		comic, err = dst.GetComicByID(ctx, 2)
+		assert.NoError(t, err)
+		assert.True(t, comic.Hidden)
+
+		// слова для поиска пересчитываются из текста комикса
+		matches, err := dst.SearchTerms(ctx, []string{"barrel"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[int]int{1: 1}, matches)
+
+		user, err := dst.GetUserByUsername(ctx, "user1")
+		assert.NoError(t, err)
+		assert.Equal(t, core.RoleAdmin, user.Role)
+	}
+}
+
+func TestExportPasswords(t *testing.T) {
+	src := openStorage(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, &buf, ExportOptions{Users: true, Passwords: true})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), passwordHash)
+
+	dst := openStorage(t)
+	assert.NoError(t, dst.DeleteUser(ctx, "user2"))
+	count, err := Import(ctx, dst, &buf, ImportOptions{Users: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count.Users)
+
+	user, err := dst.GetUserByUsername(ctx, "user2")
+	assert.NoError(t, err)
+	assert.Equal(t, passwordHash, user.Password)
+}
+
+func TestImportLegacy(t *testing.T) {
+	dst := openStorage(t)
+	ctx := context.Background()
+
+	// формат concurrency/pkg/database: объекты с отступами подряд
+	legacy := `{
+   "1": {
+      "url": "https://imgs.xkcd.com/comics/barrel_cropped_(1).jpg",
+      "keywords": ["barrel", "boy"]
+   }
+}
+{
+   "3": {
+      "url": "https://imgs.xkcd.com/comics/island_color.jpg",
+      "keywords": ["island"]
+   }
+}
+`
+	count, err := Import(ctx, dst, strings.NewReader(legacy), ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, Count{Comics: 2}, count)
+
+	comic, err := dst.GetComicByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "barrel,boy", comic.Keywords)
+	assert.Equal(t, 0, comic.NormVersion)
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	ctx := context.Background()
+	// роли root нет в базе
+	mockStorage.EXPECT().GetRole(gomock.Any(), "root").Return(core.Role{}, sql.ErrNoRows)
+
+	for _, line := range []string{
+		`{"type":"comic","comic":{"id":0,"url":"https://imgs.xkcd.com/comics/1.png"}}`,
+		`{"type":"comic","comic":{"id":1,"url":"not a url"}}`,
+		`{"type":"user","user":{"username":"root","role":"root"}}`,
+		`{"type":"user","user":{"username":"root","role":"admin","password":"plain"}}`,
+		`{"type":"header","version":99}`,
+		`{"type":"table"}`,
+		`{"abc":{"url":"https://imgs.xkcd.com/comics/1.png"}}`,
+		`{"type":`,
+	} {
+		_, err := Import(ctx, mockStorage, strings.NewReader(line), ImportOptions{Users: true})
+		assert.Error(t, err, line)
+		assert.Contains(t, err.Error(), "record 1", line)
+	}
+
+	// с SkipInvalid плохие записи пропускаются, остальные сохраняются
+	mockStorage.EXPECT().UpsertComic(gomock.Any(), gomock.Any()).Return(nil)
+	input := `{"type":"comic","comic":{"id":0,"url":"https://imgs.xkcd.com/comics/1.png"}}
+{"type":"comic","comic":{"id":2,"url":"https://imgs.xkcd.com/comics/2.png"}}
+`
+	var progress bytes.Buffer
+	count, err := Import(ctx, mockStorage, strings.NewReader(input), ImportOptions{SkipInvalid: true, Progress: &progress})
+	assert.NoError(t, err)
+	assert.Equal(t, Count{Comics: 1, Skipped: 1}, count)
+	assert.Contains(t, progress.String(), "skipped record 1: invalid comic number 0")
+}
@@ -0,0 +1,273 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/words"
+	"github.com/sgsoul/internal/xkcd"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/errgroup"
+)
+
+type ImportOptions struct {
+	// Users загружает пользователей; без него записи пользователей пропускаются
+	Users bool
+	// Workers - сколько записей сохраняется в базу параллельно
+	Workers int
+	// SkipInvalid пропускает записи, не прошедшие проверку, вместо остановки импорта
+	SkipInvalid bool
+	// Progress получает отчет о ходе загрузки, nil - молча
+	Progress io.Writer
+}
+
+// item - проверенная запись, готовая к сохранению
+type item struct {
+	pos   int
+	comic *core.Comic
+	user  *core.User
+}
+
+// Import загружает выгрузку JSON Lines, сжатую gzip или нет, и сохраняет комиксы по номеру поверх существующих.
+// Принимается и старый формат файла базы: поток объектов {"<num>": {"url": ..., "keywords": [...]}}
+func Import(ctx context.Context, st Storage, r io.Reader, opts ImportOptions) (Count, error) {
+	var count Count
+
+	r, err := decompress(r)
+	if err != nil {
+		return count, err
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	var mu sync.Mutex
+	progress := newProgress(opts.Progress, "imported", 0)
+	// note учитывает пропущенную запись и объясняет причину
+	note := func(pos int, reason error) {
+		mu.Lock()
+		defer mu.Unlock()
+		count.Skipped++
+		fmt.Fprintf(progress.out, "skipped record %d: %v\n", pos, reason)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	items := make(chan item)
+
+	g.Go(func() error {
+		defer close(items)
+		return decodeItems(ctx, r, opts, items, note)
+	})
+
+	for i := 0; i < opts.Workers; i++ {
+		g.Go(func() error {
+			for it := range items {
+				skipped, err := save(ctx, st, it)
+				if err != nil {
+					return fmt.Errorf("record %d: %w", it.pos, err)
+				}
+				if skipped != nil {
+					note(it.pos, skipped)
+					continue
+				}
+
+				mu.Lock()
+				if it.comic != nil {
+					count.Comics++
+				} else {
+					count.Users++
+				}
+				progress.add(1)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	progress.done(count)
+	return count, err
+}
+
+// decompress распознает gzip по сигнатуре
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// decodeItems читает записи по одной, проверяет их и передает на сохранение
+func decodeItems(ctx context.Context, r io.Reader, opts ImportOptions, items chan<- item, note func(int, error)) error {
+	dec := json.NewDecoder(r)
+	for pos := 1; ; pos++ {
+		var raw map[string]json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("record %d: %w", pos, err)
+		}
+
+		parsed, err := parseRecord(raw, opts.Users)
+		if err != nil {
+			if !opts.SkipInvalid {
+				return fmt.Errorf("record %d: %w", pos, err)
+			}
+			note(pos, err)
+			continue
+		}
+
+		for _, it := range parsed {
+			it.pos = pos
+			select {
+			case items <- it:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// parseRecord разбирает строку выгрузки или объект старого формата; заголовок и пропущенные записи дают nil
+func parseRecord(raw map[string]json.RawMessage, users bool) ([]item, error) {
+	if _, ok := raw["type"]; !ok {
+		return parseLegacy(raw)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	switch rec.Type {
+	case typeHeader:
+		if rec.Version > FormatVersion {
+			return nil, fmt.Errorf("unsupported dump version %d, latest known %d", rec.Version, FormatVersion)
+		}
+		return nil, nil
+	case typeComic:
+		if rec.Comic == nil {
+			return nil, errors.New("comic record without comic")
+		}
+		if err := validateComic(*rec.Comic); err != nil {
+			return nil, err
+		}
+		return []item{{comic: rec.Comic}}, nil
+	case typeUser:
+		if rec.User == nil {
+			return nil, errors.New("user record without user")
+		}
+		if err := validateUser(*rec.User); err != nil {
+			return nil, err
+		}
+		if !users {
+			return nil, nil
+		}
+		u := core.User{Username: rec.User.Username, Password: rec.User.Password, Role: rec.User.Role, Disabled: rec.User.Disabled}
+		return []item{{user: &u}}, nil
+	default:
+		return nil, fmt.Errorf("unknown record type %q", rec.Type)
+	}
+}
+
+// legacyComic - комикс из файла базы ранних версий сервиса
+type legacyComic struct {
+	URL      string   `json:"url"`
+	Keywords []string `json:"keywords"`
+}
+
+func parseLegacy(raw map[string]json.RawMessage) ([]item, error) {
+	var items []item
+	for key, data := range raw {
+		num, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid comic number %q", key)
+		}
+
+		var legacy legacyComic
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("comic %d: %w", num, err)
+		}
+
+		// исходного текста в старом формате нет, поэтому ключевые слова остаются как есть
+		comic := core.Comic{ID: num, URL: legacy.URL, Keywords: strings.Join(legacy.Keywords, ",")}
+		if err := validateComic(comic); err != nil {
+			return nil, err
+		}
+		items = append(items, item{comic: &comic})
+	}
+	return items, nil
+}
+
+func validateComic(comic core.Comic) error {
+	if comic.ID <= 0 {
+		return fmt.Errorf("invalid comic number %d", comic.ID)
+	}
+	u, err := url.Parse(comic.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("comic %d: invalid url %q", comic.ID, comic.URL)
+	}
+	if comic.NormVersion < 0 || comic.NormVersion > words.Version {
+		return fmt.Errorf("comic %d: invalid normalizer version %d", comic.ID, comic.NormVersion)
+	}
+	return nil
+}
+
+func validateUser(u user) error {
+	if u.Username == "" {
+		return errors.New("user without username")
+	}
+	if !core.ValidRoleName(u.Role) {
+		return fmt.Errorf("user %q: %w %q", u.Username, core.ErrUnknownRole, u.Role)
+	}
+	if u.Password != "" {
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			return fmt.Errorf("user %q: password is not a bcrypt hash", u.Username)
+		}
+	}
+	return nil
+}
+
+// save сохраняет запись; пользователя без хеша пароля, которого нет в базе, создать нельзя - он пропускается
+func save(ctx context.Context, st Storage, it item) (skipped error, err error) {
+	if it.comic != nil {
+		comic := *it.comic
+		comic.Terms = xkcd.ComicTerms(comic)
+		return nil, st.UpsertComic(ctx, comic)
+	}
+
+	// роли в выгрузку не попадают, поэтому роль пользователя должна уже быть в базе
+	if _, err := st.GetRole(ctx, it.user.Role); errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %q: %w %q", it.user.Username, core.ErrUnknownRole, it.user.Role)
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = st.UpsertUser(ctx, *it.user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %q does not exist and the dump has no password hash", it.user.Username), nil
+	}
+	return nil, err
+}
@@ -0,0 +1,124 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: dump.go
+
+// Package mock_dump is a generated GoMock package.
+package mock_dump
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	core "github.com/sgsoul/internal/core"
+)
+
+// MockStorage is a mock of Storage interface.
+type MockStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockStorageMockRecorder
+}
+
+// MockStorageMockRecorder is the mock recorder for MockStorage.
+type MockStorageMockRecorder struct {
+	mock *MockStorage
+}
+
+// NewMockStorage creates a new mock instance.
+func NewMockStorage(ctrl *gomock.Controller) *MockStorage {
+	mock := &MockStorage{ctrl: ctrl}
+	mock.recorder = &MockStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
+	return m.recorder
+}
+
+// GetComicsAfter mocks base method.
+func (m *MockStorage) GetComicsAfter(ctx context.Context, afterID, limit int) ([]core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicsAfter", ctx, afterID, limit)
+	ret0, _ := ret[0].([]core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicsAfter indicates an expected call of GetComicsAfter.
+func (mr *MockStorageMockRecorder) GetComicsAfter(ctx, afterID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicsAfter", reflect.TypeOf((*MockStorage)(nil).GetComicsAfter), ctx, afterID, limit)
+}
+
+// GetCount mocks base method.
+func (m *MockStorage) GetCount(ctx context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCount", ctx)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCount indicates an expected call of GetCount.
+func (mr *MockStorageMockRecorder) GetCount(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCount", reflect.TypeOf((*MockStorage)(nil).GetCount), ctx)
+}
+
+// GetRole mocks base method.
+func (m *MockStorage) GetRole(ctx context.Context, name string) (core.Role, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRole", ctx, name)
+	ret0, _ := ret[0].(core.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRole indicates an expected call of GetRole.
+func (mr *MockStorageMockRecorder) GetRole(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRole", reflect.TypeOf((*MockStorage)(nil).GetRole), ctx, name)
+}
+
+// ListUsers mocks base method.
+func (m *MockStorage) ListUsers(ctx context.Context, limit, offset int) ([]core.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsers", ctx, limit, offset)
+	ret0, _ := ret[0].([]core.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsers indicates an expected call of ListUsers.
+func (mr *MockStorageMockRecorder) ListUsers(ctx, limit, offset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockStorage)(nil).ListUsers), ctx, limit, offset)
+}
+
+// UpsertComic mocks base method.
+func (m *MockStorage) UpsertComic(ctx context.Context, comic core.Comic) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertComic", ctx, comic)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertComic indicates an expected call of UpsertComic.
+func (mr *MockStorageMockRecorder) UpsertComic(ctx, comic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertComic", reflect.TypeOf((*MockStorage)(nil).UpsertComic), ctx, comic)
+}
+
+// UpsertUser mocks base method.
+func (m *MockStorage) UpsertUser(ctx context.Context, user core.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertUser", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertUser indicates an expected call of UpsertUser.
+func (mr *MockStorageMockRecorder) UpsertUser(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertUser", reflect.TypeOf((*MockStorage)(nil).UpsertUser), ctx, user)
+}
@@ -0,0 +1,186 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+)
+
+//go:generate mockgen -source=mirror.go -destination=mocks/mock.go
+
+const (
+	defaultThumbWidth = 200
+	// картинки xkcd редко больше нескольких мегабайт
+	maxImageSize = 20 << 20
+	// failedRetry - сколько Open не пытается снова скачать картинку после неудачи
+	failedRetry = 10 * time.Minute
+	// downloadTimeout - предел скачивания одной картинки, чтобы зависший сервер не держал обработчик
+	downloadTimeout = 30 * time.Second
+)
+
+var (
+	ErrNoImage       = errors.New("comic has no mirrored image")
+	ErrImageTooLarge = errors.New("image is too large")
+)
+
+type Storage interface {
+	GetComicByID(ctx context.Context, id int) (core.Comic, error)
+	GetComicImage(ctx context.Context, id int) (core.ComicImage, error)
+	SaveComicImage(ctx context.Context, image core.ComicImage) error
+}
+
+// Mirror скачивает картинки комиксов в локальное хранилище и отдает их вместе с миниатюрами
+type Mirror struct {
+	store      *Store
+	storage    Storage
+	client     *http.Client
+	thumbWidth int
+
+	mu sync.Mutex
+	// failed - неудачные скачивания по запросу: номер комикса -> адрес картинки и время следующей попытки
+	failed map[int]failedImage
+}
+
+type failedImage struct {
+	url   string
+	retry time.Time
+	err   error
+}
+
+func NewMirror(store *Store, st Storage, thumbWidth int) *Mirror {
+	if thumbWidth <= 0 {
+		thumbWidth = defaultThumbWidth
+	}
+	return &Mirror{
+		store:      store,
+		storage:    st,
+		client:     &http.Client{Timeout: downloadTimeout},
+		thumbWidth: thumbWidth,
+		failed:     make(map[int]failedImage),
+	}
+}
+
+// MirrorImage скачивает картинку по url, сохраняет ее и миниатюру и привязывает к комиксу
+func (m *Mirror) MirrorImage(ctx context.Context, comicID int, url string) (core.ComicImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return core.ComicImage{}, fmt.Errorf("image of comic %d: unexpected status %s", comicID, resp.Status)
+	}
+
+	if resp.ContentLength > maxImageSize {
+		return core.ComicImage{}, fmt.Errorf("image of comic %d: %w", comicID, ErrImageTooLarge)
+	}
+	// читаем на байт больше предела, чтобы отличить слишком большую картинку от обрезанной
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+	if len(data) > maxImageSize {
+		return core.ComicImage{}, fmt.Errorf("image of comic %d: %w", comicID, ErrImageTooLarge)
+	}
+
+	// миниатюра заодно проверяет, что по адресу действительно картинка
+	thumb, err := Thumbnail(data, m.thumbWidth)
+	if err != nil {
+		return core.ComicImage{}, fmt.Errorf("thumbnail of comic %d: %w", comicID, err)
+	}
+
+	contentType := http.DetectContentType(data)
+	hash, err := m.store.Save(data)
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+	thumbHash, err := m.store.Save(thumb)
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+
+	image := core.ComicImage{
+		ComicID:     comicID,
+		SourceURL:   url,
+		Hash:        hash,
+		ContentType: contentType,
+		ThumbHash:   thumbHash,
+	}
+	if err := m.storage.SaveComicImage(ctx, image); err != nil {
+		return core.ComicImage{}, err
+	}
+
+	log.Debug().Msgf("Mirrored image of comic %d", comicID)
+	return image, nil
+}
+
+// Open возвращает картинку комикса (или ее миниатюру), при необходимости скачивая ее.
+// Для миниатюры Hash и ContentType в возвращаемой структуре описывают саму миниатюру.
+// Картинки скрытых комиксов не отдаются
+func (m *Mirror) Open(ctx context.Context, comicID int, thumb bool) (*os.File, core.ComicImage, error) {
+	comic, err := m.storage.GetComicByID(ctx, comicID)
+	if err != nil {
+		return nil, core.ComicImage{}, err
+	}
+	if comic.Hidden {
+		return nil, core.ComicImage{}, fmt.Errorf("%w: comic %d is hidden", ErrNoImage, comicID)
+	}
+
+	image, err := m.storage.GetComicImage(ctx, comicID)
+	if err != nil {
+		// картинки комиксов, загруженных до появления зеркала, скачиваются при первом запросе
+		image, err = m.mirrorOnDemand(ctx, comicID, comic.URL)
+		if err != nil {
+			return nil, core.ComicImage{}, fmt.Errorf("%w: %v", ErrNoImage, err)
+		}
+	}
+
+	if thumb && image.ThumbHash != image.Hash {
+		image.Hash = image.ThumbHash
+		image.ContentType = "image/png"
+	}
+
+	file, err := m.store.Open(image.Hash)
+	if err != nil {
+		return nil, core.ComicImage{}, err
+	}
+	return file, image, nil
+}
+
+// mirrorOnDemand скачивает картинку для Open. Неудача запоминается на failedRetry,
+// чтобы повторные запросы к битой картинке не ходили каждый раз на сервер xkcd
+func (m *Mirror) mirrorOnDemand(ctx context.Context, comicID int, url string) (core.ComicImage, error) {
+	now := time.Now()
+	m.mu.Lock()
+	failed, ok := m.failed[comicID]
+	if ok && (failed.url != url || !now.Before(failed.retry)) {
+		delete(m.failed, comicID)
+		ok = false
+	}
+	m.mu.Unlock()
+	if ok {
+		return core.ComicImage{}, failed.err
+	}
+
+	image, err := m.MirrorImage(ctx, comicID, url)
+	// отмена запроса клиентом ничего не говорит о самой картинке
+	if err != nil && ctx.Err() == nil {
+		m.mu.Lock()
+		m.failed[comicID] = failedImage{url: url, retry: now.Add(failedRetry), err: err}
+		m.mu.Unlock()
+	}
+	return image, err
+}
@@ -0,0 +1,165 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sgsoul/internal/core"
+	mocks "github.com/sgsoul/internal/images/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.Black)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	hash, err := store.Save([]byte("comic"))
+	assert.NoError(t, err)
+
+	again, err := store.Save([]byte("comic"))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	file, err := store.Open(hash)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "comic", string(data))
+
+	_, err = store.Open("../../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestThumbnail(t *testing.T) {
+	thumb, err := Thumbnail(testPNG(t, 800, 400), 200)
+	assert.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(thumb))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+
+	small := testPNG(t, 100, 50)
+	thumb, err = Thumbnail(small, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, small, thumb)
+
+	_, err = Thumbnail([]byte("<html>interactive comic</html>"), 200)
+	assert.Error(t, err)
+}
+
+func TestMirrorOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	original := testPNG(t, 400, 200)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/comics/apple.png" {
+			_, _ = w.Write(original)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mirror := NewMirror(store, mockStorage, 100)
+
+	// картинки еще нет: она скачивается при первом запросе
+	mockStorage.EXPECT().GetComicImage(gomock.Any(), 1).Return(core.ComicImage{}, errors.New("no rows"))
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(core.Comic{ID: 1, URL: server.URL + "/comics/apple.png"}, nil).Times(2)
+	mockStorage.EXPECT().SaveComicImage(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, image core.ComicImage) error {
+		assert.Equal(t, 1, image.ComicID)
+		assert.Equal(t, "image/png", image.ContentType)
+		assert.NotEqual(t, image.Hash, image.ThumbHash)
+		return nil
+	})
+
+	file, image, err := mirror.Open(context.Background(), 1, false)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, original, data)
+
+	mockStorage.EXPECT().GetComicImage(gomock.Any(), 1).Return(image, nil)
+	file, thumb, err := mirror.Open(context.Background(), 1, true)
+	assert.NoError(t, err)
+	file.Close()
+	assert.NotEqual(t, image.Hash, thumb.Hash)
+
+	mockStorage.EXPECT().GetComicImage(gomock.Any(), 2).Return(core.ComicImage{}, errors.New("no rows")).Times(2)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 2).Return(core.Comic{ID: 2, URL: server.URL + "/2/"}, nil).Times(2)
+	_, _, err = mirror.Open(context.Background(), 2, false)
+	assert.ErrorIs(t, err, ErrNoImage)
+	// неудача запоминается, и повторный запрос не идет на сервер
+	_, _, err = mirror.Open(context.Background(), 2, false)
+	assert.ErrorIs(t, err, ErrNoImage)
+	assert.Equal(t, int64(2), requests.Load())
+
+	// картинка скрытого комикса не отдается, даже если уже скачана
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 3).Return(core.Comic{ID: 3, Hidden: true}, nil)
+	_, _, err = mirror.Open(context.Background(), 3, false)
+	assert.ErrorIs(t, err, ErrNoImage)
+}
+
+func TestMirrorImageTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	mirror := NewMirror(store, mocks.NewMockStorage(gomock.NewController(t)), 100)
+	mirror.client.Timeout = 50 * time.Millisecond
+
+	_, err = mirror.MirrorImage(context.Background(), 1, server.URL+"/slow.png")
+	assert.Error(t, err)
+}
+
+func TestMirrorImageTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// без Content-Length размер становится известен только при чтении
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(make([]byte, maxImageSize+1))
+	}))
+	defer server.Close()
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	mirror := NewMirror(store, mocks.NewMockStorage(gomock.NewController(t)), 100)
+
+	_, err = mirror.MirrorImage(context.Background(), 1, server.URL+"/big.png")
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+}
@@ -0,0 +1,80 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: mirror.go
+
+// Package mock_images is a generated GoMock package.
+package mock_images
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	core "github.com/sgsoul/internal/core"
+)
+
+// MockStorage is a mock of Storage interface.
+type MockStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockStorageMockRecorder
+}
+
+// MockStorageMockRecorder is the mock recorder for MockStorage.
+type MockStorageMockRecorder struct {
+	mock *MockStorage
+}
+
+// NewMockStorage creates a new mock instance.
+func NewMockStorage(ctrl *gomock.Controller) *MockStorage {
+	mock := &MockStorage{ctrl: ctrl}
+	mock.recorder = &MockStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
+	return m.recorder
+}
+
+// GetComicByID mocks base method.
+func (m *MockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicByID", ctx, id)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicByID indicates an expected call of GetComicByID.
+func (mr *MockStorageMockRecorder) GetComicByID(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), ctx, id)
+}
+
+// GetComicImage mocks base method.
+func (m *MockStorage) GetComicImage(ctx context.Context, id int) (core.ComicImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicImage", ctx, id)
+	ret0, _ := ret[0].(core.ComicImage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicImage indicates an expected call of GetComicImage.
+func (mr *MockStorageMockRecorder) GetComicImage(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicImage", reflect.TypeOf((*MockStorage)(nil).GetComicImage), ctx, id)
+}
+
+// SaveComicImage mocks base method.
+func (m *MockStorage) SaveComicImage(ctx context.Context, image core.ComicImage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveComicImage", ctx, image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveComicImage indicates an expected call of SaveComicImage.
+func (mr *MockStorageMockRecorder) SaveComicImage(ctx, image interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicImage", reflect.TypeOf((*MockStorage)(nil).SaveComicImage), ctx, image)
+}
@@ -0,0 +1,68 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store - локальное хранилище картинок, адресуемое хешем содержимого
+type Store struct {
+	root string
+}
+
+func NewStore(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{root: root}, nil
+}
+
+// Save записывает данные под их sha256 и возвращает хеш. Повторное сохранение
+// тех же данных ничего не делает
+func (s *Store) Save(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	path := s.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	// пишем во временный файл, чтобы читатели не увидели файл наполовину
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (s *Store) Open(hash string) (*os.File, error) {
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid image hash %q", hash)
+	}
+	return os.Open(s.path(hash))
+}
+
+// path раскладывает файлы по подкаталогам из первых двух символов хеша
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash)
+}
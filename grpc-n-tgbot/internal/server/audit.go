@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+)
+
+// auditTimeout ограничивает запись в журнал, когда дедлайн запроса уже истек
+const auditTimeout = 5 * time.Second
+
+type auditKey struct{}
+
+// audited пишет в журнал аудита запросы, меняющие состояние; GET и HEAD не пишутся.
+// Обработчик уточняет запись через auditRecord: кто действует, над чем и с какими параметрами
+func (s *Server) audited(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		record := &core.AuditRecord{
+			IP:        clientIP(r),
+			Action:    action,
+			Details:   r.URL.RawQuery,
+			CreatedAt: time.Now(),
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, record)))
+		record.Status = recorder.status
+
+		// запрос мог завершиться по дедлайну, но запись в журнал терять нельзя
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), auditTimeout)
+		defer cancel()
+		if err := s.service.SaveAuditRecord(ctx, *record); err != nil {
+			log.Error().Err(err).Msgf("error saving audit record %s by %q", record.Action, record.Actor)
+		}
+	}
+}
+
+// auditRecord возвращает запись аудита текущего запроса; вне audited изменения никуда не попадут
+func auditRecord(r *http.Request) *core.AuditRecord {
+	if record, ok := r.Context().Value(auditKey{}).(*core.AuditRecord); ok {
+		return record
+	}
+	return &core.AuditRecord{}
+}
+
+// clientIP - адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder запоминает код ответа обработчика
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// handleAudit - просмотр журнала: GET /audit?actor=&ip=&action=&target=&since=&until=&failed=true
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := s.service.AuditLog(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// parseAuditFilter читает фильтры журнала; since и until в формате RFC 3339
+func parseAuditFilter(r *http.Request) (core.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := core.AuditFilter{
+		Actor:  query.Get("actor"),
+		IP:     query.Get("ip"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Failed: query.Get("failed") == "true",
+	}
+
+	var err error
+	filter.Limit, filter.Offset, err = parsePage(r)
+	if err != nil {
+		return core.AuditFilter{}, err
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+			return core.AuditFilter{}, fmt.Errorf("invalid %s %q", name, v)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudited(t *testing.T) {
+	s, mockService := newTestServer(t)
+
+	var saved []core.AuditRecord
+	mockService.EXPECT().SaveAuditRecord(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, record core.AuditRecord) error {
+		saved = append(saved, record)
+		return nil
+	}).Times(2)
+
+	handler := s.audited(core.AuditUpdate, s.authorized(core.PermComicsIngest, func(w http.ResponseWriter, r *http.Request) {
+		auditRecord(r).Target = "comics"
+	}))
+
+	r := newRequest(http.MethodPost, "/update?mode=resync", "admin", "")
+	r.RemoteAddr = "192.0.2.1:1234"
+	handler(httptest.NewRecorder(), r)
+	handler(httptest.NewRecorder(), newRequest(http.MethodPost, "/update", "user1", ""))
+	// чтение не пишется в журнал
+	handler(httptest.NewRecorder(), newRequest(http.MethodGet, "/update", "admin", ""))
+
+	assert.Len(t, saved, 2)
+	assert.Equal(t, "admin", saved[0].Actor)
+	assert.Equal(t, "192.0.2.1", saved[0].IP)
+	assert.Equal(t, core.AuditUpdate, saved[0].Action)
+	assert.Equal(t, "comics", saved[0].Target)
+	assert.Equal(t, "mode=resync", saved[0].Details)
+	assert.Equal(t, http.StatusOK, saved[0].Status)
+	assert.Equal(t, "user1", saved[1].Actor)
+	assert.Equal(t, http.StatusForbidden, saved[1].Status)
+}
+
+func TestAuditedLogin(t *testing.T) {
+	s, mockService := newTestServer(t)
+
+	mockService.EXPECT().SaveAuditRecord(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, record core.AuditRecord) error {
+		assert.Equal(t, core.AuditLogin, record.Action)
+		assert.Equal(t, "user1", record.Actor)
+		assert.Equal(t, http.StatusUnauthorized, record.Status)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	s.audited(core.AuditLogin, s.handleLogin)(w, newRequest(http.MethodPost, "/login", "", `{"username":"user1","password":"wrong"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleAudit(t *testing.T) {
+	s, mockService := newTestServer(t)
+	handleAudit := s.authorized(core.PermAuditRead, s.handleAudit)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := core.AuditFilter{Actor: "admin", Action: core.AuditRegister, Since: since, Failed: true, Limit: 20}
+	mockService.EXPECT().AuditLog(gomock.Any(), filter).Return([]core.AuditRecord{{ID: 1, Actor: "admin", Action: core.AuditRegister, Target: "root"}}, nil)
+
+	w := httptest.NewRecorder()
+	handleAudit(w, newRequest(http.MethodGet, "/audit?actor=admin&action=register&since=2024-01-01T00:00:00Z&failed=true", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"target":"root"`)
+
+	w = httptest.NewRecorder()
+	handleAudit(w, newRequest(http.MethodGet, "/audit?since=yesterday", "admin", ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleAudit(w, newRequest(http.MethodGet, "/audit", "user1", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errNoToken          = errors.New("no token provided")
+	errInvalidAuthority = errors.New("invalid authorization header")
+)
+
+type principalKey struct{}
+
+// principal - пользователь, от имени которого выполняется запрос
+type principal struct {
+	Username    string
+	Role        string
+	Permissions []string
+	// Token передается сервису авторизации, когда действие выполняется от имени пользователя
+	Token string
+}
+
+// can сообщает, есть ли у пользователя право permission
+func (p *principal) can(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// principalFrom возвращает пользователя запроса; ok ложно для анонимного запроса
+func principalFrom(r *http.Request) (p *principal, ok bool) {
+	p, ok = r.Context().Value(principalKey{}).(*principal)
+	return p, ok
+}
+
+// requestToken достает токен или ключ API из заголовка Authorization: Bearer, а без заголовка - из cookie token.
+// Заголовок нужен клиентам API, боту и автоматизации, cookie - браузеру
+func requestToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", errInvalidAuthority
+		}
+		return strings.TrimSpace(token), nil
+	}
+	if cookie, err := r.Cookie("token"); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	return "", errNoToken
+}
+
+// authenticate проверяет токен или ключ API запроса и возвращает запрос с пользователем в контексте
+func (s *Server) authenticate(r *http.Request) (*http.Request, error) {
+	token, err := requestToken(r)
+	if err != nil {
+		return r, err
+	}
+	validate := s.authClient.ValidateJWT
+	if auth.IsAPIKey(token) {
+		validate = s.authClient.ValidateAPIKey
+	}
+	claims, err := validate(token)
+	if err != nil {
+		return r, err
+	}
+
+	p := &principal{Username: claims.Username, Role: claims.Role, Permissions: claims.Permissions, Token: token}
+	auditRecord(r).Actor = p.Username
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), nil
+}
+
+// authenticated пропускает только запросы с действующим токеном: без токена или с негодным отвечает 401
+func (s *Server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, err := s.authenticate(r)
+		switch {
+		case err == nil:
+			handler(w, r)
+		case status.Code(err) == codes.Unavailable:
+			// сервис авторизации недоступен - это не повод выходить из системы
+			writeError(w, err)
+		case errors.Is(err, errNoToken), errors.Is(err, errInvalidAuthority):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+		}
+	}
+}
+
+// optionalAuth кладет пользователя в контекст, если токен действует; иначе запрос обрабатывается как анонимный
+func (s *Server) optionalAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, err := s.authenticate(r)
+		if err != nil && !errors.Is(err, errNoToken) {
+			log.Debug().Err(err).Str("path", r.URL.Path).Msg("ignoring invalid token")
+		}
+		handler(w, r)
+	}
+}
+
+// authorized пропускает запрос, только если у пользователя есть право permission: без токена 401, без права 403
+func (s *Server) authorized(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return s.authenticated(func(w http.ResponseWriter, r *http.Request) {
+		if p, _ := principalFrom(r); !p.can(permission) {
+			http.Error(w, "forbidden. permission "+permission+" required", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
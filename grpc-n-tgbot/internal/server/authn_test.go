@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// whoami отвечает именем пользователя из контекста или anonymous
+func whoami(w http.ResponseWriter, r *http.Request) {
+	if p, ok := principalFrom(r); ok {
+		w.Write([]byte(p.Username))
+		return
+	}
+	w.Write([]byte("anonymous"))
+}
+
+func bearer(r *http.Request, header string) *http.Request {
+	r.Header.Set("Authorization", header)
+	return r
+}
+
+func TestAuthenticated(t *testing.T) {
+	s, _ := newTestServer(t)
+	handler := s.authenticated(whoami)
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+		body string
+	}{
+		{"bearer", bearer(newRequest(http.MethodGet, "/", "", ""), "Bearer user1"), http.StatusOK, "user1"},
+		{"cookie", newRequest(http.MethodGet, "/", "user1", ""), http.StatusOK, "user1"},
+		// заголовок важнее cookie
+		{"bearer and cookie", bearer(newRequest(http.MethodGet, "/", "user1", ""), "Bearer admin"), http.StatusOK, "admin"},
+		{"no token", newRequest(http.MethodGet, "/", "", ""), http.StatusUnauthorized, "no token provided"},
+		{"invalid token", bearer(newRequest(http.MethodGet, "/", "", ""), "Bearer invalid"), http.StatusUnauthorized, "invalid token"},
+		{"basic auth", bearer(newRequest(http.MethodGet, "/", "user1", ""), "Basic dXNlcjE6cGFzc3dvcmQ="), http.StatusUnauthorized, "invalid authorization header"},
+		{"empty bearer", bearer(newRequest(http.MethodGet, "/", "", ""), "Bearer "), http.StatusUnauthorized, "invalid authorization header"},
+		{"auth unavailable", bearer(newRequest(http.MethodGet, "/", "", ""), "Bearer unavailable"), http.StatusServiceUnavailable, "try again later"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, tt.r)
+			assert.Equal(t, tt.code, w.Code)
+			assert.Contains(t, w.Body.String(), tt.body)
+			if tt.code == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+	handler := s.optionalAuth(whoami)
+
+	w := httptest.NewRecorder()
+	handler(w, bearer(newRequest(http.MethodGet, "/", "", ""), "Bearer user1"))
+	assert.Equal(t, "user1", w.Body.String())
+
+	// негодный токен не мешает анонимному запросу
+	w = httptest.NewRecorder()
+	handler(w, newRequest(http.MethodGet, "/", "invalid", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+}
+
+func TestAuthorized(t *testing.T) {
+	s, _ := newTestServer(t)
+	handler := s.authorized(core.PermSearchRead, whoami)
+
+	// бот и веб-интерфейс ищут комиксы с токеном в заголовке
+	w := httptest.NewRecorder()
+	handler(w, bearer(newRequest(http.MethodGet, "/pics?search=apple", "", ""), "Bearer user1"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, newRequest(http.MethodGet, "/pics?search=apple", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	s.authorized(core.PermUsersManage, whoami)(w, bearer(newRequest(http.MethodGet, "/users", "", ""), "Bearer user1"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	// планировщик запускает обновление по ключу API, но искать комиксы ключу не разрешено
+	w := httptest.NewRecorder()
+	s.authorized(core.PermComicsIngest, whoami)(w, bearer(newRequest(http.MethodPost, "/update", "", ""), "Bearer xkcd_ci"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ci", w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.authorized(core.PermSearchRead, whoami)(w, bearer(newRequest(http.MethodGet, "/pics?search=apple", "", ""), "Bearer xkcd_ci"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	s.authorized(core.PermComicsIngest, whoami)(w, bearer(newRequest(http.MethodPost, "/update", "", ""), "Bearer xkcd_revoked"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -2,73 +2,340 @@ package server
 
 import (
 	"context"
-	"net/http"
+	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/sgsoul/internal/auth"
+	"github.com/sgsoul/internal/core"
 	pb "github.com/sgsoul/internal/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+const (
+	defaultAuthAddress = "localhost:50051"
+	defaultAuthTimeout = time.Second
+	// rotateTimeout - выпуск ключа RSA может занять больше обычного дедлайна
+	rotateTimeout = 5 * time.Second
+	// maxAuthAttempts - больше попыток gRPC все равно не делает
+	maxAuthAttempts = 5
+	// validationTTL - сколько действует ответ сервиса о том, что токен не отозван; отзыв в другом экземпляре
+	// сервера вступает в силу не позже этого
+	validationTTL = 5 * time.Second
+	// staleValidationTTL - сколько последний ответ сервиса о токене с проверенной на месте подписью
+	// принимается, пока сервис недоступен; отзыв за это время не виден
+	staleValidationTTL = 10 * time.Minute
+	// sweepValidations - с этого размера кеш проверок чистится от устаревших записей
+	sweepValidations = 1024
+)
+
+// retryMethods - запросы, которые безопасно повторить. RefreshToken не повторяется: если первая попытка
+// дошла до сервиса, повтор выглядит как кража токена и отзывает всю цепочку
+var retryMethods = []string{"ValidateJWT", "ValidateAPIKey", "ListAPIKeys", "ListLoginLocks", "GetPublicKeys", "Login", "RevokeToken", "RevokeAllForUser"}
+
 type AuthClient struct {
 	client pb.AuthServiceClient
+	// verifier проверяет токены асимметричных ключей без обращения к сервису; nil - всегда через сервис
+	verifier *auth.Verifier
+	timeout  time.Duration
+
+	mu sync.Mutex
+	// validated - недавние ответы сервиса на ValidateJWT по токену
+	validated map[string]validation
+}
+
+type validation struct {
+	res   *pb.ValidateJWTResponse
+	until time.Time
+	// stale - до этого момента ответ заменяет сервис, если тот недоступен
+	stale time.Time
 }
 
-func NewAuthClient(address string) (*AuthClient, error) {
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+// NewAuthClient подключается к сервису авторизации с адресом, TLS, дедлайном и повторами из cfg
+func NewAuthClient(cfg *core.Config) (*AuthClient, error) {
+	address := cfg.AuthAddress
+	if address == "" {
+		address = defaultAuthAddress
+	}
+	timeout := time.Duration(cfg.AuthTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+
+	creds, err := auth.ClientCredentials(cfg.AuthClientTLS)
+	if err != nil {
+		return nil, err
+	}
+	serviceConfig, err := retryConfig(cfg.AuthRetries)
 	if err != nil {
 		return nil, err
 	}
-	client := pb.NewAuthServiceClient(conn)
-	return &AuthClient{client: client}, nil
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds), grpc.WithDefaultServiceConfig(serviceConfig))
+	if err != nil {
+		return nil, err
+	}
+	a := &AuthClient{client: pb.NewAuthServiceClient(conn), timeout: timeout}
+	a.verifier = auth.NewVerifier(a.publicKeys)
+	return a, nil
 }
 
-func (a *AuthClient) GenerateJWT(username, role string, expiryMinutes int) (string, error) {
-	var expiryMinutesInt32 int32 = int32(expiryMinutes)
+// retryConfig - service config gRPC, повторяющий retryMethods при codes.Unavailable с растущей паузой
+func retryConfig(retries int) (string, error) {
+	if retries <= 0 {
+		return "{}", nil
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	type method struct {
+		Service string `json:"service"`
+		Method  string `json:"method"`
+	}
+	names := make([]method, 0, len(retryMethods))
+	for _, m := range retryMethods {
+		names = append(names, method{Service: pb.AuthService_ServiceDesc.ServiceName, Method: m})
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"methodConfig": []interface{}{map[string]interface{}{
+			"name": names,
+			"retryPolicy": map[string]interface{}{
+				"maxAttempts":          min(retries+1, maxAuthAttempts),
+				"initialBackoff":       "0.1s",
+				"maxBackoff":           "1s",
+				"backoffMultiplier":    2,
+				"retryableStatusCodes": []string{"UNAVAILABLE"},
+			},
+		}},
+	})
+	return string(data), err
+}
+
+func (a *AuthClient) publicKeys(ctx context.Context) ([]*pb.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
 	defer cancel()
 
-	req := &pb.GenerateJWTRequest{
-		Username:     username,
-		Role:         role,
-		ExpiryMinutes: expiryMinutesInt32,
+	res, err := a.client.GetPublicKeys(ctx, &pb.GetPublicKeysRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Keys, nil
+}
+
+// JWKS возвращает открытые ключи сервиса авторизации в формате JWKS
+func (a *AuthClient) JWKS(ctx context.Context) (auth.JWKSet, error) {
+	var keys []*pb.PublicKey
+	var err error
+	if a.verifier != nil {
+		keys, err = a.verifier.PublicKeys(ctx)
+	} else {
+		keys, err = a.publicKeys(ctx)
 	}
-	res, err := a.client.GenerateJWT(ctx, req)
 	if err != nil {
-		return "", err
+		return auth.JWKSet{}, err
+	}
+	return auth.JWKS(keys)
+}
+
+// Login проверяет пароль в сервисе авторизации и возвращает access-токен на expiryMinutes минут и refresh-токен
+func (a *AuthClient) Login(ctx context.Context, username, password, ip string, expiryMinutes int) (*pb.GenerateJWTResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return a.client.Login(ctx, &pb.LoginRequest{Username: username, Password: password, Ip: ip, ExpiryMinutes: int32(expiryMinutes)})
+}
+
+// Register создает пользователя; token нужен только для назначения роли, отличной от user, может быть пустым
+func (a *AuthClient) Register(ctx context.Context, token, username, password, role string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
 	}
-	return res.Token, nil
+	_, err := a.client.Register(ctx, &pb.RegisterRequest{Username: username, Password: password, Role: role})
+	return err
+}
+
+// ChangePassword меняет пароль владельца token
+func (a *AuthClient) ChangePassword(ctx context.Context, token, oldPassword, newPassword string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err := a.client.ChangePassword(ctx, &pb.ChangePasswordRequest{OldPassword: oldPassword, NewPassword: newPassword})
+	return err
 }
 
+// RefreshToken обменивает refresh-токен на новую пару токенов
+func (a *AuthClient) RefreshToken(ctx context.Context, refreshToken string, expiryMinutes int) (*pb.GenerateJWTResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	return a.client.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: refreshToken, ExpiryMinutes: int32(expiryMinutes)})
+}
+
+// RevokeToken отзывает access-токен и refresh-токен; пустые значения пропускаются
+func (a *AuthClient) RevokeToken(ctx context.Context, token, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	_, err := a.client.RevokeToken(ctx, &pb.RevokeTokenRequest{Token: token, RefreshToken: refreshToken})
+	if err == nil {
+		a.forget(func(t string, _ validation) bool { return t == token })
+	}
+	return err
+}
+
+// RevokeAllForUser отзывает все токены username от имени владельца token
+func (a *AuthClient) RevokeAllForUser(ctx context.Context, token, username string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err := a.client.RevokeAllForUser(ctx, &pb.RevokeAllForUserRequest{Username: username})
+	if err == nil {
+		a.forget(func(_ string, v validation) bool { return v.res.Username == username })
+	}
+	return err
+}
+
+// ValidateJWT проверяет подпись токена на месте по открытым ключам, а отзыв - через сервис авторизации,
+// который видит выход, отзыв всех токенов пользователя, блокировку и смену роли. Ответ сервиса кешируется
+// на validationTTL. Пока сервис недоступен, токен с проверенной на месте подписью принимается по последнему
+// ответу сервиса в пределах staleValidationTTL, а новые токены не принимаются: проверка отзыва требует сервиса.
+// Токены ключей HS256 и проверяются, и сверяются с отзывами только в сервисе
 func (a *AuthClient) ValidateJWT(token string) (*pb.ValidateJWTResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
 	defer cancel()
 
-	req := &pb.ValidateJWTRequest{Token: token}
-	res, err := a.client.ValidateJWT(ctx, req)
+	verified := false
+	if a.verifier != nil {
+		// негодная подпись отсекается без обращения к сервису
+		_, err := a.verifier.Verify(ctx, token)
+		if err != nil && !errors.Is(err, auth.ErrUnknownKey) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		verified = err == nil
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.validated[token]
+	a.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		return cached.res, nil
+	}
+
+	res, err := a.client.ValidateJWT(ctx, &pb.ValidateJWTRequest{Token: token})
+	if code := status.Code(err); (code == codes.Unavailable || code == codes.DeadlineExceeded) && verified && ok && now.Before(cached.stale) {
+		return cached.res, nil
+	}
 	if err != nil {
+		if ok {
+			// отвергнутый сервисом токен не должен пережить его следующий отказ
+			a.forget(func(t string, _ validation) bool { return t == token })
+		}
 		return nil, err
 	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.validated == nil {
+		a.validated = map[string]validation{}
+	}
+	if len(a.validated) >= sweepValidations {
+		for t, v := range a.validated {
+			if !now.Before(v.stale) {
+				delete(a.validated, t)
+			}
+		}
+	}
+	a.validated[token] = validation{res: res, until: now.Add(validationTTL), stale: now.Add(staleValidationTTL)}
 	return res, nil
 }
 
-func (a *AuthClient) IsAdmin(w http.ResponseWriter, r *http.Request) bool {
-	tokenCookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "no token provided", http.StatusUnauthorized)
-		return false
+// forget убирает из кеша проверок токены, для которых match истинно, чтобы отзыв через этот сервер действовал сразу
+func (a *AuthClient) forget(match func(token string, v validation) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for t, v := range a.validated {
+		if match(t, v) {
+			delete(a.validated, t)
+		}
 	}
+}
+
+// ListLoginLocks возвращает действующие блокировки входа от имени владельца token
+func (a *AuthClient) ListLoginLocks(ctx context.Context, token string) ([]*pb.LoginLock, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
 
-	claims, err := a.ValidateJWT(tokenCookie.Value)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	res, err := a.client.ListLoginLocks(ctx, &pb.ListLoginLocksRequest{})
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
-		return false
+		return nil, err
 	}
+	return res.Locks, nil
+}
 
-	if claims.Role != "admin" {
-		return false
+// UnlockLogin снимает блокировку входа с пользователя username или адреса ip от имени владельца token
+func (a *AuthClient) UnlockLogin(ctx context.Context, token, username, ip string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err := a.client.UnlockLogin(ctx, &pb.UnlockLoginRequest{Username: username, Ip: ip})
+	return err
+}
+
+// ValidateAPIKey проверяет ключ API; ключи отзываются и меняют права в любой момент, поэтому всегда через сервис
+func (a *AuthClient) ValidateAPIKey(key string) (*pb.ValidateJWTResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	return a.client.ValidateAPIKey(ctx, &pb.ValidateAPIKeyRequest{Key: key})
+}
+
+// CreateAPIKey выпускает ключ API от имени владельца token
+func (a *AuthClient) CreateAPIKey(ctx context.Context, token string, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	return a.client.CreateAPIKey(ctx, req)
+}
+
+// ListAPIKeys возвращает ключи username, а с пустым username - все ключи
+func (a *AuthClient) ListAPIKeys(ctx context.Context, token, username string) ([]*pb.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	res, err := a.client.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{Username: username})
+	if err != nil {
+		return nil, err
 	}
+	return res.Keys, nil
+}
 
-	return true
+// RevokeAPIKey отзывает ключ API по id от имени владельца token
+func (a *AuthClient) RevokeAPIKey(ctx context.Context, token, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err := a.client.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{Id: id})
+	return err
 }
 
+// RotateKeys просит сервис авторизации выпустить новый ключ подписи от имени владельца token
+func (a *AuthClient) RotateKeys(ctx context.Context, token string, retire []string) (*pb.RotateKeysResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, max(a.timeout, rotateTimeout))
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	return a.client.RotateKeys(ctx, &pb.RotateKeysRequest{Retire: retire})
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sgsoul/internal/auth"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyAuth отвечает Unavailable на первый вызов каждого метода
+type flakyAuth struct {
+	pb.UnimplementedAuthServiceServer
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyAuth) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	if f.calls[method] == 1 {
+		return status.Error(codes.Unavailable, "try again")
+	}
+	return nil
+}
+
+func (f *flakyAuth) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *flakyAuth) GetPublicKeys(context.Context, *pb.GetPublicKeysRequest) (*pb.GetPublicKeysResponse, error) {
+	if err := f.call("GetPublicKeys"); err != nil {
+		return nil, err
+	}
+	return &pb.GetPublicKeysResponse{}, nil
+}
+
+func (f *flakyAuth) ValidateJWT(context.Context, *pb.ValidateJWTRequest) (*pb.ValidateJWTResponse, error) {
+	if err := f.call("ValidateJWT"); err != nil {
+		return nil, err
+	}
+	return &pb.ValidateJWTResponse{Valid: true, Username: "user1", Role: "user"}, nil
+}
+
+func (f *flakyAuth) RefreshToken(context.Context, *pb.RefreshTokenRequest) (*pb.GenerateJWTResponse, error) {
+	if err := f.call("RefreshToken"); err != nil {
+		return nil, err
+	}
+	return &pb.GenerateJWTResponse{}, nil
+}
+
+func newFlakyClient(t *testing.T, retries int) (*AuthClient, *flakyAuth) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyAuth{calls: map[string]int{}}
+	s := grpc.NewServer()
+	pb.RegisterAuthServiceServer(s, flaky)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	client, err := NewAuthClient(&core.Config{
+		AuthAddress:   lis.Addr().String(),
+		AuthClientTLS: core.TLSConfig{Insecure: true},
+		AuthRetries:   retries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, flaky
+}
+
+// hsToken - токен ключа, которого нет среди открытых, поэтому проверяется через сервис
+func hsToken(t *testing.T) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "user1"})
+	token.Header["kid"] = "hs"
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthClientRetry(t *testing.T) {
+	client, flaky := newFlakyClient(t, 2)
+
+	claims, err := client.ValidateJWT(hsToken(t))
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", claims.Username)
+	assert.Equal(t, 2, flaky.count("ValidateJWT"))
+
+	// повтор refresh-токена отозвал бы всю цепочку
+	_, err = client.RefreshToken(context.Background(), "refresh", 15)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 1, flaky.count("RefreshToken"))
+}
+
+func TestAuthClientNoRetry(t *testing.T) {
+	client, flaky := newFlakyClient(t, 0)
+
+	_, err := client.ValidateJWT(hsToken(t))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 1, flaky.count("ValidateJWT"))
+}
+
+func TestAuthClientInvalidToken(t *testing.T) {
+	client, flaky := newFlakyClient(t, 2)
+
+	_, err := client.ValidateJWT("not a token")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, 0, flaky.count("ValidateJWT"))
+}
+
+func TestAuthClientTimeout(t *testing.T) {
+	client, err := NewAuthClient(&core.Config{AuthClientTLS: core.TLSConfig{Insecure: true}, AuthTimeout: 250})
+	assert.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, client.timeout)
+
+	client, err = NewAuthClient(&core.Config{AuthClientTLS: core.TLSConfig{Insecure: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultAuthTimeout, client.timeout)
+}
+
+// revokingAuth отвечает на ValidateJWT с учетом отозванных токенов и считает запросы; с down отвечает Unavailable
+type revokingAuth struct {
+	pb.AuthServiceClient
+
+	mu      sync.Mutex
+	revoked map[string]bool
+	calls   int
+	down    bool
+}
+
+func (f *revokingAuth) ValidateJWT(_ context.Context, in *pb.ValidateJWTRequest, _ ...grpc.CallOption) (*pb.ValidateJWTResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.down {
+		return nil, status.Error(codes.Unavailable, "auth service is down")
+	}
+	if f.revoked[in.Token] {
+		return nil, status.Error(codes.Unauthenticated, "token revoked")
+	}
+	return &pb.ValidateJWTResponse{Valid: true, Username: "user1", Role: core.RoleUser}, nil
+}
+
+func (f *revokingAuth) RevokeToken(_ context.Context, in *pb.RevokeTokenRequest, _ ...grpc.CallOption) (*pb.RevokeTokenResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[in.Token] = true
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+func TestAuthClientRevocation(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	sign := func(id string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.StandardClaims{Id: id, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		token.Header["kid"] = "ed"
+		signed, err := token.SignedString(private)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	fake := &revokingAuth{revoked: map[string]bool{}}
+	client := &AuthClient{client: fake, timeout: time.Second}
+	client.verifier = auth.NewVerifier(func(context.Context) ([]*pb.PublicKey, error) {
+		return []*pb.PublicKey{{Kid: "ed", Alg: auth.AlgEdDSA, Key: der}}, nil
+	})
+
+	// подпись проверяется на месте, но отзыв - в сервисе, а его ответ кешируется
+	token := sign("1")
+	for i := 0; i < 2; i++ {
+		_, err = client.ValidateJWT(token)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, fake.calls)
+
+	// выход через этот сервер действует сразу
+	assert.NoError(t, client.RevokeToken(context.Background(), token, ""))
+	_, err = client.ValidateJWT(token)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, 2, fake.calls)
+
+	// отзыв в другом месте действует, как только истечет кеш
+	other := sign("2")
+	_, err = client.ValidateJWT(other)
+	assert.NoError(t, err)
+	fake.revoked[other] = true
+	_, err = client.ValidateJWT(other)
+	assert.NoError(t, err)
+	client.validated[other] = validation{res: client.validated[other].res, until: time.Now()}
+	_, err = client.ValidateJWT(other)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// поддельная подпись отвергается без запроса к сервису
+	calls := fake.calls
+	_, err = client.ValidateJWT(token[:len(token)-4] + "AAAA")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, calls, fake.calls)
+}
+
+func TestAuthClientUnavailable(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	sign := func(id string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.StandardClaims{Id: id, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		token.Header["kid"] = "ed"
+		signed, err := token.SignedString(private)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	fake := &revokingAuth{revoked: map[string]bool{}}
+	client := &AuthClient{client: fake, timeout: time.Second}
+	client.verifier = auth.NewVerifier(func(context.Context) ([]*pb.PublicKey, error) {
+		return []*pb.PublicKey{{Kid: "ed", Alg: auth.AlgEdDSA, Key: der}}, nil
+	})
+
+	known, revoked := sign("1"), sign("2")
+	for _, token := range []string{known, revoked} {
+		_, err = client.ValidateJWT(token)
+		assert.NoError(t, err)
+	}
+	fake.revoked[revoked] = true
+	for token, v := range client.validated {
+		client.validated[token] = validation{res: v.res, until: time.Now(), stale: v.stale}
+	}
+	_, err = client.ValidateJWT(revoked)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// пока сервис лежит, уже проверенный токен принимается по прошлому ответу, а новый - нет
+	fake.down = true
+	res, err := client.ValidateJWT(known)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", res.Username)
+	_, err = client.ValidateJWT(sign("3"))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	// отвергнутый сервисом токен не возвращается при его отказе
+	_, err = client.ValidateJWT(revoked)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// старый ответ не заменяет сервис бесконечно
+	client.validated[known] = validation{res: res, until: time.Now(), stale: time.Now()}
+	_, err = client.ValidateJWT(known)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,465 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: server.go
+
+// Package mock_server is a generated GoMock package.
+package mock_server
+
+import (
+	bytes "bytes"
+	context "context"
+	http "net/http"
+	os "os"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	core "github.com/sgsoul/internal/core"
+	rate "golang.org/x/time/rate"
+)
+
+// MockService is a mock of Service interface.
+type MockService struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceMockRecorder
+}
+
+// MockServiceMockRecorder is the mock recorder for MockService.
+type MockServiceMockRecorder struct {
+	mock *MockService
+}
+
+// NewMockService creates a new mock instance.
+func NewMockService(ctrl *gomock.Controller) *MockService {
+	mock := &MockService{ctrl: ctrl}
+	mock.recorder = &MockServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockService) EXPECT() *MockServiceMockRecorder {
+	return m.recorder
+}
+
+// AuditLog mocks base method.
+func (m *MockService) AuditLog(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuditLog", ctx, filter)
+	ret0, _ := ret[0].([]core.AuditRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuditLog indicates an expected call of AuditLog.
+func (mr *MockServiceMockRecorder) AuditLog(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuditLog", reflect.TypeOf((*MockService)(nil).AuditLog), ctx, filter)
+}
+
+// Decode mocks base method.
+func (m *MockService) Decode(w http.ResponseWriter, r *http.Request, v any) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Decode", w, r, v)
+}
+
+// Decode indicates an expected call of Decode.
+func (mr *MockServiceMockRecorder) Decode(w, r, v interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decode", reflect.TypeOf((*MockService)(nil).Decode), w, r, v)
+}
+
+// DeleteComic mocks base method.
+func (m *MockService) DeleteComic(ctx context.Context, num int, hard bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteComic", ctx, num, hard)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteComic indicates an expected call of DeleteComic.
+func (mr *MockServiceMockRecorder) DeleteComic(ctx, num, hard interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComic", reflect.TypeOf((*MockService)(nil).DeleteComic), ctx, num, hard)
+}
+
+// DeleteRole mocks base method.
+func (m *MockService) DeleteRole(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRole", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRole indicates an expected call of DeleteRole.
+func (mr *MockServiceMockRecorder) DeleteRole(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRole", reflect.TypeOf((*MockService)(nil).DeleteRole), ctx, name)
+}
+
+// DeleteUserService mocks base method.
+func (m *MockService) DeleteUserService(ctx context.Context, username string, hard bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUserService", ctx, username, hard)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUserService indicates an expected call of DeleteUserService.
+func (mr *MockServiceMockRecorder) DeleteUserService(ctx, username, hard interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserService", reflect.TypeOf((*MockService)(nil).DeleteUserService), ctx, username, hard)
+}
+
+// GetComic mocks base method.
+func (m *MockService) GetComic(ctx context.Context, num int) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComic", ctx, num)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComic indicates an expected call of GetComic.
+func (mr *MockServiceMockRecorder) GetComic(ctx, num interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComic", reflect.TypeOf((*MockService)(nil).GetComic), ctx, num)
+}
+
+// GetComicInfo mocks base method.
+func (m *MockService) GetComicInfo(ctx context.Context, num int) (core.ComicInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicInfo", ctx, num)
+	ret0, _ := ret[0].(core.ComicInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicInfo indicates an expected call of GetComicInfo.
+func (mr *MockServiceMockRecorder) GetComicInfo(ctx, num interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicInfo", reflect.TypeOf((*MockService)(nil).GetComicInfo), ctx, num)
+}
+
+// GetComicRevisionsService mocks base method.
+func (m *MockService) GetComicRevisionsService(ctx context.Context, id int) ([]core.ComicRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicRevisionsService", ctx, id)
+	ret0, _ := ret[0].([]core.ComicRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicRevisionsService indicates an expected call of GetComicRevisionsService.
+func (mr *MockServiceMockRecorder) GetComicRevisionsService(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicRevisionsService", reflect.TypeOf((*MockService)(nil).GetComicRevisionsService), ctx, id)
+}
+
+// GetRateLimiter mocks base method.
+func (m *MockService) GetRateLimiter(ip string, rps int) *rate.Limiter {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRateLimiter", ip, rps)
+	ret0, _ := ret[0].(*rate.Limiter)
+	return ret0
+}
+
+// GetRateLimiter indicates an expected call of GetRateLimiter.
+func (mr *MockServiceMockRecorder) GetRateLimiter(ip, rps interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRateLimiter", reflect.TypeOf((*MockService)(nil).GetRateLimiter), ip, rps)
+}
+
+// Health mocks base method.
+func (m *MockService) Health(ctx context.Context) core.Health {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Health", ctx)
+	ret0, _ := ret[0].(core.Health)
+	return ret0
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockServiceMockRecorder) Health(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockService)(nil).Health), ctx)
+}
+
+// IngestionReport mocks base method.
+func (m *MockService) IngestionReport(ctx context.Context) (core.IngestionReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IngestionReport", ctx)
+	ret0, _ := ret[0].(core.IngestionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IngestionReport indicates an expected call of IngestionReport.
+func (mr *MockServiceMockRecorder) IngestionReport(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IngestionReport", reflect.TypeOf((*MockService)(nil).IngestionReport), ctx)
+}
+
+// LimitedHandlerService mocks base method.
+func (m *MockService) LimitedHandlerService(handler http.HandlerFunc) http.HandlerFunc {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LimitedHandlerService", handler)
+	ret0, _ := ret[0].(http.HandlerFunc)
+	return ret0
+}
+
+// LimitedHandlerService indicates an expected call of LimitedHandlerService.
+func (mr *MockServiceMockRecorder) LimitedHandlerService(handler interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LimitedHandlerService", reflect.TypeOf((*MockService)(nil).LimitedHandlerService), handler)
+}
+
+// ListRoles mocks base method.
+func (m *MockService) ListRoles(ctx context.Context) (core.RoleList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRoles", ctx)
+	ret0, _ := ret[0].(core.RoleList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRoles indicates an expected call of ListRoles.
+func (mr *MockServiceMockRecorder) ListRoles(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRoles", reflect.TypeOf((*MockService)(nil).ListRoles), ctx)
+}
+
+// ListUsersService mocks base method.
+func (m *MockService) ListUsersService(ctx context.Context, limit, offset int) (core.UserList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsersService", ctx, limit, offset)
+	ret0, _ := ret[0].(core.UserList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsersService indicates an expected call of ListUsersService.
+func (mr *MockServiceMockRecorder) ListUsersService(ctx, limit, offset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsersService", reflect.TypeOf((*MockService)(nil).ListUsersService), ctx, limit, offset)
+}
+
+// NormalizeComics mocks base method.
+func (m *MockService) NormalizeComics(ctx context.Context, batch int) (core.NormalizeCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NormalizeComics", ctx, batch)
+	ret0, _ := ret[0].(core.NormalizeCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NormalizeComics indicates an expected call of NormalizeComics.
+func (mr *MockServiceMockRecorder) NormalizeComics(ctx, batch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NormalizeComics", reflect.TypeOf((*MockService)(nil).NormalizeComics), ctx, batch)
+}
+
+// PrettyPrintService mocks base method.
+func (m *MockService) PrettyPrintService(comics []core.Comic) bytes.Buffer {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PrettyPrintService", comics)
+	ret0, _ := ret[0].(bytes.Buffer)
+	return ret0
+}
+
+// PrettyPrintService indicates an expected call of PrettyPrintService.
+func (mr *MockServiceMockRecorder) PrettyPrintService(comics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrettyPrintService", reflect.TypeOf((*MockService)(nil).PrettyPrintService), comics)
+}
+
+// RefetchComic mocks base method.
+func (m *MockService) RefetchComic(ctx context.Context, num int) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefetchComic", ctx, num)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefetchComic indicates an expected call of RefetchComic.
+func (mr *MockServiceMockRecorder) RefetchComic(ctx, num interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefetchComic", reflect.TypeOf((*MockService)(nil).RefetchComic), ctx, num)
+}
+
+// ResyncDatabase mocks base method.
+func (m *MockService) ResyncDatabase(ctx context.Context, workers int) (core.ResyncCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResyncDatabase", ctx, workers)
+	ret0, _ := ret[0].(core.ResyncCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResyncDatabase indicates an expected call of ResyncDatabase.
+func (mr *MockServiceMockRecorder) ResyncDatabase(ctx, workers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResyncDatabase", reflect.TypeOf((*MockService)(nil).ResyncDatabase), ctx, workers)
+}
+
+// SaveAuditRecord mocks base method.
+func (m *MockService) SaveAuditRecord(ctx context.Context, record core.AuditRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAuditRecord", ctx, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAuditRecord indicates an expected call of SaveAuditRecord.
+func (mr *MockServiceMockRecorder) SaveAuditRecord(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAuditRecord", reflect.TypeOf((*MockService)(nil).SaveAuditRecord), ctx, record)
+}
+
+// SaveRole mocks base method.
+func (m *MockService) SaveRole(ctx context.Context, role core.Role) (core.Role, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRole", ctx, role)
+	ret0, _ := ret[0].(core.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveRole indicates an expected call of SaveRole.
+func (mr *MockServiceMockRecorder) SaveRole(ctx, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRole", reflect.TypeOf((*MockService)(nil).SaveRole), ctx, role)
+}
+
+// UpdateComic mocks base method.
+func (m *MockService) UpdateComic(ctx context.Context, num int, patch core.ComicPatch) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateComic", ctx, num, patch)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateComic indicates an expected call of UpdateComic.
+func (mr *MockServiceMockRecorder) UpdateComic(ctx, num, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateComic", reflect.TypeOf((*MockService)(nil).UpdateComic), ctx, num, patch)
+}
+
+// UpdateDatabase mocks base method.
+func (m *MockService) UpdateDatabase(ctx context.Context, workers int) (core.ComicCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateDatabase", ctx, workers)
+	ret0, _ := ret[0].(core.ComicCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateDatabase indicates an expected call of UpdateDatabase.
+func (mr *MockServiceMockRecorder) UpdateDatabase(ctx, workers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDatabase", reflect.TypeOf((*MockService)(nil).UpdateDatabase), ctx, workers)
+}
+
+// UpdateUserService mocks base method.
+func (m *MockService) UpdateUserService(ctx context.Context, username string, patch core.UserPatch) (core.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserService", ctx, username, patch)
+	ret0, _ := ret[0].(core.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateUserService indicates an expected call of UpdateUserService.
+func (mr *MockServiceMockRecorder) UpdateUserService(ctx, username, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserService", reflect.TypeOf((*MockService)(nil).UpdateUserService), ctx, username, patch)
+}
+
+// MockSearch is a mock of Search interface.
+type MockSearch struct {
+	ctrl     *gomock.Controller
+	recorder *MockSearchMockRecorder
+}
+
+// MockSearchMockRecorder is the mock recorder for MockSearch.
+type MockSearchMockRecorder struct {
+	mock *MockSearch
+}
+
+// NewMockSearch creates a new mock instance.
+func NewMockSearch(ctrl *gomock.Controller) *MockSearch {
+	mock := &MockSearch{ctrl: ctrl}
+	mock.recorder = &MockSearchMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSearch) EXPECT() *MockSearchMockRecorder {
+	return m.recorder
+}
+
+// RebuildIndex mocks base method.
+func (m *MockSearch) RebuildIndex(ctx context.Context, indexFile string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RebuildIndex", ctx, indexFile)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RebuildIndex indicates an expected call of RebuildIndex.
+func (mr *MockSearchMockRecorder) RebuildIndex(ctx, indexFile interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RebuildIndex", reflect.TypeOf((*MockSearch)(nil).RebuildIndex), ctx, indexFile)
+}
+
+// RelevantURLS mocks base method.
+func (m *MockSearch) RelevantURLS(ctx context.Context, str, indexFile string) ([]string, []core.Comic) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RelevantURLS", ctx, str, indexFile)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].([]core.Comic)
+	return ret0, ret1
+}
+
+// RelevantURLS indicates an expected call of RelevantURLS.
+func (mr *MockSearchMockRecorder) RelevantURLS(ctx, str, indexFile interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RelevantURLS", reflect.TypeOf((*MockSearch)(nil).RelevantURLS), ctx, str, indexFile)
+}
+
+// MockImages is a mock of Images interface.
+type MockImages struct {
+	ctrl     *gomock.Controller
+	recorder *MockImagesMockRecorder
+}
+
+// MockImagesMockRecorder is the mock recorder for MockImages.
+type MockImagesMockRecorder struct {
+	mock *MockImages
+}
+
+// NewMockImages creates a new mock instance.
+func NewMockImages(ctrl *gomock.Controller) *MockImages {
+	mock := &MockImages{ctrl: ctrl}
+	mock.recorder = &MockImagesMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImages) EXPECT() *MockImagesMockRecorder {
+	return m.recorder
+}
+
+// Open mocks base method.
+func (m *MockImages) Open(ctx context.Context, comicID int, thumb bool) (*os.File, core.ComicImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Open", ctx, comicID, thumb)
+	ret0, _ := ret[0].(*os.File)
+	ret1, _ := ret[1].(core.ComicImage)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Open indicates an expected call of Open.
+func (mr *MockImagesMockRecorder) Open(ctx, comicID, thumb interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockImages)(nil).Open), ctx, comicID, thumb)
+}
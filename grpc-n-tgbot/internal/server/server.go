@@ -2,31 +2,66 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
-	"golang.org/x/crypto/bcrypt"
+	pb "github.com/sgsoul/internal/proto"
 	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 //go:generate mockgen -source=server.go -destination=mocks/mock.go
 
 type Service interface {
 	Decode(w http.ResponseWriter, r *http.Request, v any)
-	UpdateDatabase(workers int) (core.ComicCount, error)
+	UpdateDatabase(ctx context.Context, workers int) (core.ComicCount, error)
+	NormalizeComics(ctx context.Context, batch int) (core.NormalizeCount, error)
+	ResyncDatabase(ctx context.Context, workers int) (core.ResyncCount, error)
+	GetComicRevisionsService(ctx context.Context, id int) ([]core.ComicRevision, error)
+	IngestionReport(ctx context.Context) (core.IngestionReport, error)
+	GetComicInfo(ctx context.Context, num int) (core.ComicInfo, error)
+	GetComic(ctx context.Context, num int) (core.Comic, error)
+	UpdateComic(ctx context.Context, num int, patch core.ComicPatch) (core.Comic, error)
+	DeleteComic(ctx context.Context, num int, hard bool) error
+	RefetchComic(ctx context.Context, num int) (core.Comic, error)
 	GetRateLimiter(ip string, rps int) *rate.Limiter
-	CreateUserService(username, password, role string) error
 	PrettyPrintService(comics []core.Comic) bytes.Buffer
 	LimitedHandlerService(handler http.HandlerFunc) http.HandlerFunc
-	GetUserByUsernameService(username string) (core.User, error)
+	ListUsersService(ctx context.Context, limit, offset int) (core.UserList, error)
+	UpdateUserService(ctx context.Context, username string, patch core.UserPatch) (core.User, error)
+	DeleteUserService(ctx context.Context, username string, hard bool) error
+	SaveAuditRecord(ctx context.Context, record core.AuditRecord) error
+	AuditLog(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error)
+	Health(ctx context.Context) core.Health
+	ListRoles(ctx context.Context) (core.RoleList, error)
+	SaveRole(ctx context.Context, role core.Role) (core.Role, error)
+	DeleteRole(ctx context.Context, name string) error
 }
 
 type Search interface {
-	RelevantURLS(str string, indexFile string) ([]string, []core.Comic)
+	RelevantURLS(ctx context.Context, str string, indexFile string) ([]string, []core.Comic)
+	RebuildIndex(ctx context.Context, indexFile string) error
+}
+
+type Images interface {
+	Open(ctx context.Context, comicID int, thumb bool) (*os.File, core.ComicImage, error)
 }
 
 type Server struct {
@@ -34,22 +69,49 @@ type Server struct {
 	service Service
 	search Search
 	authClient *AuthClient
+	images Images
 }
 
-func NewServer(cfg *core.Config, src Service, sr Search, authClient *AuthClient) (*Server, error) { // Update the function signature
+func NewServer(cfg *core.Config, src Service, sr Search, authClient *AuthClient, img Images) (*Server, error) { // Update the function signature
 	return &Server{
 		search:     sr,
 		config:     cfg,
 		service:    src,
 		authClient: authClient,
+		images:     img,
 	}, nil
 }
 
+// Start регистрирует маршруты. Каждый маршрут объявляет, кого пропускает: authorized - токен с правом,
+// authenticated - любой действующий токен, optionalAuth - токен необязателен; остальные маршруты открыты,
+// а /comics/ проверяет права сам, потому что они зависят от действия
 func (s *Server) Start() error {
-	http.HandleFunc("/login", s.handleLogin)
-	http.HandleFunc("/register", s.handleRegister)
-	http.HandleFunc("/pics", s.limitedHandler(s.rateLimitedHandler(s.handlePics)))
-	http.HandleFunc("/update", s.limitedHandler(s.rateLimitedHandler(s.handleUpdate)))
+	// проверки балансировщика не ограничиваются и не требуют авторизации
+	s.handle("/health", s.handleHealth)
+	s.handle("/login", s.audited(core.AuditLogin, s.rateLimitedHandler(s.handleLogin)))
+	s.handle("/refresh", s.rateLimitedHandler(s.handleRefresh))
+	s.handle("/logout", s.audited(core.AuditLogout, s.optionalAuth(s.handleLogout)))
+	s.handle("/register", s.audited(core.AuditRegister, s.optionalAuth(s.handleRegister)))
+	s.handle("/pics", s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermSearchRead, s.handlePics))))
+	s.handle("/update", s.audited(core.AuditUpdate, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermComicsIngest, s.handleUpdate)))))
+	s.handle("/normalize", s.audited(core.AuditNormalize, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermComicsIngest, s.handleNormalize)))))
+	s.handle("/ingestion", s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermComicsIngest, s.handleIngestion))))
+	s.handle("/users", s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleUsers))))
+	s.handle("/users/", s.audited(core.AuditUserUpdate, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleUser)))))
+	s.handle("/roles", s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleRoles))))
+	s.handle("/roles/", s.audited(core.AuditRoleUpdate, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleRole)))))
+	s.handle("/me/password", s.audited(core.AuditPasswordChange, s.limitedHandler(s.rateLimitedHandler(s.authenticated(s.handlePassword)))))
+	s.handle("/.well-known/jwks.json", s.limitedHandler(s.handleJWKS))
+	s.handle("/keys/rotate", s.audited(core.AuditKeyRotate, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermKeysManage, s.handleRotateKeys)))))
+	s.handle("/api-keys", s.audited(core.AuditAPIKeyCreate, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleAPIKeys)))))
+	s.handle("/api-keys/", s.audited(core.AuditAPIKeyRevoke, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleAPIKey)))))
+	s.handle("/lockouts", s.audited(core.AuditLoginUnlock, s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermUsersManage, s.handleLockouts)))))
+	s.handle("/audit", s.limitedHandler(s.rateLimitedHandler(s.authorized(core.PermAuditRead, s.handleAudit))))
+	s.handle("/comics/", s.audited(core.AuditComicUpdate, s.limitedHandler(s.rateLimitedHandler(s.handleComics))))
+	// картинки запрашиваются пачками, поэтому ограничиваем только конкурентность
+	s.handle("/images/", s.limitedHandler(s.handleImages))
+	// зеркало API xkcd.com: /info.0.json и /{num}/info.0.json
+	s.handle("/", s.limitedHandler(s.handleMirror))
 
 	port := fmt.Sprintf(":%d", s.config.Port)
 	fmt.Printf("\nServer listening on port %d\n", s.config.Port)
@@ -72,7 +134,22 @@ func (s *Server) getPics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comicURLs, _ := s.search.RelevantURLS(searchString, s.config.IndexFile)
+	comicURLs, relevantComics := s.search.RelevantURLS(r.Context(), searchString, s.config.IndexFile)
+	if err := r.Context().Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// images=local отдает ссылки на локальное зеркало картинок вместо imgs.xkcd.com
+	if r.URL.Query().Get("images") == "local" {
+		comicURLs = comicURLs[:0]
+		for i, comic := range relevantComics {
+			if i >= 10 {
+				break
+			}
+			comicURLs = append(comicURLs, fmt.Sprintf("/images/%d", comic.ID))
+		}
+	}
 
 	// JSON output
 	urlJSON, err := json.Marshal(comicURLs)
@@ -104,15 +181,15 @@ func (s *Server) getPics(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-
-		if !s.authClient.IsAdmin(w, r) {
-			http.Error(w, "forbidden. administration rights required", http.StatusForbidden)
+		// mode=resync повторно загружает уже сохраненные комиксы в поисках правок
+		if r.URL.Query().Get("mode") == "resync" {
+			s.resyncComics(w, r)
 			return
 		}
 
-		response, err := s.service.UpdateDatabase(s.config.Parallel)
+		response, err := s.service.UpdateDatabase(r.Context(), s.config.Parallel)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err)
 			return
 		}
 
@@ -128,6 +205,309 @@ func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handleIngestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.service.IngestionReport(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+func (s *Server) resyncComics(w http.ResponseWriter, r *http.Request) {
+	response, err := s.service.ResyncDatabase(r.Context(), s.config.Parallel)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if response.ChangedComics > 0 {
+		if err := s.search.RebuildIndex(r.Context(), s.config.IndexFile); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleComics обслуживает пути вида /comics/{num}/...
+func (s *Server) handleComics(w http.ResponseWriter, r *http.Request) {
+	num, action, err := parseComicPath("/comics/", r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	auditRecord(r).Target = fmt.Sprintf("comic/%d", num)
+
+	switch action {
+	case "":
+		s.authorized(core.PermComicsUpdate, func(w http.ResponseWriter, r *http.Request) { s.handleComic(w, r, num) })(w, r)
+	case "revisions":
+		s.authorized(core.PermSearchRead, func(w http.ResponseWriter, r *http.Request) { s.handleComicRevisions(w, r, num) })(w, r)
+	case "refetch":
+		s.authorized(core.PermComicsUpdate, func(w http.ResponseWriter, r *http.Request) { s.handleRefetch(w, r, num) })(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleComic - просмотр и правка комикса модератором: GET, PATCH и DELETE /comics/{num}
+func (s *Server) handleComic(w http.ResponseWriter, r *http.Request, num int) {
+	var (
+		comic core.Comic
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		comic, err = s.service.GetComic(r.Context(), num)
+	case http.MethodPatch:
+		var patch core.ComicPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		comic, err = s.service.UpdateComic(r.Context(), num, patch)
+	case http.MethodDelete:
+		auditRecord(r).Action = core.AuditComicDelete
+		// по умолчанию комикс только скрывается, ?hard=true удаляет его из базы
+		err = s.service.DeleteComic(r.Context(), num, r.URL.Query().Get("hard") == "true")
+	default:
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "comic not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		// правка должна сразу отражаться в поиске
+		if err := s.search.RebuildIndex(r.Context(), s.config.IndexFile); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(comic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleRefetch заново загружает комикс с xkcd.com, отменяя ручные правки
+func (s *Server) handleRefetch(w http.ResponseWriter, r *http.Request, num int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auditRecord(r).Action = core.AuditComicRefetch
+
+	comic, err := s.service.RefetchComic(r.Context(), num)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "comic not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.search.RebuildIndex(r.Context(), s.config.IndexFile); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(comic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleImages отдает /images/{num} и /images/{num}/thumb из локального зеркала
+func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	num, action, err := parseComicPath("/images/", r.URL.Path)
+	if err != nil || (action != "" && action != "thumb") {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, image, err := s.images.Open(r.Context(), num, action == "thumb")
+	if err != nil {
+		log.Error().Err(err).Msgf("error opening image of comic %d", num)
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// содержимое файла определяется хешем, так что он же служит ETag
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("ETag", `"`+image.Hash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// handleMirror отдает сохраненные комиксы в формате xkcd.com, чтобы другой экземпляр
+// сервиса мог использовать этот сервер как source_url
+func (s *Server) handleMirror(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	num := 0
+	if r.URL.Path != "/info.0.json" {
+		var err error
+		num, _, err = parseComicPath("/", strings.TrimSuffix(r.URL.Path, "/info.0.json"))
+		if err != nil || !strings.HasSuffix(r.URL.Path, "/info.0.json") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	info, err := s.service.GetComicInfo(r.Context(), num)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// ссылки на картинки можно направить в локальное зеркало вместо imgs.xkcd.com
+	if s.config.MirrorImageURLs && path.Ext(info.Img) != "" {
+		info.Img = fmt.Sprintf("%s/images/%d", requestBaseURL(r), info.Num)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// requestBaseURL восстанавливает адрес сервера, по которому пришел запрос
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *Server) handleComicRevisions(w http.ResponseWriter, r *http.Request, num int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revisions, err := s.service.GetComicRevisionsService(r.Context(), num)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if revisions == nil {
+		revisions = []core.ComicRevision{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(revisions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// parseComicPath разбирает {prefix}{num}/{action} на номер комикса и действие
+func parseComicPath(prefix, path string) (int, string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+	if len(parts) > 2 {
+		return 0, "", fmt.Errorf("invalid comic path %q", path)
+	}
+
+	num, err := strconv.Atoi(parts[0])
+	if err != nil || num <= 0 {
+		return 0, "", fmt.Errorf("invalid comic number %q", parts[0])
+	}
+
+	if len(parts) == 2 {
+		return num, parts[1], nil
+	}
+	return num, "", nil
+}
+
+func (s *Server) handleNormalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response, err := s.service.NormalizeComics(r.Context(), s.config.NormBatch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// ключевые слова изменились, индекс нужно пересобрать
+	if err := s.search.RebuildIndex(r.Context(), s.config.IndexFile); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var credentials struct {
 		Username string `json:"username"`
@@ -137,30 +517,618 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	auditRecord(r).Actor = credentials.Username
 
-	user, err := s.service.GetUserByUsernameService(credentials.Username)
+	// пароль проверяет сервис авторизации, хеши паролей сюда не попадают; он же считает неудачные
+	// входы по имени и адресу и после серии неудач отвечает 429
+	tokens, err := s.authClient.Login(r.Context(), credentials.Username, credentials.Password, s.loginClient(r), s.config.TokenTime)
 	if err != nil {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		writeAuthError(w, err)
+		return
+	}
+
+	s.setTokenCookies(w, tokens)
+	w.WriteHeader(http.StatusOK)
+}
+
+const (
+	// loginClientHeader - заголовок, в котором доверенный посредник называет клиента, например чат бота
+	loginClientHeader = "X-Login-Client"
+	// loginClientSecretHeader - заголовок с login_client_secret, которым посредник подтверждает loginClientHeader
+	loginClientSecretHeader = "X-Login-Client-Secret"
+)
+
+// loginClient - кем считать входящего для счетчика неудач по адресу. Доверенный посредник называет
+// своего клиента в X-Login-Client, а если задан login_client_secret, еще и предъявляет его.
+// Во всех остальных случаях, в том числе у посредника без заголовка, считается адрес соединения
+func (s *Server) loginClient(r *http.Request) string {
+	ip := clientIP(r)
+	client := strings.TrimSpace(r.Header.Get(loginClientHeader))
+	if client == "" || !slices.Contains(s.config.LoginTrustedAddrs, ip) {
+		return ip
+	}
+	if secret := s.config.LoginClientSecret; secret != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get(loginClientSecretHeader)), []byte(secret)) != 1 {
+		return ip
+	}
+	return client
+}
+
+// setTokenCookies кладет access-токен в cookie token, а refresh-токен - в cookie refresh_token, недоступную скриптам
+func (s *Server) setTokenCookies(w http.ResponseWriter, tokens *pb.GenerateJWTResponse) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    "token",
+		Value:   tokens.Token,
+		Expires: time.Now().Add(time.Duration(s.config.TokenTime) * time.Minute),
+	})
+	if tokens.RefreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "refresh_token",
+			Value:    tokens.RefreshToken,
+			Path:     "/",
+			Expires:  time.Unix(tokens.RefreshExpiresAt, 0),
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// handleRefresh обменивает refresh-токен из cookie или тела запроса на новую пару токенов
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)); err != nil {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		req.RefreshToken = cookie.Value
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "no refresh token provided", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := s.authClient.GenerateJWT(user.Username, user.Role, s.config.TokenTime)
+	tokens, err := s.authClient.RefreshToken(r.Context(), req.RefreshToken, s.config.TokenTime)
 	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		writeAuthError(w, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   token,
-		Expires: time.Now().Add(time.Duration(s.config.TokenTime) * time.Minute),
+	s.setTokenCookies(w, tokens)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]any{
+		"token":              tokens.Token,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
 	})
-	w.WriteHeader(http.StatusOK)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleLogout отзывает токены из cookie и удаляет cookie
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// просроченный access-токен отзывать незачем, но refresh-токен отзывается в любом случае
+	var token, refreshToken string
+	if p, ok := principalFrom(r); ok {
+		token = p.Token
+	}
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		refreshToken = cookie.Value
+	}
+
+	if token != "" || refreshToken != "" {
+		if err := s.authClient.RevokeToken(r.Context(), token, refreshToken); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: "token", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHealth отвечает 200 и в деградированном режиме: поиск продолжает работать по кешу
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := s.service.Health(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(health)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleUsers - список пользователей: GET /users?limit=&offset=
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := s.service.ListUsersService(r.Context(), limit, offset)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(users)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleUser - правка пользователя: PATCH и DELETE /users/{username}
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimPrefix(r.URL.Path, "/users/")
+	if username == "" || strings.Contains(username, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	auditRecord(r).Target = username
+
+	var (
+		user core.User
+		err  error
+		// заблокированный, удаленный или сменивший роль пользователь должен войти заново
+		revoke bool
+	)
+	switch r.Method {
+	case http.MethodPatch:
+		var patch core.UserPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if patch.Role != nil {
+			auditRecord(r).Action = core.AuditRoleGrant
+			auditRecord(r).Details = "role=" + *patch.Role
+		}
+		revoke = patch.Role != nil || (patch.Disabled != nil && *patch.Disabled)
+		user, err = s.service.UpdateUserService(r.Context(), username, patch)
+	case http.MethodDelete:
+		auditRecord(r).Action = core.AuditUserDelete
+		revoke = true
+		// по умолчанию пользователь только блокируется, ?hard=true удаляет его из базы
+		err = s.service.DeleteUserService(r.Context(), username, r.URL.Query().Get("hard") == "true")
+	default:
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	case errors.Is(err, core.ErrUnknownRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	if revoke {
+		s.revokeUserTokens(r, username)
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// revokeUserTokens отзывает токены пользователя от имени того, кто его изменил; изменение уже сохранено,
+// поэтому ошибка только записывается в лог
+func (s *Server) revokeUserTokens(r *http.Request, username string) {
+	p, ok := principalFrom(r)
+	if !ok {
+		return
+	}
+	if err := s.authClient.RevokeAllForUser(r.Context(), p.Token, username); err != nil {
+		log.Error().Err(err).Str("username", username).Msg("error revoking user tokens")
+	}
+}
+
+// handleRoles - список ролей с их правами и всех прав, которые можно назначить: GET /roles
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles, err := s.service.ListRoles(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(roles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleRole создает или меняет роль (PUT /roles/{name}) и удаляет ее (DELETE /roles/{name}).
+// Пользователям роль назначается через PATCH /users/{username}
+func (s *Server) handleRole(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/roles/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	auditRecord(r).Target = name
+
+	var (
+		role core.Role
+		err  error
+	)
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Description string   `json:"description"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		auditRecord(r).Details = "permissions=" + strings.Join(req.Permissions, ",")
+		role, err = s.service.SaveRole(r.Context(), core.Role{Name: name, Description: req.Description, Permissions: req.Permissions})
+	case http.MethodDelete:
+		auditRecord(r).Action = core.AuditRoleDelete
+		err = s.service.DeleteRole(r.Context(), name)
+	default:
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "role not found", http.StatusNotFound)
+		return
+	case errors.Is(err, core.ErrUnknownRole), errors.Is(err, core.ErrUnknownPermission), errors.Is(err, core.ErrBuiltinRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, core.ErrRoleInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handlePassword меняет пароль текущего пользователя: PUT /me/password
+func (s *Server) handlePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// authenticated уже проверил токен
+	p, _ := principalFrom(r)
+
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.NewPassword == "" {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.authClient.ChangePassword(r.Context(), p.Token, req.OldPassword, req.NewPassword); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleJWKS отдает открытые ключи для проверки токенов другими сервисами
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	set, err := s.authClient.JWKS(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err = json.NewEncoder(w).Encode(set)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleRotateKeys выпускает новый ключ подписи токенов; в теле можно перечислить kid ключей, которые пора вывести
+func (s *Server) handleRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Retire []string `json:"retire"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// authorized уже проверил токен
+	p, _ := principalFrom(r)
+	res, err := s.authClient.RotateKeys(r.Context(), p.Token, req.Retire)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	auditRecord(r).Target = res.Kid
+	if len(req.Retire) > 0 {
+		auditRecord(r).Details = "retired " + strings.Join(req.Retire, ",")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleAPIKeys перечисляет ключи API (GET /api-keys?username=) и выпускает новый (POST /api-keys).
+// Значение ключа возвращается только в ответе на POST
+func (s *Server) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	// authorized уже проверил токен
+	p, _ := principalFrom(r)
+
+	var (
+		res any
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = s.authClient.ListAPIKeys(r.Context(), p.Token, r.URL.Query().Get("username"))
+	case http.MethodPost:
+		var req struct {
+			Username  string    `json:"username"`
+			Name      string    `json:"name"`
+			Scopes    []string  `json:"scopes"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		auditRecord(r).Target = req.Username
+		auditRecord(r).Details = "name=" + req.Name + " scopes=" + strings.Join(req.Scopes, ",")
+
+		in := &pb.CreateAPIKeyRequest{Username: req.Username, Name: req.Name, Scopes: req.Scopes}
+		if !req.ExpiresAt.IsZero() {
+			in.ExpiresAt = req.ExpiresAt.Unix()
+		}
+		res, err = s.authClient.CreateAPIKey(r.Context(), p.Token, in)
+	default:
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+}
+
+// handleAPIKey отзывает ключ API: DELETE /api-keys/{id}
+func (s *Server) handleAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api-keys/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auditRecord(r).Target = id
+
+	// authorized уже проверил токен
+	p, _ := principalFrom(r)
+	if err := s.authClient.RevokeAPIKey(r.Context(), p.Token, id); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLockouts перечисляет действующие блокировки входа (GET /lockouts)
+// и снимает блокировку с пользователя или адреса (DELETE /lockouts?username= или ?ip=)
+func (s *Server) handleLockouts(w http.ResponseWriter, r *http.Request) {
+	// authorized уже проверил токен
+	p, _ := principalFrom(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		locks, err := s.authClient.ListLoginLocks(r.Context(), p.Token)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(locks)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusTeapot)
+			return
+		}
+	case http.MethodDelete:
+		username, ip := r.URL.Query().Get("username"), r.URL.Query().Get("ip")
+		auditRecord(r).Target = username + ip
+		if err := s.authClient.UnlockLogin(r.Context(), p.Token, username, ip); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "invalid http method", http.StatusMethodNotAllowed)
+	}
+}
+
+// parsePage читает limit и offset из запроса; по умолчанию 20 записей, не больше 100
+func parsePage(r *http.Request) (int, int, error) {
+	limit, offset := 20, 0
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// handle регистрирует обработчик с дедлайном запроса из конфига
+func (s *Server) handle(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, s.timeoutHandler(s.config.EndpointTimeout(pattern), handler))
+}
+
+// timeoutHandler отменяет контекст запроса по истечении timeout; 0 - без дедлайна.
+// Контекст отменяется и тогда, когда клиент закрывает соединение
+func (s *Server) timeoutHandler(timeout time.Duration, handler http.HandlerFunc) http.HandlerFunc {
+	if timeout <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// writeAuthError переводит ответ сервиса авторизации в HTTP-статус с его сообщением
+func writeAuthError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unauthenticated:
+		http.Error(w, st.Message(), http.StatusUnauthorized)
+	case codes.PermissionDenied:
+		http.Error(w, st.Message(), http.StatusForbidden)
+	case codes.InvalidArgument:
+		http.Error(w, st.Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, st.Message(), http.StatusNotFound)
+	case codes.AlreadyExists:
+		http.Error(w, st.Message(), http.StatusConflict)
+	case codes.ResourceExhausted:
+		if wait := retryDelay(st); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+		http.Error(w, st.Message(), http.StatusTooManyRequests)
+	default:
+		writeError(w, err)
+	}
+}
+
+// retryDelay - сколько, по словам сервиса авторизации, ждать перед повтором; 0, если он не сказал
+func retryDelay(st *status.Status) time.Duration {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
+
+// writeError отвечает 504 на истекший дедлайн, 409, если работу уже выполняет другая реплика,
+// 503 при недоступной базе и 500 на остальные ошибки
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, core.ErrLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, core.ErrStorageUnavailable), status.Code(err) == codes.Unavailable:
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "storage is unavailable, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "request timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		// клиент ушел, отвечать некому
+		log.Debug().Err(err).Msg("request cancelled by client")
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func (s *Server) limitedHandler(handler http.HandlerFunc) http.HandlerFunc {
@@ -192,33 +1160,28 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return 
-	}
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "error hashing password", http.StatusInternalServerError)
 		return
 	}
 
-	if req.Role == "admin" {
-		if !s.authClient.IsAdmin(w, r) {
-			http.Error(w, "forbidden. administration rights required", http.StatusForbidden)
-			return
-		}
+	auditRecord(r).Target = req.Username
+	auditRecord(r).Details = "role=" + req.Role
+
+	// роль, отличную от user, сервис авторизации назначает только по токену с правом users:manage
+	var token string
+	if p, ok := principalFrom(r); ok {
+		token = p.Token
 	}
 
-	err = s.service.CreateUserService(req.Username, string(hashedPassword), req.Role)
-	if err != nil {
-		http.Error(w, "error saving user", http.StatusInternalServerError)
+	if err := s.authClient.Register(r.Context(), token, req.Username, req.Password, req.Role); err != nil {
+		writeAuthError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-func StartServer(cfg *core.Config, src Service, srch Search, authClient *AuthClient) { 
-	xkcdServer, err := NewServer(cfg, src, srch, authClient)
+func StartServer(cfg *core.Config, src Service, srch Search, authClient *AuthClient, img Images) {
+	xkcdServer, err := NewServer(cfg, src, srch, authClient, img)
 	if err != nil {
 		log.Error().Err(err).Msg("Error creating server")
 	}
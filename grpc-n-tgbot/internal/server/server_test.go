@@ -0,0 +1,601 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sgsoul/internal/core"
+	pb "github.com/sgsoul/internal/proto"
+	mocks "github.com/sgsoul/internal/server/mocks"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeAuth принимает в качестве токена имя пользователя: "admin" - администратор, "moderator" - модератор.
+// Refresh-токен - имя пользователя с префиксом "refresh-", revoked запоминает отозванные токены и пользователей.
+// Пароль любого пользователя - "password", "disabled" заблокирован, вход "locked" запрещен после неудач, "unavailable" - база недоступна,
+// токен "unavailable" - сервис авторизации недоступен. Ключ API "xkcd_ci" принадлежит "ci" с правом comics:ingest
+type fakeAuth struct {
+	revoked map[string]bool
+}
+
+func (f fakeAuth) Login(ctx context.Context, in *pb.LoginRequest, _ ...grpc.CallOption) (*pb.GenerateJWTResponse, error) {
+	switch {
+	case in.Username == "unavailable":
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	case in.Username == "locked":
+		st, _ := status.New(codes.ResourceExhausted, "too many failed login attempts, try again in 1m30s").
+			WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(90 * time.Second)})
+		return nil, st.Err()
+	case in.Password != "password":
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	case in.Username == "disabled":
+		return nil, status.Error(codes.PermissionDenied, "account disabled")
+	}
+	return f.GenerateJWT(ctx, &pb.GenerateJWTRequest{Username: in.Username, ExpiryMinutes: in.ExpiryMinutes})
+}
+
+func (fakeAuth) Register(ctx context.Context, in *pb.RegisterRequest, _ ...grpc.CallOption) (*pb.RegisterResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	switch {
+	case in.Role == "admin" && (len(md.Get("authorization")) == 0 || md.Get("authorization")[0] != "Bearer admin"):
+		return nil, status.Error(codes.PermissionDenied, "administration rights required")
+	case in.Username == "user1":
+		return nil, status.Error(codes.AlreadyExists, "user already exists")
+	}
+	return &pb.RegisterResponse{}, nil
+}
+
+func (fakeAuth) ChangePassword(ctx context.Context, in *pb.ChangePasswordRequest, _ ...grpc.CallOption) (*pb.ChangePasswordResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get("authorization")) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no token provided")
+	}
+	if in.OldPassword != "old" {
+		return nil, status.Error(codes.PermissionDenied, "wrong password")
+	}
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+func (fakeAuth) GenerateJWT(_ context.Context, in *pb.GenerateJWTRequest, _ ...grpc.CallOption) (*pb.GenerateJWTResponse, error) {
+	return &pb.GenerateJWTResponse{Token: in.Username, RefreshToken: "refresh-" + in.Username, RefreshExpiresAt: time.Now().Add(time.Hour).Unix()}, nil
+}
+
+func (f fakeAuth) RefreshToken(_ context.Context, in *pb.RefreshTokenRequest, _ ...grpc.CallOption) (*pb.GenerateJWTResponse, error) {
+	username, ok := strings.CutPrefix(in.RefreshToken, "refresh-")
+	if !ok || f.revoked[in.RefreshToken] {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	return f.GenerateJWT(context.Background(), &pb.GenerateJWTRequest{Username: username})
+}
+
+func (f fakeAuth) RevokeToken(_ context.Context, in *pb.RevokeTokenRequest, _ ...grpc.CallOption) (*pb.RevokeTokenResponse, error) {
+	f.revoked[in.Token] = true
+	f.revoked[in.RefreshToken] = true
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+func (f fakeAuth) RevokeAllForUser(ctx context.Context, in *pb.RevokeAllForUserRequest, _ ...grpc.CallOption) (*pb.RevokeAllForUserResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if got := md.Get("authorization"); len(got) == 0 || got[0] != "Bearer admin" {
+		return nil, status.Error(codes.PermissionDenied, "administration rights required")
+	}
+	f.revoked["user:"+in.Username] = true
+	return &pb.RevokeAllForUserResponse{}, nil
+}
+
+func (fakeAuth) ValidateJWT(_ context.Context, in *pb.ValidateJWTRequest, _ ...grpc.CallOption) (*pb.ValidateJWTResponse, error) {
+	switch in.Token {
+	case "", "invalid":
+		return nil, errors.New("invalid token")
+	case "unavailable":
+		return nil, status.Error(codes.Unavailable, "auth service is unavailable")
+	}
+	role, permissions := core.RoleUser, []string{core.PermSearchRead}
+	switch in.Token {
+	case core.RoleAdmin:
+		role = core.RoleAdmin
+		permissions = []string{core.PermSearchRead, core.PermComicsUpdate, core.PermComicsIngest, core.PermUsersManage, core.PermAuditRead, core.PermKeysManage}
+	case core.RoleModerator:
+		role = core.RoleModerator
+		permissions = []string{core.PermSearchRead, core.PermComicsUpdate}
+	}
+	return &pb.ValidateJWTResponse{Valid: true, Username: in.Token, Role: role, Permissions: permissions}, nil
+}
+
+func (fakeAuth) RotateKeys(ctx context.Context, in *pb.RotateKeysRequest, _ ...grpc.CallOption) (*pb.RotateKeysResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if got := md.Get("authorization"); len(got) == 0 || got[0] != "Bearer admin" {
+		return nil, status.Error(codes.PermissionDenied, "administration rights required")
+	}
+	for _, kid := range in.Retire {
+		if kid == "config" {
+			return nil, status.Error(codes.InvalidArgument, "signing key is managed by config")
+		}
+	}
+	return &pb.RotateKeysResponse{Kid: "new", Keys: []*pb.SigningKey{{Kid: "old"}, {Kid: "new"}}}, nil
+}
+
+func (fakeAuth) ValidateAPIKey(_ context.Context, in *pb.ValidateAPIKeyRequest, _ ...grpc.CallOption) (*pb.ValidateJWTResponse, error) {
+	if in.Key != "xkcd_ci" {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return &pb.ValidateJWTResponse{Valid: true, Username: "ci", Role: core.RoleAdmin, Permissions: []string{core.PermComicsIngest}}, nil
+}
+
+func (fakeAuth) CreateAPIKey(ctx context.Context, in *pb.CreateAPIKeyRequest, _ ...grpc.CallOption) (*pb.CreateAPIKeyResponse, error) {
+	if len(in.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one scope is required")
+	}
+	info := &pb.APIKey{Id: "key1", Username: in.Username, Name: in.Name, Prefix: "xkcd_ci", Scopes: in.Scopes, ExpiresAt: in.ExpiresAt}
+	return &pb.CreateAPIKeyResponse{Key: "xkcd_ci", Info: info}, nil
+}
+
+func (fakeAuth) ListAPIKeys(_ context.Context, in *pb.ListAPIKeysRequest, _ ...grpc.CallOption) (*pb.ListAPIKeysResponse, error) {
+	keys := []*pb.APIKey{{Id: "key1", Username: "ci", Name: "scheduler", Prefix: "xkcd_ci", Scopes: []string{core.PermComicsIngest}}}
+	if in.Username != "" && in.Username != "ci" {
+		keys = nil
+	}
+	return &pb.ListAPIKeysResponse{Keys: keys}, nil
+}
+
+func (f fakeAuth) RevokeAPIKey(_ context.Context, in *pb.RevokeAPIKeyRequest, _ ...grpc.CallOption) (*pb.RevokeAPIKeyResponse, error) {
+	if in.Id != "key1" || f.revoked["key:"+in.Id] {
+		return nil, status.Error(codes.NotFound, "API key not found or already revoked")
+	}
+	f.revoked["key:"+in.Id] = true
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+func (fakeAuth) ListLoginLocks(_ context.Context, _ *pb.ListLoginLocksRequest, _ ...grpc.CallOption) (*pb.ListLoginLocksResponse, error) {
+	return &pb.ListLoginLocksResponse{Locks: []*pb.LoginLock{{Subject: "user:locked", Failures: 5}}}, nil
+}
+
+func (fakeAuth) UnlockLogin(_ context.Context, in *pb.UnlockLoginRequest, _ ...grpc.CallOption) (*pb.UnlockLoginResponse, error) {
+	switch {
+	case (in.Username == "") == (in.Ip == ""):
+		return nil, status.Error(codes.InvalidArgument, "exactly one of username and ip is required")
+	case in.Username != "locked":
+		return nil, status.Error(codes.NotFound, "no failed logins")
+	}
+	return &pb.UnlockLoginResponse{}, nil
+}
+
+func (fakeAuth) GetPublicKeys(_ context.Context, _ *pb.GetPublicKeysRequest, _ ...grpc.CallOption) (*pb.GetPublicKeysResponse, error) {
+	return &pb.GetPublicKeysResponse{}, nil
+}
+
+func newTestServer(t *testing.T) (*Server, *mocks.MockService) {
+	ctrl := gomock.NewController(t)
+	mockService := mocks.NewMockService(ctrl)
+	s, err := NewServer(&core.Config{TokenTime: 15}, mockService, mocks.NewMockSearch(ctrl), &AuthClient{client: fakeAuth{revoked: map[string]bool{}}, timeout: time.Second}, mocks.NewMockImages(ctrl))
+	assert.NoError(t, err)
+	return s, mockService
+}
+
+func newRequest(method, target, token, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+	return r
+}
+
+func TestHandleUsers(t *testing.T) {
+	s, mockService := newTestServer(t)
+	handleUsers := s.authorized(core.PermUsersManage, s.handleUsers)
+
+	list := core.UserList{Users: []core.User{{ID: 2, Username: "user1", Password: "hash", Role: "user"}}, Total: 3, Limit: 1, Offset: 1}
+	mockService.EXPECT().ListUsersService(gomock.Any(), 1, 1).Return(list, nil)
+
+	w := httptest.NewRecorder()
+	handleUsers(w, newRequest(http.MethodGet, "/users?limit=1&offset=1", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "hash")
+
+	var got core.UserList
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, 3, got.Total)
+	assert.Equal(t, "user1", got.Users[0].Username)
+
+	w = httptest.NewRecorder()
+	handleUsers(w, newRequest(http.MethodGet, "/users?limit=1000", "admin", ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleUsers(w, newRequest(http.MethodGet, "/users", "user1", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleUsers(w, newRequest(http.MethodGet, "/users", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleUser(t *testing.T) {
+	s, mockService := newTestServer(t)
+	handleUser := s.authorized(core.PermUsersManage, s.handleUser)
+
+	role := "admin"
+	mockService.EXPECT().UpdateUserService(gomock.Any(), "user1", core.UserPatch{Role: &role}).Return(core.User{Username: "user1", Role: role}, nil)
+	mockService.EXPECT().UpdateUserService(gomock.Any(), "user1", gomock.Any()).Return(core.User{}, core.ErrUnknownRole)
+	mockService.EXPECT().DeleteUserService(gomock.Any(), "user1", false).Return(nil)
+	mockService.EXPECT().DeleteUserService(gomock.Any(), "nobody", true).Return(sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	handleUser(w, newRequest(http.MethodPatch, "/users/user1", "admin", `{"role":"admin"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"role":"admin"`)
+
+	w = httptest.NewRecorder()
+	handleUser(w, newRequest(http.MethodPatch, "/users/user1", "admin", `{"role":"root"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleUser(w, newRequest(http.MethodDelete, "/users/user1", "admin", ""))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	handleUser(w, newRequest(http.MethodDelete, "/users/nobody?hard=true", "admin", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handleUser(w, newRequest(http.MethodDelete, "/users/user1", "user1", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// смена роли и удаление отзывают токены пользователя
+	assert.True(t, s.authClient.client.(fakeAuth).revoked["user:user1"])
+	assert.False(t, s.authClient.client.(fakeAuth).revoked["user:nobody"])
+}
+
+func TestHandlePassword(t *testing.T) {
+	s, _ := newTestServer(t)
+	handlePassword := s.authenticated(s.handlePassword)
+
+	w := httptest.NewRecorder()
+	handlePassword(w, newRequest(http.MethodPut, "/me/password", "user1", `{"old_password":"old","new_password":"new"}`))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	handlePassword(w, newRequest(http.MethodPut, "/me/password", "user1", `{"old_password":"wrong","new_password":"new"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handlePassword(w, newRequest(http.MethodPut, "/me/password", "user1", `{"old_password":"old"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handlePassword(w, newRequest(http.MethodPut, "/me/password", "invalid", `{"old_password":"old","new_password":"new"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	handlePassword(w, newRequest(http.MethodPost, "/me/password", "user1", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestModeratorPermissions(t *testing.T) {
+	s, mockService := newTestServer(t)
+
+	title := "Barrel"
+	mockService.EXPECT().UpdateComic(gomock.Any(), 1, core.ComicPatch{Title: &title}).Return(core.Comic{ID: 1, Title: title}, nil)
+	s.search.(*mocks.MockSearch).EXPECT().RebuildIndex(gomock.Any(), gomock.Any()).Return(nil)
+
+	w := httptest.NewRecorder()
+	s.handleComics(w, newRequest(http.MethodPatch, "/comics/1", "moderator", `{"title":"Barrel"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.handleComics(w, newRequest(http.MethodPatch, "/comics/1", "user1", `{"title":"Barrel"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), core.PermComicsUpdate)
+
+	// история правок требует того же права, что и поиск
+	mockService.EXPECT().GetComicRevisionsService(gomock.Any(), 1).Return(nil, nil)
+	w = httptest.NewRecorder()
+	s.handleComics(w, newRequest(http.MethodGet, "/comics/1/revisions", "user1", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	s.handleComics(w, newRequest(http.MethodGet, "/comics/1/revisions", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// модератор не управляет пользователями
+	w = httptest.NewRecorder()
+	s.authorized(core.PermUsersManage, s.handleUsers)(w, newRequest(http.MethodGet, "/users", "moderator", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandleRoles(t *testing.T) {
+	s, mockService := newTestServer(t)
+	handleRole := s.authorized(core.PermUsersManage, s.handleRole)
+
+	editor := core.Role{Name: "editor", Permissions: []string{core.PermComicsUpdate}}
+	mockService.EXPECT().ListRoles(gomock.Any()).Return(core.RoleList{Roles: []core.Role{editor}, Permissions: []string{core.PermComicsUpdate, core.PermSearchRead}}, nil)
+	mockService.EXPECT().SaveRole(gomock.Any(), editor).Return(editor, nil)
+	mockService.EXPECT().SaveRole(gomock.Any(), gomock.Any()).Return(core.Role{}, core.ErrUnknownPermission)
+	mockService.EXPECT().DeleteRole(gomock.Any(), "editor").Return(core.ErrRoleInUse)
+	mockService.EXPECT().DeleteRole(gomock.Any(), "nobody").Return(sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	s.authorized(core.PermUsersManage, s.handleRoles)(w, newRequest(http.MethodGet, "/roles", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"editor"`)
+	assert.Contains(t, w.Body.String(), `"permissions":["comics:update","search:read"]`)
+
+	w = httptest.NewRecorder()
+	handleRole(w, newRequest(http.MethodPut, "/roles/editor", "admin", `{"permissions":["comics:update"]}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRole(w, newRequest(http.MethodPut, "/roles/editor", "admin", `{"permissions":["comics:burn"]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRole(w, newRequest(http.MethodDelete, "/roles/editor", "admin", ""))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRole(w, newRequest(http.MethodDelete, "/roles/nobody", "admin", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRole(w, newRequest(http.MethodDelete, "/roles/editor", "moderator", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandleRegister(t *testing.T) {
+	s, _ := newTestServer(t)
+	handleRegister := s.optionalAuth(s.handleRegister)
+
+	w := httptest.NewRecorder()
+	handleRegister(w, newRequest(http.MethodPost, "/register", "", `{"username":"new","password":"password"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRegister(w, newRequest(http.MethodPost, "/register", "", `{"username":"user1","password":"password"}`))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRegister(w, newRequest(http.MethodPost, "/register", "user1", `{"username":"new","password":"password","role":"admin"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRegister(w, newRequest(http.MethodPost, "/register", "admin", `{"username":"new","password":"password","role":"admin"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestHandleRefetchNotFound(t *testing.T) {
+	s, mockService := newTestServer(t)
+	mockService.EXPECT().RefetchComic(gomock.Any(), 100000).Return(core.Comic{}, sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	s.handleComics(w, newRequest(http.MethodPost, "/comics/100000/refetch", "moderator", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleLoginDisabled(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleLogin(w, newRequest(http.MethodPost, "/login", "", `{"username":"disabled","password":"password"}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandleLoginLocked(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleLogin(w, newRequest(http.MethodPost, "/login", "", `{"username":"locked","password":"password"}`))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "90", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "too many failed login attempts")
+}
+
+func TestLoginClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.config.LoginTrustedAddrs = []string{"127.0.0.1"}
+
+	tests := []struct {
+		remote, header, want string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		// чужой адрес не может назвать себя другим клиентом
+		{"192.0.2.1:1234", "telegram:1", "192.0.2.1"},
+		{"127.0.0.1:1234", "telegram:1", "telegram:1"},
+		// доверенный посредник без заголовка считается по своему адресу
+		{"127.0.0.1:1234", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = tt.remote
+		if tt.header != "" {
+			r.Header.Set(loginClientHeader, tt.header)
+		}
+		assert.Equal(t, tt.want, s.loginClient(r), "%s %q", tt.remote, tt.header)
+	}
+
+	// с секретом доверенного адреса мало: заголовок принимается только вместе с секретом
+	s.config.LoginClientSecret = "s3cret"
+	for secret, want := range map[string]string{"": "127.0.0.1", "wrong": "127.0.0.1", "s3cret": "telegram:1"} {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Header.Set(loginClientHeader, "telegram:1")
+		if secret != "" {
+			r.Header.Set(loginClientSecretHeader, secret)
+		}
+		assert.Equal(t, want, s.loginClient(r), "secret %q", secret)
+	}
+}
+
+func TestHandleLockouts(t *testing.T) {
+	s, _ := newTestServer(t)
+	handleLockouts := s.authorized(core.PermUsersManage, s.handleLockouts)
+
+	w := httptest.NewRecorder()
+	handleLockouts(w, newRequest(http.MethodGet, "/lockouts", "user1", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleLockouts(w, newRequest(http.MethodGet, "/lockouts", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var locks []*pb.LoginLock
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&locks))
+	assert.Len(t, locks, 1)
+
+	w = httptest.NewRecorder()
+	handleLockouts(w, newRequest(http.MethodDelete, "/lockouts?username=locked", "admin", ""))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	handleLockouts(w, newRequest(http.MethodDelete, "/lockouts?username=user1", "admin", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handleLockouts(w, newRequest(http.MethodDelete, "/lockouts", "admin", ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleHealth(t *testing.T) {
+	s, mockService := newTestServer(t)
+
+	mockService.EXPECT().Health(gomock.Any()).Return(core.Health{Status: core.HealthDegraded, Storage: core.StorageHealth{Backend: "mysql", Error: "connection refused"}})
+
+	w := httptest.NewRecorder()
+	s.handleHealth(w, newRequest(http.MethodGet, "/health", "", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"degraded"`)
+}
+
+func TestHandleLoginStorageUnavailable(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleLogin(w, newRequest(http.MethodPost, "/login", "", `{"username":"unavailable","password":"password"}`))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestHandleRotateKeys(t *testing.T) {
+	s, _ := newTestServer(t)
+	handleRotateKeys := s.authorized(core.PermKeysManage, s.handleRotateKeys)
+
+	w := httptest.NewRecorder()
+	handleRotateKeys(w, newRequest(http.MethodPost, "/keys/rotate", "user1", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRotateKeys(w, newRequest(http.MethodPost, "/keys/rotate", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res pb.RotateKeysResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, "new", res.Kid)
+	assert.Len(t, res.Keys, 2)
+
+	w = httptest.NewRecorder()
+	handleRotateKeys(w, newRequest(http.MethodPost, "/keys/rotate", "admin", `{"retire":["config"]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "managed by config")
+}
+
+func TestHandleAPIKeys(t *testing.T) {
+	s, _ := newTestServer(t)
+	handleAPIKeys := s.authorized(core.PermUsersManage, s.handleAPIKeys)
+	handleAPIKey := s.authorized(core.PermUsersManage, s.handleAPIKey)
+
+	w := httptest.NewRecorder()
+	handleAPIKeys(w, newRequest(http.MethodPost, "/api-keys", "user1", `{"username":"ci","name":"scheduler","scopes":["comics:ingest"]}`))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleAPIKeys(w, newRequest(http.MethodPost, "/api-keys", "admin", `{"username":"ci","name":"scheduler","scopes":["comics:ingest"],"expires_at":"2030-01-01T00:00:00Z"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created pb.CreateAPIKeyResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "xkcd_ci", created.Key)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), created.Info.ExpiresAt)
+
+	w = httptest.NewRecorder()
+	handleAPIKeys(w, newRequest(http.MethodPost, "/api-keys", "admin", `{"username":"ci","name":"scheduler"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleAPIKeys(w, newRequest(http.MethodGet, "/api-keys?username=ci", "admin", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var keys []*pb.APIKey
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&keys))
+	assert.Len(t, keys, 1)
+
+	w = httptest.NewRecorder()
+	handleAPIKey(w, newRequest(http.MethodDelete, "/api-keys/key1", "admin", ""))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	handleAPIKey(w, newRequest(http.MethodDelete, "/api-keys/key1", "admin", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleJWKS(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleJWKS(w, newRequest(http.MethodGet, "/.well-known/jwks.json", "", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+}
+
+func TestHandleRefreshLogout(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleLogin(w, newRequest(http.MethodPost, "/login", "", `{"username":"user1","password":"password"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	cookies := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	assert.Equal(t, "user1", cookies["token"].Value)
+	assert.Equal(t, "refresh-user1", cookies["refresh_token"].Value)
+	assert.True(t, cookies["refresh_token"].HttpOnly)
+
+	// refresh-токен принимается из cookie и из тела запроса
+	r := newRequest(http.MethodPost, "/refresh", "", "")
+	r.AddCookie(cookies["refresh_token"])
+	w = httptest.NewRecorder()
+	s.handleRefresh(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"refresh_token":"refresh-user1"`)
+
+	w = httptest.NewRecorder()
+	s.handleRefresh(w, newRequest(http.MethodPost, "/refresh", "", `{"refresh_token":"refresh-user1"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.handleRefresh(w, newRequest(http.MethodPost, "/refresh", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = newRequest(http.MethodPost, "/logout", "user1", "")
+	r.AddCookie(cookies["refresh_token"])
+	w = httptest.NewRecorder()
+	s.optionalAuth(s.handleLogout)(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	for _, c := range w.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge, c.Name)
+	}
+	assert.True(t, s.authClient.client.(fakeAuth).revoked["user1"])
+
+	// после выхода refresh-токен больше не действует
+	w = httptest.NewRecorder()
+	s.handleRefresh(w, newRequest(http.MethodPost, "/refresh", "", `{"refresh_token":"refresh-user1"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
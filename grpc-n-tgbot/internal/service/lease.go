@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+)
+
+// ingestionLock - блокировка загрузки комиксов с xkcd.com, общая для всех реплик
+const ingestionLock = "ingestion"
+
+const defaultLockTTL = time.Minute
+
+// instanceID отличает этот процесс от других реплик в таблице блокировок
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// withLock выполняет fn, только если удалось взять блокировку name, и продлевает аренду,
+// пока fn работает. Если аренду перехватили, контекст fn отменяется
+func (s *service) withLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ok, err := s.storage.AcquireLock(ctx, name, s.owner, s.lockTTL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", core.ErrLocked, name)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	done := make(chan struct{})
+	var renewing sync.WaitGroup
+	renewing.Add(1)
+	go func() {
+		defer renewing.Done()
+		ticker := time.NewTicker(s.lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ok, err := s.storage.AcquireLock(ctx, name, s.owner, s.lockTTL)
+			if err != nil {
+				// аренда еще действует, попробуем на следующем тике
+				log.Error().Err(err).Msgf("error renewing lock %s", name)
+				continue
+			}
+			if !ok {
+				log.Error().Msgf("lock %s was taken over by another instance", name)
+				cancel(fmt.Errorf("%w: lease on %s lost", core.ErrLocked, name))
+				return
+			}
+		}
+	}()
+
+	err = fn(ctx)
+	// продление должно закончиться до снятия блокировки, иначе оно может взять ее снова
+	close(done)
+	renewing.Wait()
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancelRelease()
+	if err := s.storage.ReleaseLock(releaseCtx, name, s.owner); err != nil {
+		log.Error().Err(err).Msgf("error releasing lock %s", name)
+	}
+
+	if cause := context.Cause(ctx); err != nil && errors.Is(cause, core.ErrLocked) {
+		return cause
+	}
+	return err
+}
@@ -6,7 +6,9 @@ package mock_service
 
 import (
 	bytes "bytes"
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/sgsoul/internal/core"
@@ -35,16 +37,45 @@ func (m *MockClientXKCD) EXPECT() *MockClientXKCDMockRecorder {
 	return m.recorder
 }
 
+// RefetchComic mocks base method.
+func (m *MockClientXKCD) RefetchComic(ctx context.Context, num int) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefetchComic", ctx, num)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefetchComic indicates an expected call of RefetchComic.
+func (mr *MockClientXKCDMockRecorder) RefetchComic(ctx, num interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefetchComic", reflect.TypeOf((*MockClientXKCD)(nil).RefetchComic), ctx, num)
+}
+
+// ResyncComics mocks base method.
+func (m *MockClientXKCD) ResyncComics(ctx context.Context, workers int) core.ResyncCount {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResyncComics", ctx, workers)
+	ret0, _ := ret[0].(core.ResyncCount)
+	return ret0
+}
+
+// ResyncComics indicates an expected call of ResyncComics.
+func (mr *MockClientXKCDMockRecorder) ResyncComics(ctx, workers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResyncComics", reflect.TypeOf((*MockClientXKCD)(nil).ResyncComics), ctx, workers)
+}
+
 // RunWorkers mocks base method.
-func (m *MockClientXKCD) RunWorkers(workers int) {
+func (m *MockClientXKCD) RunWorkers(ctx context.Context, workers int) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "RunWorkers", workers)
+	m.ctrl.Call(m, "RunWorkers", ctx, workers)
 }
 
 // RunWorkers indicates an expected call of RunWorkers.
-func (mr *MockClientXKCDMockRecorder) RunWorkers(workers interface{}) *gomock.Call {
+func (mr *MockClientXKCDMockRecorder) RunWorkers(ctx, workers interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWorkers", reflect.TypeOf((*MockClientXKCD)(nil).RunWorkers), workers)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWorkers", reflect.TypeOf((*MockClientXKCD)(nil).RunWorkers), ctx, workers)
 }
 
 // MockStorage is a mock of Storage interface.
@@ -70,78 +101,315 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
-// CreateUser mocks base method.
-func (m *MockStorage) CreateUser(username, password, role string) error {
+// AcquireLock mocks base method.
+func (m *MockStorage) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateUser", username, password, role)
+	ret := m.ctrl.Call(m, "AcquireLock", ctx, name, owner, ttl)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AcquireLock indicates an expected call of AcquireLock.
+func (mr *MockStorageMockRecorder) AcquireLock(ctx, name, owner, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcquireLock", reflect.TypeOf((*MockStorage)(nil).AcquireLock), ctx, name, owner, ttl)
+}
+
+// CountIngestionStatuses mocks base method.
+func (m *MockStorage) CountIngestionStatuses(ctx context.Context) (map[string]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountIngestionStatuses", ctx)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountIngestionStatuses indicates an expected call of CountIngestionStatuses.
+func (mr *MockStorageMockRecorder) CountIngestionStatuses(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountIngestionStatuses", reflect.TypeOf((*MockStorage)(nil).CountIngestionStatuses), ctx)
+}
+
+// CountUsers mocks base method.
+func (m *MockStorage) CountUsers(ctx context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountUsers", ctx)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUsers indicates an expected call of CountUsers.
+func (mr *MockStorageMockRecorder) CountUsers(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountUsers", reflect.TypeOf((*MockStorage)(nil).CountUsers), ctx)
+}
+
+// DeleteComic mocks base method.
+func (m *MockStorage) DeleteComic(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteComic", ctx, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// CreateUser indicates an expected call of CreateUser.
-func (mr *MockStorageMockRecorder) CreateUser(username, password, role interface{}) *gomock.Call {
+// DeleteComic indicates an expected call of DeleteComic.
+func (mr *MockStorageMockRecorder) DeleteComic(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockStorage)(nil).CreateUser), username, password, role)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComic", reflect.TypeOf((*MockStorage)(nil).DeleteComic), ctx, id)
+}
+
+// DeleteRole mocks base method.
+func (m *MockStorage) DeleteRole(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRole", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRole indicates an expected call of DeleteRole.
+func (mr *MockStorageMockRecorder) DeleteRole(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRole", reflect.TypeOf((*MockStorage)(nil).DeleteRole), ctx, name)
+}
+
+// DeleteUser mocks base method.
+func (m *MockStorage) DeleteUser(ctx context.Context, username string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, username)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockStorageMockRecorder) DeleteUser(ctx, username interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockStorage)(nil).DeleteUser), ctx, username)
 }
 
 // GetAllComics mocks base method.
-func (m *MockStorage) GetAllComics() ([]core.Comic, error) {
+func (m *MockStorage) GetAllComics(ctx context.Context) ([]core.Comic, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllComics")
+	ret := m.ctrl.Call(m, "GetAllComics", ctx)
 	ret0, _ := ret[0].([]core.Comic)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllComics indicates an expected call of GetAllComics.
-func (mr *MockStorageMockRecorder) GetAllComics() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetAllComics(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllComics", reflect.TypeOf((*MockStorage)(nil).GetAllComics))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllComics", reflect.TypeOf((*MockStorage)(nil).GetAllComics), ctx)
+}
+
+// GetAuditRecords mocks base method.
+func (m *MockStorage) GetAuditRecords(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditRecords", ctx, filter)
+	ret0, _ := ret[0].([]core.AuditRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditRecords indicates an expected call of GetAuditRecords.
+func (mr *MockStorageMockRecorder) GetAuditRecords(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditRecords", reflect.TypeOf((*MockStorage)(nil).GetAuditRecords), ctx, filter)
 }
 
 // GetComicByID mocks base method.
-func (m *MockStorage) GetComicByID(id int) (core.Comic, error) {
+func (m *MockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetComicByID", id)
+	ret := m.ctrl.Call(m, "GetComicByID", ctx, id)
 	ret0, _ := ret[0].(core.Comic)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetComicByID indicates an expected call of GetComicByID.
-func (mr *MockStorageMockRecorder) GetComicByID(id interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetComicByID(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), ctx, id)
+}
+
+// GetComicIDs mocks base method.
+func (m *MockStorage) GetComicIDs(ctx context.Context) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicIDs", ctx)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicIDs indicates an expected call of GetComicIDs.
+func (mr *MockStorageMockRecorder) GetComicIDs(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicIDs", reflect.TypeOf((*MockStorage)(nil).GetComicIDs), ctx)
+}
+
+// GetComicRevisions mocks base method.
+func (m *MockStorage) GetComicRevisions(ctx context.Context, id int) ([]core.ComicRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicRevisions", ctx, id)
+	ret0, _ := ret[0].([]core.ComicRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicRevisions indicates an expected call of GetComicRevisions.
+func (mr *MockStorageMockRecorder) GetComicRevisions(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicRevisions", reflect.TypeOf((*MockStorage)(nil).GetComicRevisions), ctx, id)
+}
+
+// GetComicsToNormalize mocks base method.
+func (m *MockStorage) GetComicsToNormalize(ctx context.Context, afterID, version, limit int) ([]core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetComicsToNormalize", ctx, afterID, version, limit)
+	ret0, _ := ret[0].([]core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetComicsToNormalize indicates an expected call of GetComicsToNormalize.
+func (mr *MockStorageMockRecorder) GetComicsToNormalize(ctx, afterID, version, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicsToNormalize", reflect.TypeOf((*MockStorage)(nil).GetComicsToNormalize), ctx, afterID, version, limit)
 }
 
 // GetCount mocks base method.
-func (m *MockStorage) GetCount() (int, error) {
+func (m *MockStorage) GetCount(ctx context.Context) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCount")
+	ret := m.ctrl.Call(m, "GetCount", ctx)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCount indicates an expected call of GetCount.
-func (mr *MockStorageMockRecorder) GetCount() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetCount(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCount", reflect.TypeOf((*MockStorage)(nil).GetCount), ctx)
+}
+
+// GetIngestionStatuses mocks base method.
+func (m *MockStorage) GetIngestionStatuses(ctx context.Context) ([]core.IngestionStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIngestionStatuses", ctx)
+	ret0, _ := ret[0].([]core.IngestionStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIngestionStatuses indicates an expected call of GetIngestionStatuses.
+func (mr *MockStorageMockRecorder) GetIngestionStatuses(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIngestionStatuses", reflect.TypeOf((*MockStorage)(nil).GetIngestionStatuses), ctx)
+}
+
+// GetLatestComic mocks base method.
+func (m *MockStorage) GetLatestComic(ctx context.Context) (core.Comic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLatestComic", ctx)
+	ret0, _ := ret[0].(core.Comic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLatestComic indicates an expected call of GetLatestComic.
+func (mr *MockStorageMockRecorder) GetLatestComic(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatestComic", reflect.TypeOf((*MockStorage)(nil).GetLatestComic), ctx)
+}
+
+// GetRole mocks base method.
+func (m *MockStorage) GetRole(ctx context.Context, name string) (core.Role, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRole", ctx, name)
+	ret0, _ := ret[0].(core.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRole indicates an expected call of GetRole.
+func (mr *MockStorageMockRecorder) GetRole(ctx, name interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCount", reflect.TypeOf((*MockStorage)(nil).GetCount))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRole", reflect.TypeOf((*MockStorage)(nil).GetRole), ctx, name)
 }
 
 // GetUserByUsername mocks base method.
-func (m *MockStorage) GetUserByUsername(username string) (core.User, error) {
+func (m *MockStorage) GetUserByUsername(ctx context.Context, username string) (core.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserByUsername", username)
+	ret := m.ctrl.Call(m, "GetUserByUsername", ctx, username)
 	ret0, _ := ret[0].(core.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserByUsername indicates an expected call of GetUserByUsername.
-func (mr *MockStorageMockRecorder) GetUserByUsername(username interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetUserByUsername(ctx, username interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByUsername", reflect.TypeOf((*MockStorage)(nil).GetUserByUsername), username)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByUsername", reflect.TypeOf((*MockStorage)(nil).GetUserByUsername), ctx, username)
+}
+
+// Health mocks base method.
+func (m *MockStorage) Health(ctx context.Context) core.StorageHealth {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Health", ctx)
+	ret0, _ := ret[0].(core.StorageHealth)
+	return ret0
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockStorageMockRecorder) Health(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockStorage)(nil).Health), ctx)
+}
+
+// ListPermissions mocks base method.
+func (m *MockStorage) ListPermissions(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPermissions", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPermissions indicates an expected call of ListPermissions.
+func (mr *MockStorageMockRecorder) ListPermissions(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPermissions", reflect.TypeOf((*MockStorage)(nil).ListPermissions), ctx)
+}
+
+// ListRoles mocks base method.
+func (m *MockStorage) ListRoles(ctx context.Context) ([]core.Role, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRoles", ctx)
+	ret0, _ := ret[0].([]core.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRoles indicates an expected call of ListRoles.
+func (mr *MockStorageMockRecorder) ListRoles(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRoles", reflect.TypeOf((*MockStorage)(nil).ListRoles), ctx)
+}
+
+// ListUsers mocks base method.
+func (m *MockStorage) ListUsers(ctx context.Context, limit, offset int) ([]core.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsers", ctx, limit, offset)
+	ret0, _ := ret[0].([]core.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsers indicates an expected call of ListUsers.
+func (mr *MockStorageMockRecorder) ListUsers(ctx, limit, offset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockStorage)(nil).ListUsers), ctx, limit, offset)
 }
 
 // PrettyPrint mocks base method.
@@ -158,16 +426,128 @@ func (mr *MockStorageMockRecorder) PrettyPrint(v interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrettyPrint", reflect.TypeOf((*MockStorage)(nil).PrettyPrint), v)
 }
 
+// ReleaseLock mocks base method.
+func (m *MockStorage) ReleaseLock(ctx context.Context, name, owner string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseLock", ctx, name, owner)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseLock indicates an expected call of ReleaseLock.
+func (mr *MockStorageMockRecorder) ReleaseLock(ctx, name, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseLock", reflect.TypeOf((*MockStorage)(nil).ReleaseLock), ctx, name, owner)
+}
+
+// SaveAuditRecord mocks base method.
+func (m *MockStorage) SaveAuditRecord(ctx context.Context, record core.AuditRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAuditRecord", ctx, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAuditRecord indicates an expected call of SaveAuditRecord.
+func (mr *MockStorageMockRecorder) SaveAuditRecord(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAuditRecord", reflect.TypeOf((*MockStorage)(nil).SaveAuditRecord), ctx, record)
+}
+
+// SaveComicRevision mocks base method.
+func (m *MockStorage) SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveComicRevision", ctx, comic, diff)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveComicRevision indicates an expected call of SaveComicRevision.
+func (mr *MockStorageMockRecorder) SaveComicRevision(ctx, comic, diff interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicRevision", reflect.TypeOf((*MockStorage)(nil).SaveComicRevision), ctx, comic, diff)
+}
+
 // SaveComicToDatabase mocks base method.
-func (m *MockStorage) SaveComicToDatabase(comic core.Comic) error {
+func (m *MockStorage) SaveComicToDatabase(ctx context.Context, comic core.Comic) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveComicToDatabase", comic)
+	ret := m.ctrl.Call(m, "SaveComicToDatabase", ctx, comic)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveComicToDatabase indicates an expected call of SaveComicToDatabase.
-func (mr *MockStorageMockRecorder) SaveComicToDatabase(comic interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) SaveComicToDatabase(ctx, comic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicToDatabase", reflect.TypeOf((*MockStorage)(nil).SaveComicToDatabase), ctx, comic)
+}
+
+// SaveRole mocks base method.
+func (m *MockStorage) SaveRole(ctx context.Context, role core.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRole", ctx, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRole indicates an expected call of SaveRole.
+func (mr *MockStorageMockRecorder) SaveRole(ctx, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRole", reflect.TypeOf((*MockStorage)(nil).SaveRole), ctx, role)
+}
+
+// SetComicHidden mocks base method.
+func (m *MockStorage) SetComicHidden(ctx context.Context, id int, hidden bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetComicHidden", ctx, id, hidden)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetComicHidden indicates an expected call of SetComicHidden.
+func (mr *MockStorageMockRecorder) SetComicHidden(ctx, id, hidden interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetComicHidden", reflect.TypeOf((*MockStorage)(nil).SetComicHidden), ctx, id, hidden)
+}
+
+// SetUserDisabled mocks base method.
+func (m *MockStorage) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserDisabled", ctx, username, disabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserDisabled indicates an expected call of SetUserDisabled.
+func (mr *MockStorageMockRecorder) SetUserDisabled(ctx, username, disabled interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserDisabled", reflect.TypeOf((*MockStorage)(nil).SetUserDisabled), ctx, username, disabled)
+}
+
+// SetUserRole mocks base method.
+func (m *MockStorage) SetUserRole(ctx context.Context, username, role string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserRole", ctx, username, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserRole indicates an expected call of SetUserRole.
+func (mr *MockStorageMockRecorder) SetUserRole(ctx, username, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRole", reflect.TypeOf((*MockStorage)(nil).SetUserRole), ctx, username, role)
+}
+
+// UpdateComicKeywords mocks base method.
+func (m *MockStorage) UpdateComicKeywords(ctx context.Context, id int, keywords string, terms []core.ComicTerm, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateComicKeywords", ctx, id, keywords, terms, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateComicKeywords indicates an expected call of UpdateComicKeywords.
+func (mr *MockStorageMockRecorder) UpdateComicKeywords(ctx, id, keywords, terms, version interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicToDatabase", reflect.TypeOf((*MockStorage)(nil).SaveComicToDatabase), comic)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateComicKeywords", reflect.TypeOf((*MockStorage)(nil).UpdateComicKeywords), ctx, id, keywords, terms, version)
 }
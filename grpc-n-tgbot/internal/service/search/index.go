@@ -1,6 +1,7 @@
 package search
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"sort"
@@ -10,7 +11,7 @@ import (
 	"github.com/sgsoul/internal/core"
 )
 
-func (s *search) RelevantComic(relevantComics map[int]int) ([]core.Comic, error) {
+func (s *search) RelevantComic(ctx context.Context, relevantComics map[int]int) ([]core.Comic, error) {
 	var sortedComics []core.Comic
 
 	// слайс для сортировки
@@ -28,10 +29,17 @@ func (s *search) RelevantComic(relevantComics map[int]int) ([]core.Comic, error)
 	})
 
 	for _, item := range sortedSlice {
-		comic, err := s.storage.GetComicByID(item.Key)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		comic, err := s.storage.GetComicByID(ctx, item.Key)
 		if err != nil {
-			log.Printf("Error getting comic with ID %d: %v\n", item.Key, err)
-			continue
+			cached, ok := s.cachedComic(item.Key)
+			if !ok {
+				log.Printf("Error getting comic with ID %d: %v\n", item.Key, err)
+				continue
+			}
+			comic = cached
 		}
 		sortedComics = append(sortedComics, comic)
 	}
@@ -48,6 +56,10 @@ func IndexSearch(file []byte, normalizedKeywords []string) map[int]int {
 		return nil
 	}
 
+	return searchIndex(index, normalizedKeywords)
+}
+
+func searchIndex(index map[string][]int, normalizedKeywords []string) map[int]int {
 	relevantComics := make(map[int]int)
 	for _, keyword := range normalizedKeywords {
 		if comics, ok := index[keyword]; ok {
@@ -60,11 +72,11 @@ func IndexSearch(file []byte, normalizedKeywords []string) map[int]int {
 	return relevantComics
 }
 
-func (s *search) buildIndex(indexFile string) error {
+func (s *search) buildIndex(ctx context.Context, indexFile string) error {
 	log.Info().Msg("Building index...")
 
 	// Получаем комиксы из базы данных
-	comics, err := s.storage.GetAllComics()
+	comics, err := s.storage.GetAllComics(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("error getting comics from database")
 		return err
@@ -72,12 +84,15 @@ func (s *search) buildIndex(indexFile string) error {
 
 	// Создаем индекс
 	index := make(map[string][]int)
+	byID := make(map[int]core.Comic, len(comics))
 	for _, comic := range comics {
 		keywords := strings.Split(comic.Keywords, ",")
 		for _, keyword := range keywords {
 			index[keyword] = append(index[keyword], comic.ID)
 		}
+		byID[comic.ID] = comic
 	}
+	s.remember(index, byID)
 
 	// Создаем файл индекса
 	file, err := os.Create(indexFile)
@@ -100,8 +115,8 @@ func (s *search) buildIndex(indexFile string) error {
 	return nil
 }
 
-func (s *search) newIndex(indexFile string) ([]byte, error) {
-	err := s.buildIndex(indexFile)
+func (s *search) newIndex(ctx context.Context, indexFile string) ([]byte, error) {
+	err := s.buildIndex(ctx, indexFile)
 	if err != nil {
 		log.Error().Err(err).Msg("error building index")
 		return nil, err
@@ -114,4 +129,22 @@ func (s *search) newIndex(indexFile string) ([]byte, error) {
 	return index, nil
 }
 
+// remember сохраняет построенный индекс для поиска без базы
+func (s *search) remember(index map[string][]int, comics map[int]core.Comic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index, s.comics = index, comics
+}
+
+func (s *search) cachedIndex() map[string][]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index
+}
 
+func (s *search) cachedComic(id int) (core.Comic, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comic, ok := s.comics[id]
+	return comic, ok
+}
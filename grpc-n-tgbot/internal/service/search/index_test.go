@@ -1,6 +1,7 @@
 package search
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -39,14 +40,18 @@ func TestIndexSearch(t *testing.T) {
 
 type MockStorage struct{}
 
-func (m *MockStorage) GetAllComics() ([]core.Comic, error) {
+func (m *MockStorage) GetAllComics(ctx context.Context) ([]core.Comic, error) {
 	return []core.Comic{}, nil
 }
 
-func (m *MockStorage) GetComicByID(id int) (core.Comic, error) {
+func (m *MockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
 	return core.Comic{}, nil
 }
 
+func (m *MockStorage) SearchTerms(ctx context.Context, terms []string) (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
 func TestBuildIndex(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "test")
 	assert.NoError(t, err)
@@ -54,7 +59,7 @@ func TestBuildIndex(t *testing.T) {
 
 	s := &search{storage: &MockStorage{}}
 
-	err = s.buildIndex(tmpDir + "/test_index.json")
+	err = s.buildIndex(context.Background(), tmpDir + "/test_index.json")
 	assert.NoError(t, err)
 
 	indexFile, err := os.ReadFile(tmpDir + "/test_index.json")
@@ -67,7 +72,7 @@ func TestBuildIndex(t *testing.T) {
 
 type mockStorage struct{}
 
-func (m *mockStorage) GetAllComics() ([]core.Comic, error) {
+func (m *mockStorage) GetAllComics(ctx context.Context) ([]core.Comic, error) {
 	return []core.Comic{
 		{ID: 1, Keywords: "apple,pie"},
 		{ID: 2, Keywords: "pie"},
@@ -77,8 +82,8 @@ func (m *mockStorage) GetAllComics() ([]core.Comic, error) {
 	}, nil
 }
 
-func (m *mockStorage) GetComicByID(id int) (core.Comic, error) {
-	comics, err := m.GetAllComics()
+func (m *mockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
+	comics, err := m.GetAllComics(ctx)
 	if err != nil {
 		return core.Comic{}, err
 	}
@@ -90,6 +95,10 @@ func (m *mockStorage) GetComicByID(id int) (core.Comic, error) {
 	return core.Comic{}, fmt.Errorf("comic with ID %d not found", id)
 }
 
+func (m *mockStorage) SearchTerms(ctx context.Context, terms []string) (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
 var yourMockStorageImplementation = &mockStorage{}
 
 func TestNewIndex(t *testing.T) {
@@ -119,7 +128,7 @@ func TestNewIndex(t *testing.T) {
 		t.Fatalf("error writing test index to file: %v", err)
 	}
 
-	indexBytes, err := s.newIndex(tmpfile.Name())
+	indexBytes, err := s.newIndex(context.Background(), tmpfile.Name())
 	assert.NoError(t, err)
 
 	var expectedIndex, actualIndex map[string][]int
@@ -165,7 +174,7 @@ func TestRelevantComic(t *testing.T) {
 		{ID: 3, Keywords: "apple"},
 	}
 
-	sortedComics, err := s.RelevantComic(relevantComics)
+	sortedComics, err := s.RelevantComic(context.Background(), relevantComics)
 	if err != nil {
 		t.Fatalf("error getting relevant comics: %v", err)
 	}
@@ -5,6 +5,7 @@
 package mock_search
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,31 +36,46 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 }
 
 // GetAllComics mocks base method.
-func (m *MockStorage) GetAllComics() ([]core.Comic, error) {
+func (m *MockStorage) GetAllComics(ctx context.Context) ([]core.Comic, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllComics")
+	ret := m.ctrl.Call(m, "GetAllComics", ctx)
 	ret0, _ := ret[0].([]core.Comic)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllComics indicates an expected call of GetAllComics.
-func (mr *MockStorageMockRecorder) GetAllComics() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetAllComics(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllComics", reflect.TypeOf((*MockStorage)(nil).GetAllComics))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllComics", reflect.TypeOf((*MockStorage)(nil).GetAllComics), ctx)
 }
 
 // GetComicByID mocks base method.
-func (m *MockStorage) GetComicByID(id int) (core.Comic, error) {
+func (m *MockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetComicByID", id)
+	ret := m.ctrl.Call(m, "GetComicByID", ctx, id)
 	ret0, _ := ret[0].(core.Comic)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetComicByID indicates an expected call of GetComicByID.
-func (mr *MockStorageMockRecorder) GetComicByID(id interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetComicByID(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), ctx, id)
+}
+
+// SearchTerms mocks base method.
+func (m *MockStorage) SearchTerms(ctx context.Context, terms []string) (map[int]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchTerms", ctx, terms)
+	ret0, _ := ret[0].(map[int]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchTerms indicates an expected call of SearchTerms.
+func (mr *MockStorageMockRecorder) SearchTerms(ctx, terms interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchTerms", reflect.TypeOf((*MockStorage)(nil).SearchTerms), ctx, terms)
 }
@@ -1,8 +1,10 @@
 package search
 
 import (
+	"context"
 	"sort"
 	"strings"
+	"sync"
 
 	log "github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
@@ -12,12 +14,19 @@ import (
 //go:generate mockgen -source=search.go -destination=mocks/mock.go
 
 type Storage interface {
-	GetAllComics() ([]core.Comic, error)
-	GetComicByID(id int) (core.Comic, error)
+	GetAllComics(ctx context.Context) ([]core.Comic, error)
+	GetComicByID(ctx context.Context, id int) (core.Comic, error)
+	SearchTerms(ctx context.Context, terms []string) (map[int]int, error)
 }
 
 type search struct {
 	storage Storage
+
+	// index и comics - последний построенный индекс и комиксы из него;
+	// по ним ищем, пока база недоступна
+	mu     sync.RWMutex
+	index  map[string][]int
+	comics map[int]core.Comic
 }
 
 func NewSearch(st Storage) *search { //??
@@ -26,10 +35,10 @@ func NewSearch(st Storage) *search { //??
 	}
 }
 
-func (s *search) FindRelevantComics(keywords []string) ([]core.Comic, error) {
+func (s *search) FindRelevantComics(ctx context.Context, keywords []string) ([]core.Comic, error) {
 	var relevantComics []core.Comic
 
-	comics, err := s.storage.GetAllComics()
+	comics, err := s.storage.GetAllComics(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -66,12 +75,32 @@ func countMatchingKeywords(comic core.Comic, keywords []string) int {
 	return count
 }
 
-func (s *search) RelevantURLS(str string, indexFile string) ([]string, []core.Comic) {
+func (s *search) RebuildIndex(ctx context.Context, indexFile string) error {
+	return s.buildIndex(ctx, indexFile)
+}
+
+func (s *search) RelevantURLS(ctx context.Context, str string, indexFile string) ([]string, []core.Comic) {
 	normalizedKeywords := words.NormalizeWords(str)
 
-	index, _ := s.newIndex(indexFile)
+	var matches map[int]int
+	index, err := s.newIndex(ctx, indexFile)
+	if err == nil {
+		matches = IndexSearch(index, normalizedKeywords)
+	} else if cached := s.cachedIndex(); cached != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Msg("database is unavailable, searching the last built index")
+		matches = searchIndex(cached, normalizedKeywords)
+	}
+	// индекс недоступен - ищем по comic_terms в базе
+	if matches == nil && ctx.Err() == nil {
+		log.Warn().Msg("index is unavailable, searching in database")
+		matches, err = s.storage.SearchTerms(ctx, normalizedKeywords)
+		if err != nil {
+			log.Error().Err(err).Msg("error searching comic terms")
+			return nil, nil
+		}
+	}
 
-	relevantComics, err := s.RelevantComic(IndexSearch(index, normalizedKeywords))
+	relevantComics, err := s.RelevantComic(ctx, matches)
 	if err != nil {
 		log.Error().Msg("error ")
 		return nil, nil
@@ -1,6 +1,9 @@
 package search
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -18,16 +21,16 @@ func TestRelevantURLS(t *testing.T) {
 
 	mockStorage := mocks.NewMockStorage(ctrl)
 
-	mockStorage.EXPECT().GetAllComics().Return([]core.Comic{
+	mockStorage.EXPECT().GetAllComics(gomock.Any()).Return([]core.Comic{
 		{ID: 1, URL: "comic1URL", Keywords: "apple,pie"},
 		{ID: 2, URL: "comic2URL", Keywords: "apple,dock"},
 	}, nil)
 
-	mockStorage.EXPECT().GetComicByID(1).Return(core.Comic{ID: 1, URL: "comic1URL"}, nil)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(core.Comic{ID: 1, URL: "comic1URL"}, nil)
 
 	s := &search{storage: mockStorage}
 
-	urls, comics := s.RelevantURLS("apple pie", "index.json")
+	urls, comics := s.RelevantURLS(context.Background(), "apple pie", "index.json")
 	defer os.Remove("index.json")
 
 	expectedURLs := []string{"comic1URL"}
@@ -43,13 +46,60 @@ func TestRelevantURLS(t *testing.T) {
 	}
 }
 
+func TestRelevantURLSFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+
+	// индекс не строится - поиск уходит в comic_terms
+	mockStorage.EXPECT().GetAllComics(gomock.Any()).Return(nil, errors.New("index storage is unavailable"))
+	mockStorage.EXPECT().SearchTerms(gomock.Any(), []string{"appl", "pie"}).Return(map[int]int{1: 2, 2: 1}, nil)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(core.Comic{ID: 1, URL: "comic1URL"}, nil)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 2).Return(core.Comic{ID: 2, URL: "comic2URL"}, nil)
+
+	s := &search{storage: mockStorage}
+
+	urls, _ := s.RelevantURLS(context.Background(), "apple pie", "index.json")
+	defer os.Remove("index.json")
+
+	assert.Equal(t, []string{"comic1URL", "comic2URL"}, urls)
+}
+
+func TestRelevantURLSDegraded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	unavailable := fmt.Errorf("%w: connection refused", core.ErrStorageUnavailable)
+
+	gomock.InOrder(
+		mockStorage.EXPECT().GetAllComics(gomock.Any()).Return([]core.Comic{
+			{ID: 1, URL: "comic1URL", Keywords: "appl,pie"},
+			{ID: 2, URL: "comic2URL", Keywords: "pie"},
+		}, nil),
+		// база упала после того, как индекс был построен
+		mockStorage.EXPECT().GetAllComics(gomock.Any()).Return(nil, unavailable),
+	)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), gomock.Any()).Return(core.Comic{}, unavailable).AnyTimes()
+
+	s := &search{storage: mockStorage}
+	defer os.Remove("index.json")
+
+	assert.NoError(t, s.RebuildIndex(context.Background(), "index.json"))
+
+	// поиск идет по индексу и комиксам в памяти, SearchTerms не вызывается
+	urls, _ := s.RelevantURLS(context.Background(), "apple pie", "index.json")
+	assert.Equal(t, []string{"comic1URL", "comic2URL"}, urls)
+}
+
 func TestFindRelevantComics(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockStorage := mocks.NewMockStorage(ctrl)
 
-	mockStorage.EXPECT().GetAllComics().Return([]core.Comic{
+	mockStorage.EXPECT().GetAllComics(gomock.Any()).Return([]core.Comic{
 		{ID: 1, Keywords: "apple, doctor"},
 		{ID: 2, Keywords: "apple, pie"},
 		{ID: 3, Keywords: "brush"},
@@ -57,7 +107,7 @@ func TestFindRelevantComics(t *testing.T) {
 
 	s := &search{storage: mockStorage}
 
-	relevantComics, err := s.FindRelevantComics([]string{"apple,pie"})
+	relevantComics, err := s.FindRelevantComics(context.Background(), []string{"apple,pie"})
 
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
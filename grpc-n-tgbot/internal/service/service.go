@@ -2,15 +2,21 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jasonlvhit/gocron"
 	log "github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/words"
+	"github.com/sgsoul/internal/xkcd"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
 )
@@ -18,30 +24,66 @@ import (
 //go:generate mockgen -source=service.go -destination=mocks/mock.go
 
 type ClientXKCD interface {
-	RunWorkers(workers int)
+	RunWorkers(ctx context.Context, workers int)
+	ResyncComics(ctx context.Context, workers int) core.ResyncCount
+	RefetchComic(ctx context.Context, num int) (core.Comic, error)
 }
 
 type Storage interface {
-	GetCount() (int, error)
-	CreateUser(username, password, role string) error
+	GetCount(ctx context.Context) (int, error)
 	PrettyPrint(v []core.Comic) bytes.Buffer
-	GetAllComics() ([]core.Comic, error)
-	GetComicByID(id int) (core.Comic, error)
-	GetUserByUsername(username string) (core.User, error)
-	SaveComicToDatabase(comic core.Comic) error
+	GetAllComics(ctx context.Context) ([]core.Comic, error)
+	GetComicByID(ctx context.Context, id int) (core.Comic, error)
+	GetLatestComic(ctx context.Context) (core.Comic, error)
+	GetUserByUsername(ctx context.Context, username string) (core.User, error)
+	ListUsers(ctx context.Context, limit, offset int) ([]core.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	SetUserRole(ctx context.Context, username, role string) error
+	SetUserDisabled(ctx context.Context, username string, disabled bool) error
+	DeleteUser(ctx context.Context, username string) error
+	GetRole(ctx context.Context, name string) (core.Role, error)
+	ListRoles(ctx context.Context) ([]core.Role, error)
+	ListPermissions(ctx context.Context) ([]string, error)
+	SaveRole(ctx context.Context, role core.Role) error
+	DeleteRole(ctx context.Context, name string) error
+	SaveAuditRecord(ctx context.Context, record core.AuditRecord) error
+	GetAuditRecords(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error)
+	Health(ctx context.Context) core.StorageHealth
+	AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, name, owner string) error
+	SaveComicToDatabase(ctx context.Context, comic core.Comic) error
+	GetComicsToNormalize(ctx context.Context, afterID, version, limit int) ([]core.Comic, error)
+	UpdateComicKeywords(ctx context.Context, id int, keywords string, terms []core.ComicTerm, version int) error
+	GetComicRevisions(ctx context.Context, id int) ([]core.ComicRevision, error)
+	SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error
+	SetComicHidden(ctx context.Context, id int, hidden bool) error
+	DeleteComic(ctx context.Context, id int) error
+	GetComicIDs(ctx context.Context) ([]int, error)
+	GetIngestionStatuses(ctx context.Context) ([]core.IngestionStatus, error)
+	CountIngestionStatuses(ctx context.Context) (map[string]int, error)
 }
 
 type service struct {
 	storage Storage
 	client  ClientXKCD
+	// owner и lockTTL - имя этой реплики и срок аренды блокировок в базе
+	owner   string
+	lockTTL time.Duration
 }
 
 func NewService(cfg *core.Config, st Storage, cl ClientXKCD) *service { //??
 	initConcurrencyLimiter(int64(cfg.ConcLim))
 
+	lockTTL := time.Duration(cfg.LockTTL) * time.Second
+	if lockTTL <= 0 {
+		lockTTL = defaultLockTTL
+	}
+
 	return &service{
 		client:  cl,
 		storage: st,
+		owner:   instanceID(),
+		lockTTL: lockTTL,
 	}
 }
 
@@ -64,9 +106,10 @@ func (s *service) GetRateLimiter(ip string, rps int) *rate.Limiter {
 	return limiter
 }
 
-func StartScheduler(hour int, min int, port int) {
+// StartScheduler раз в час запускает /update от имени ключа API apiKey; без ключа сервер ответит 401
+func StartScheduler(hour int, min int, port int, apiKey string) {
 	t := time.Date(time.Now().Year(), time.Now().Month(), time.Now().Day(), hour, min, 0, 0, time.FixedZone("UTC+3", 3*60*60))
-	err := gocron.Every(1).Hour().From(&t).Do(updateServer, port)
+	err := gocron.Every(1).Hour().From(&t).Do(updateServer, port, apiKey)
 	if err != nil {
 		return
 	}
@@ -74,16 +117,27 @@ func StartScheduler(hour int, min int, port int) {
 	<-gocron.Start()
 }
 
-func updateServer(port int) {
+func updateServer(port int, apiKey string) {
 	log.Info().Msg("Scheduled database update")
 
 	url := fmt.Sprintf("http://localhost:%d/update", port)
-	resp, err := http.Post(url, "", nil)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating request")
+		return
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Error().Err(err).Msg("Error executing request")
 		return
 	}
 	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Error().Int("status", resp.StatusCode).Msg("Scheduled update failed")
+	}
 }
 
 func (s *service) Decode(w http.ResponseWriter, r *http.Request, v any) {
@@ -93,20 +147,214 @@ func (s *service) Decode(w http.ResponseWriter, r *http.Request, v any) {
 	}
 }
 
-func (s *service) UpdateDatabase(workers int) (core.ComicCount, error) {
-	loadedComicsCountBefore, _ := s.storage.GetCount()
+// UpdateDatabase загружает новые комиксы; одновременно это делает только одна реплика
+func (s *service) UpdateDatabase(ctx context.Context, workers int) (core.ComicCount, error) {
+	var response core.ComicCount
+	err := s.withLock(ctx, ingestionLock, func(ctx context.Context) error {
+		loadedComicsCountBefore, _ := s.storage.GetCount(ctx)
+
+		s.client.RunWorkers(ctx, workers)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		loadedComicsCountAfter, _ := s.storage.GetCount(ctx)
+
+		updatedComicsCount := loadedComicsCountAfter - loadedComicsCountBefore
+
+		// исходы по всем номерам, чтобы было видно, почему итог не сходится с последним номером
+		statuses, err := s.storage.CountIngestionStatuses(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("error counting ingestion statuses")
+		}
+
+		response = core.ComicCount{
+			UpdatedComics: updatedComicsCount,
+			TotalComics:   loadedComicsCountAfter,
+			Statuses:      statuses,
+		}
+		return nil
+	})
+	if err != nil {
+		return core.ComicCount{}, err
+	}
+	return response, nil
+}
+
+// IngestionReport собирает пропуски в последовательности номеров вместе с причинами
+func (s *service) IngestionReport(ctx context.Context) (core.IngestionReport, error) {
+	ids, err := s.storage.GetComicIDs(ctx)
+	if err != nil {
+		return core.IngestionReport{}, err
+	}
+	statuses, err := s.storage.GetIngestionStatuses(ctx)
+	if err != nil {
+		return core.IngestionReport{}, err
+	}
+
+	report := core.IngestionReport{
+		StoredComics: len(ids),
+		Statuses:     make(map[string]int),
+		Gaps:         []core.ComicGap{},
+	}
+
+	stored := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		stored[id] = true
+		report.LatestComic = max(report.LatestComic, id)
+	}
+	byNum := make(map[int]core.IngestionStatus, len(statuses))
+	for _, status := range statuses {
+		byNum[status.Num] = status
+		report.Statuses[status.Status]++
+		report.LatestComic = max(report.LatestComic, status.Num)
+	}
+
+	for num := 1; num <= report.LatestComic; num++ {
+		if stored[num] {
+			continue
+		}
+		status, ok := byNum[num]
+		if !ok {
+			status = core.IngestionStatus{Status: "unknown"}
+		}
+
+		// соседние номера с одинаковым исходом объединяются в один диапазон
+		if n := len(report.Gaps); n > 0 {
+			last := &report.Gaps[n-1]
+			if last.To == num-1 && last.Status == status.Status && last.Error == status.Error {
+				last.To = num
+				last.Attempts = max(last.Attempts, status.Attempts)
+				continue
+			}
+		}
+		report.Gaps = append(report.Gaps, core.ComicGap{
+			From:     num,
+			To:       num,
+			Status:   status.Status,
+			Attempts: status.Attempts,
+			Error:    status.Error,
+		})
+	}
+	return report, nil
+}
+
+func (s *service) ResyncDatabase(ctx context.Context, workers int) (core.ResyncCount, error) {
+	var count core.ResyncCount
+	err := s.withLock(ctx, ingestionLock, func(ctx context.Context) error {
+		count = s.client.ResyncComics(ctx, workers)
+		return ctx.Err()
+	})
+	return count, err
+}
+
+// GetComicInfo возвращает комикс в формате xkcd.com; num == 0 означает последний комикс.
+// Скрытые комиксы не отдаются, как будто их нет
+func (s *service) GetComicInfo(ctx context.Context, num int) (core.ComicInfo, error) {
+	var comic core.Comic
+	var err error
+	if num == 0 {
+		comic, err = s.storage.GetLatestComic(ctx)
+	} else {
+		comic, err = s.storage.GetComicByID(ctx, num)
+	}
+	if err != nil {
+		return core.ComicInfo{}, err
+	}
+	if comic.Hidden {
+		return core.ComicInfo{}, sql.ErrNoRows
+	}
+	return xkcd.InfoFromComic(comic), nil
+}
+
+func (s *service) GetComicRevisionsService(ctx context.Context, id int) ([]core.ComicRevision, error) {
+	return s.storage.GetComicRevisions(ctx, id)
+}
+
+// GetComic возвращает сохраненный комикс, включая скрытые
+func (s *service) GetComic(ctx context.Context, num int) (core.Comic, error) {
+	return s.storage.GetComicByID(ctx, num)
+}
+
+// UpdateComic применяет ручную правку и сохраняет ее как ревизию комикса
+func (s *service) UpdateComic(ctx context.Context, num int, patch core.ComicPatch) (core.Comic, error) {
+	comic, err := s.storage.GetComicByID(ctx, num)
+	if err != nil {
+		return core.Comic{}, err
+	}
+
+	if patch.Title != nil || patch.Alt != nil || patch.Transcript != nil || patch.Keywords != nil {
+		var diff string
+		comic, diff = xkcd.ApplyPatch(comic, patch, time.Now())
+		if err := s.storage.SaveComicRevision(ctx, comic, diff); err != nil {
+			return core.Comic{}, err
+		}
+	}
+
+	if patch.Hidden != nil && *patch.Hidden != comic.Hidden {
+		if err := s.storage.SetComicHidden(ctx, num, *patch.Hidden); err != nil {
+			return core.Comic{}, err
+		}
+		comic.Hidden = *patch.Hidden
+	}
+	return comic, nil
+}
+
+// DeleteComic скрывает комикс, а при hard удаляет его из базы
+func (s *service) DeleteComic(ctx context.Context, num int, hard bool) error {
+	if hard {
+		return s.storage.DeleteComic(ctx, num)
+	}
+	return s.storage.SetComicHidden(ctx, num, true)
+}
+
+// RefetchComic заново загружает комикс с xkcd.com, отменяя ручные правки.
+// Номер, которого нет на xkcd.com, возвращает sql.ErrNoRows, как и отсутствующий в базе комикс
+func (s *service) RefetchComic(ctx context.Context, num int) (core.Comic, error) {
+	comic, err := s.client.RefetchComic(ctx, num)
+	if errors.Is(err, xkcd.ErrComicNotFound) {
+		return core.Comic{}, fmt.Errorf("%w: %v", sql.ErrNoRows, err)
+	}
+	return comic, err
+}
+
+const defaultNormalizeBatch = 100
 
-	s.client.RunWorkers(workers)
+// NormalizeComics пересчитывает ключевые слова из сохраненного исходного текста
+// для всех комиксов, нормализованных более старой версией words.NormalizeWords
+func (s *service) NormalizeComics(ctx context.Context, batch int) (core.NormalizeCount, error) {
+	if batch <= 0 {
+		batch = defaultNormalizeBatch
+	}
 
-	loadedComicsCountAfter, _ := s.storage.GetCount()
+	response := core.NormalizeCount{Version: words.Version}
+	lastID := 0
+	for {
+		comics, err := s.storage.GetComicsToNormalize(ctx, lastID, words.Version, batch)
+		if err != nil {
+			return response, err
+		}
+		if len(comics) == 0 {
+			break
+		}
 
-	updatedComicsCount := loadedComicsCountAfter - loadedComicsCountBefore
+		for _, comic := range comics {
+			lastID = comic.ID
+			// комиксы без исходного текста можно исправить только повторной загрузкой
+			if comic.Title == "" && comic.Alt == "" && comic.Transcript == "" {
+				response.SkippedComics++
+				continue
+			}
 
-	response := core.ComicCount{
-		UpdatedComics: updatedComicsCount,
-		TotalComics:   loadedComicsCountAfter,
+			keywords := strings.Join(words.NormalizeWords(comic.Title, comic.Transcript, comic.Alt), ",")
+			if err := s.storage.UpdateComicKeywords(ctx, comic.ID, keywords, xkcd.ComicTerms(comic), words.Version); err != nil {
+				return response, err
+			}
+			response.NormalizedComics++
+		}
 	}
 
+	log.Info().Msgf("Normalized %d comics, skipped %d", response.NormalizedComics, response.SkippedComics)
 	return response, nil
 }
 
@@ -114,17 +362,132 @@ func (s *service) PrettyPrintService(comics []core.Comic) bytes.Buffer {
 	return s.storage.PrettyPrint(comics)
 }
 
-func (s *service) GetUserByUsernameService(username string) (core.User, error) {
-	return s.storage.GetUserByUsername(username)
+// GetUserByUsernameService возвращает пользователя без хеша пароля: паролями занимается сервис авторизации
+func (s *service) GetUserByUsernameService(ctx context.Context, username string) (core.User, error) {
+	user, err := s.storage.GetUserByUsername(ctx, username)
+	user.Password = ""
+	return user, err
+}
+
+func (s *service) ListUsersService(ctx context.Context, limit, offset int) (core.UserList, error) {
+	users, err := s.storage.ListUsers(ctx, limit, offset)
+	if err != nil {
+		return core.UserList{}, err
+	}
+	total, err := s.storage.CountUsers(ctx)
+	if err != nil {
+		return core.UserList{}, err
+	}
+	if users == nil {
+		users = []core.User{}
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+	return core.UserList{Users: users, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// UpdateUserService меняет роль и блокировку пользователя и возвращает его новое состояние
+func (s *service) UpdateUserService(ctx context.Context, username string, patch core.UserPatch) (core.User, error) {
+	if patch.Role != nil {
+		if _, err := s.storage.GetRole(ctx, *patch.Role); errors.Is(err, sql.ErrNoRows) {
+			return core.User{}, fmt.Errorf("%w: %q", core.ErrUnknownRole, *patch.Role)
+		} else if err != nil {
+			return core.User{}, err
+		}
+
+		if err := s.storage.SetUserRole(ctx, username, *patch.Role); err != nil {
+			return core.User{}, err
+		}
+	}
+	if patch.Disabled != nil {
+		if err := s.storage.SetUserDisabled(ctx, username, *patch.Disabled); err != nil {
+			return core.User{}, err
+		}
+	}
+	return s.GetUserByUsernameService(ctx, username)
+}
+
+// DeleteUserService блокирует пользователя, а при hard удаляет его из базы
+func (s *service) DeleteUserService(ctx context.Context, username string, hard bool) error {
+	if hard {
+		return s.storage.DeleteUser(ctx, username)
+	}
+	return s.storage.SetUserDisabled(ctx, username, true)
+}
+
+// ListRoles возвращает все роли с их правами и список прав, допустимых в SaveRole
+func (s *service) ListRoles(ctx context.Context) (core.RoleList, error) {
+	roles, err := s.storage.ListRoles(ctx)
+	if err != nil {
+		return core.RoleList{}, err
+	}
+	permissions, err := s.storage.ListPermissions(ctx)
+	if err != nil {
+		return core.RoleList{}, err
+	}
+	if roles == nil {
+		roles = []core.Role{}
+	}
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return core.RoleList{Roles: roles, Permissions: permissions}, nil
+}
+
+// SaveRole создает роль или заменяет ее права; права admin не меняются, чтобы нельзя было потерять управление
+func (s *service) SaveRole(ctx context.Context, role core.Role) (core.Role, error) {
+	if !core.ValidRoleName(role.Name) {
+		return core.Role{}, fmt.Errorf("%w: invalid name %q", core.ErrUnknownRole, role.Name)
+	}
+	if role.Name == core.RoleAdmin {
+		return core.Role{}, core.ErrBuiltinRole
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	if err := s.storage.SaveRole(ctx, role); err != nil {
+		return core.Role{}, err
+	}
+	return s.storage.GetRole(ctx, role.Name)
+}
+
+// DeleteRole удаляет роль, которая никому не назначена; встроенные роли не удаляются
+func (s *service) DeleteRole(ctx context.Context, name string) error {
+	if core.BuiltinRole(name) {
+		return core.ErrBuiltinRole
+	}
+	return s.storage.DeleteRole(ctx, name)
 }
 
-func (s *service) CreateUserService(username, password, role string) error {
-	if err := s.storage.CreateUser(username, password, role); err != nil {
-		log.Printf("Failed to save user to the database: %v", err)
-		return err
+// SaveAuditRecord пишет запись в журнал аудита
+func (s *service) SaveAuditRecord(ctx context.Context, record core.AuditRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
 	}
-	return nil
+	// TIMESTAMP в MySQL хранит секунды, а фильтры по времени должны давать одно и то же на всех бэкендах
+	record.CreatedAt = record.CreatedAt.UTC().Truncate(time.Second)
+	return s.storage.SaveAuditRecord(ctx, record)
+}
 
+func (s *service) AuditLog(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error) {
+	records, err := s.storage.GetAuditRecords(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = []core.AuditRecord{}
+	}
+	return records, nil
+}
+
+// Health сообщает, работает ли сервис полностью или только по кешу поиска
+func (s *service) Health(ctx context.Context) core.Health {
+	health := core.Health{Status: core.HealthOK, Storage: s.storage.Health(ctx)}
+	if !health.Storage.Available {
+		health.Status = core.HealthDegraded
+	}
+	return health
 }
 
 func (s *service) LimitedHandlerService(handler http.HandlerFunc) http.HandlerFunc {
@@ -1,15 +1,21 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/sgsoul/internal/core"
 	mocks "github.com/sgsoul/internal/service/mocks"
+	"github.com/sgsoul/internal/xkcd"
+	"github.com/sgsoul/internal/words"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/time/rate"
 )
@@ -24,15 +30,162 @@ func TestUpdateDatabase(t *testing.T) {
 	comicsBefore := 5
 	comicsAfter := 10
 
-	mockStorage.EXPECT().GetCount().Return(comicsBefore, nil).Times(1)
-	mockClient.EXPECT().RunWorkers(2).Times(1)
-	mockStorage.EXPECT().GetCount().Return(comicsAfter, nil).Times(1)
+	mockStorage.EXPECT().AcquireLock(gomock.Any(), ingestionLock, gomock.Any(), defaultLockTTL).Return(true, nil)
+	mockStorage.EXPECT().ReleaseLock(gomock.Any(), ingestionLock, gomock.Any()).Return(nil)
+	mockStorage.EXPECT().GetCount(gomock.Any()).Return(comicsBefore, nil).Times(1)
+	mockClient.EXPECT().RunWorkers(gomock.Any(), 2).Times(1)
+	mockStorage.EXPECT().GetCount(gomock.Any()).Return(comicsAfter, nil).Times(1)
+	mockStorage.EXPECT().CountIngestionStatuses(gomock.Any()).Return(map[string]int{core.StatusOK: 10, core.StatusNotFound: 1}, nil)
 
 	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
 
-	result, err := s.UpdateDatabase(2)
+	result, err := s.UpdateDatabase(context.Background(), 2)
 	assert.NoError(t, err)
-	assert.Equal(t, core.ComicCount{UpdatedComics: 5, TotalComics: 10}, result)
+	assert.Equal(t, core.ComicCount{
+		UpdatedComics: 5,
+		TotalComics:   10,
+		Statuses:      map[string]int{core.StatusOK: 10, core.StatusNotFound: 1},
+	}, result)
+}
+
+func TestUpdateDatabaseLocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	// загрузку уже выполняет другая реплика - RunWorkers не вызывается
+	mockStorage.EXPECT().AcquireLock(gomock.Any(), ingestionLock, gomock.Any(), gomock.Any()).Return(false, nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	_, err := s.UpdateDatabase(context.Background(), 2)
+	assert.ErrorIs(t, err, core.ErrLocked)
+}
+
+func TestLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	gomock.InOrder(
+		mockStorage.EXPECT().AcquireLock(gomock.Any(), ingestionLock, gomock.Any(), gomock.Any()).Return(true, nil),
+		// продление не удалось: аренду перехватили
+		mockStorage.EXPECT().AcquireLock(gomock.Any(), ingestionLock, gomock.Any(), gomock.Any()).Return(false, nil),
+	)
+	mockStorage.EXPECT().ReleaseLock(gomock.Any(), ingestionLock, gomock.Any()).Return(nil)
+	mockClient.EXPECT().ResyncComics(gomock.Any(), 2).DoAndReturn(func(ctx context.Context, _ int) core.ResyncCount {
+		<-ctx.Done()
+		return core.ResyncCount{}
+	})
+
+	s := NewService(&core.Config{ConcLim: 10, LockTTL: 1}, mockStorage, mockClient)
+	s.lockTTL = 30 * time.Millisecond
+
+	_, err := s.ResyncDatabase(context.Background(), 2)
+	assert.ErrorIs(t, err, core.ErrLocked)
+}
+
+func TestIngestionReport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	mockStorage.EXPECT().GetComicIDs(gomock.Any()).Return([]int{1, 2, 5, 8}, nil)
+	mockStorage.EXPECT().GetIngestionStatuses(gomock.Any()).Return([]core.IngestionStatus{
+		{Num: 3, Status: core.StatusFetchError, Attempts: 1, Error: "timeout"},
+		{Num: 4, Status: core.StatusFetchError, Attempts: 2, Error: "timeout"},
+		{Num: 7, Status: core.StatusNotFound, Attempts: 3},
+		{Num: 9, Status: core.StatusDecodeError, Attempts: 1},
+	}, nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	report, err := s.IngestionReport(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 9, report.LatestComic)
+	assert.Equal(t, 4, report.StoredComics)
+	assert.Equal(t, []core.ComicGap{
+		{From: 3, To: 4, Status: core.StatusFetchError, Attempts: 2, Error: "timeout"},
+		{From: 6, To: 6, Status: "unknown"},
+		{From: 7, To: 7, Status: core.StatusNotFound, Attempts: 3},
+		{From: 9, To: 9, Status: core.StatusDecodeError, Attempts: 1},
+	}, report.Gaps)
+}
+
+func TestNormalizeComics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	stale := []core.Comic{
+		{ID: 1, Title: "Apple", Alt: "a day keeps the doctor away"},
+		{ID: 2, URL: "test.xkcd/2", Keywords: "old"},
+	}
+
+	gomock.InOrder(
+		mockStorage.EXPECT().GetComicsToNormalize(gomock.Any(), 0, words.Version, 2).Return(stale, nil),
+		mockStorage.EXPECT().UpdateComicKeywords(gomock.Any(), 1, "appl,day,keep,doctor,away", xkcd.ComicTerms(stale[0]), words.Version).Return(nil),
+		mockStorage.EXPECT().GetComicsToNormalize(gomock.Any(), 2, words.Version, 2).Return(nil, nil),
+	)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	result, err := s.NormalizeComics(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, core.NormalizeCount{NormalizedComics: 1, SkippedComics: 1, Version: words.Version}, result)
+}
+
+func TestUpdateComic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	stored := core.Comic{ID: 1, URL: "test.xkcd/1", Keywords: "poison", Transcript: "poison"}
+	keywords := []string{"apple", "doctor"}
+	hidden := true
+
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(stored, nil)
+	mockStorage.EXPECT().SaveComicRevision(gomock.Any(), gomock.Any(), "--- keywords\n+++ keywords\n-poison\n+appl,doctor\n").
+		DoAndReturn(func(_ context.Context, comic core.Comic, diff string) error {
+			assert.Equal(t, "appl,doctor", comic.Keywords)
+			assert.Equal(t, "poison", comic.Transcript)
+			assert.False(t, comic.EditedAt.IsZero())
+			return nil
+		})
+	mockStorage.EXPECT().SetComicHidden(gomock.Any(), 1, true).Return(nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	comic, err := s.UpdateComic(context.Background(), 1, core.ComicPatch{Keywords: &keywords, Hidden: &hidden})
+	assert.NoError(t, err)
+	assert.True(t, comic.Hidden)
+	assert.Equal(t, "appl,doctor", comic.Keywords)
+}
+
+func TestDeleteComic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	mockStorage.EXPECT().SetComicHidden(gomock.Any(), 1, true).Return(nil)
+	mockStorage.EXPECT().DeleteComic(gomock.Any(), 2).Return(nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	assert.NoError(t, s.DeleteComic(context.Background(), 1, false))
+	assert.NoError(t, s.DeleteComic(context.Background(), 2, true))
 }
 
 func TestPrettyPrintService(t *testing.T) {
@@ -68,32 +221,74 @@ func TestGetUserByUsernameService(t *testing.T) {
 	username := "testuser"
 	user := core.User{Username: "testuser", Role: "admin"}
 
-	mockStorage.EXPECT().GetUserByUsername(username).Return(user, nil)
+	mockStorage.EXPECT().GetUserByUsername(gomock.Any(), username).Return(user, nil)
 
 	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
 
-	result, err := s.GetUserByUsernameService(username)
+	result, err := s.GetUserByUsernameService(context.Background(), username)
 	assert.NoError(t, err)
 	assert.Equal(t, user, result)
 }
 
-func TestCreateUserService(t *testing.T) {
+func TestUpdateUserService(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockStorage := mocks.NewMockStorage(ctrl)
 	mockClient := mocks.NewMockClientXKCD(ctrl)
 
-	username := "newuser"
-	password := "password"
-	role := "user"
+	role, disabled := "admin", true
+	mockStorage.EXPECT().GetRole(gomock.Any(), role).Return(core.Role{Name: role}, nil)
+	mockStorage.EXPECT().GetRole(gomock.Any(), "root").Return(core.Role{}, sql.ErrNoRows)
+	mockStorage.EXPECT().SetUserRole(gomock.Any(), "user1", role).Return(nil)
+	mockStorage.EXPECT().SetUserDisabled(gomock.Any(), "user1", disabled).Return(nil)
+	mockStorage.EXPECT().GetUserByUsername(gomock.Any(), "user1").Return(core.User{Username: "user1", Role: role, Disabled: disabled}, nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	user, err := s.UpdateUserService(context.Background(), "user1", core.UserPatch{Role: &role, Disabled: &disabled})
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", user.Role)
+	assert.True(t, user.Disabled)
+
+	unknown := "root"
+	_, err = s.UpdateUserService(context.Background(), "user1", core.UserPatch{Role: &unknown})
+	assert.ErrorIs(t, err, core.ErrUnknownRole)
+}
+
+func TestRolesService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
 
-	mockStorage.EXPECT().CreateUser(username, password, role).Return(nil)
+	role := core.Role{Name: core.RoleModerator, Permissions: []string{core.PermSearchRead}}
+	mockStorage.EXPECT().SaveRole(gomock.Any(), role).Return(nil)
+	mockStorage.EXPECT().GetRole(gomock.Any(), core.RoleModerator).Return(role, nil)
+	mockStorage.EXPECT().DeleteRole(gomock.Any(), core.RoleModerator).Return(core.ErrRoleInUse)
+	mockStorage.EXPECT().ListRoles(gomock.Any()).Return([]core.Role{role}, nil)
+	mockStorage.EXPECT().ListPermissions(gomock.Any()).Return([]string{core.PermComicsUpdate, core.PermSearchRead}, nil)
 
 	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
 
-	err := s.CreateUserService(username, password, role)
+	// вместе с ролями приходят права, которые им можно назначить
+	list, err := s.ListRoles(context.Background())
 	assert.NoError(t, err)
+	assert.Equal(t, []core.Role{role}, list.Roles)
+	assert.Equal(t, []string{core.PermComicsUpdate, core.PermSearchRead}, list.Permissions)
+
+	saved, err := s.SaveRole(context.Background(), role)
+	assert.NoError(t, err)
+	assert.Equal(t, role, saved)
+	assert.ErrorIs(t, s.DeleteRole(context.Background(), core.RoleModerator), core.ErrRoleInUse)
+
+	// встроенные роли и некорректные имена до базы не доходят
+	_, err = s.SaveRole(context.Background(), core.Role{Name: core.RoleAdmin})
+	assert.ErrorIs(t, err, core.ErrBuiltinRole)
+	_, err = s.SaveRole(context.Background(), core.Role{Name: "Root!"})
+	assert.ErrorIs(t, err, core.ErrUnknownRole)
+	assert.ErrorIs(t, s.DeleteRole(context.Background(), core.RoleUser), core.ErrBuiltinRole)
 }
 
 func TestLimitedHandlerService(t *testing.T) {
@@ -202,3 +397,42 @@ func TestNew(t *testing.T) {
 
 	assert.NotNil(t, s, "should've been created..")
 }
+
+func TestRefetchComicNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+	mockClient.EXPECT().RefetchComic(gomock.Any(), 100000).Return(core.Comic{}, fmt.Errorf("comic 100000: %w", xkcd.ErrComicNotFound))
+
+	s := NewService(&core.Config{ConcLim: 10}, mocks.NewMockStorage(ctrl), mockClient)
+	_, err := s.RefetchComic(context.Background(), 100000)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestGetComicInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockClient := mocks.NewMockClientXKCD(ctrl)
+
+	mockStorage.EXPECT().GetLatestComic(gomock.Any()).Return(core.Comic{ID: 2950, Title: "Latest", URL: "test.xkcd/2950.png"}, nil)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(core.Comic{ID: 1, Title: "First"}, nil)
+	mockStorage.EXPECT().GetComicByID(gomock.Any(), 2).Return(core.Comic{ID: 2, Title: "Hidden", Hidden: true}, nil)
+
+	s := NewService(&core.Config{ConcLim: 10}, mockStorage, mockClient)
+
+	latest, err := s.GetComicInfo(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2950, latest.Num)
+	assert.Equal(t, "Latest", latest.SafeTitle)
+	assert.Equal(t, "test.xkcd/2950.png", latest.Img)
+
+	first, err := s.GetComicInfo(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first.Num)
+
+	_, err = s.GetComicInfo(context.Background(), 2)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
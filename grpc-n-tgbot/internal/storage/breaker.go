@@ -0,0 +1,661 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sgsoul/internal/core"
+)
+
+const (
+	// breakerThreshold - сколько ошибок соединения подряд размыкают цепь
+	breakerThreshold = 3
+	minBackoff       = time.Second
+	maxBackoff       = 30 * time.Second
+	probeTimeout     = 5 * time.Second
+)
+
+// breaker размыкает цепь после нескольких ошибок соединения подряд: запросы сразу получают
+// core.ErrStorageUnavailable, а в фоне идут попытки переподключиться с нарастающей паузой
+type breaker struct {
+	mu        sync.Mutex
+	open      bool
+	failures  int
+	lastErr   error
+	since     time.Time
+	nextRetry time.Time
+
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// probe проверяет, что база снова доступна
+	probe func(ctx context.Context) error
+	// isFailure отличает обрыв связи от ошибок самого запроса
+	isFailure func(err error) bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBreaker(probe func(ctx context.Context) error, isFailure func(err error) bool) *breaker {
+	return &breaker{
+		threshold:  breakerThreshold,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		probe:      probe,
+		isFailure:  isFailure,
+		stop:       make(chan struct{}),
+	}
+}
+
+// allow возвращает ошибку, пока цепь разомкнута
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open {
+		return fmt.Errorf("%w: %v", core.ErrStorageUnavailable, b.lastErr)
+	}
+	return nil
+}
+
+// record учитывает результат запроса к базе
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !b.isFailure(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if !b.open && b.failures >= b.threshold {
+		b.trip(err)
+	}
+}
+
+// down сразу размыкает цепь, например если база недоступна при старте
+func (b *breaker) down(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		b.trip(err)
+	}
+}
+
+// trip размыкает цепь и запускает переподключение; вызывается под b.mu
+func (b *breaker) trip(err error) {
+	log.Error().Err(err).Msg("database is unavailable, switching to degraded mode")
+	b.open = true
+	b.lastErr = err
+	b.since = time.Now()
+	go b.reconnect()
+}
+
+func (b *breaker) reconnect() {
+	backoff := b.minBackoff
+	for {
+		b.mu.Lock()
+		b.nextRetry = time.Now().Add(backoff)
+		b.mu.Unlock()
+
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		err := b.probe(ctx)
+		cancel()
+
+		b.mu.Lock()
+		if err == nil {
+			log.Info().Msgf("database is available again after %s", time.Since(b.since).Round(time.Second))
+			b.open, b.failures, b.lastErr = false, 0, nil
+			b.since, b.nextRetry = time.Time{}, time.Time{}
+			b.mu.Unlock()
+			return
+		}
+		b.lastErr = err
+		b.mu.Unlock()
+
+		log.Warn().Err(err).Msgf("database reconnect failed, next attempt in %s", backoff)
+		backoff = min(2*backoff, b.maxBackoff)
+	}
+}
+
+// health описывает состояние цепи; если она замкнута, ok - false
+func (b *breaker) health() (core.StorageHealth, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return core.StorageHealth{}, false
+	}
+	return core.StorageHealth{
+		Error:     b.lastErr.Error(),
+		Since:     b.since,
+		NextRetry: b.nextRetry,
+	}, true
+}
+
+func (b *breaker) close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+}
+
+// breakerStorage пропускает запросы к хранилищу через breaker
+type breakerStorage struct {
+	st      Storage
+	br      *breaker
+	backend string
+}
+
+func newBreakerStorage(st Storage, br *breaker, backend string) *breakerStorage {
+	return &breakerStorage{st: st, br: br, backend: backend}
+}
+
+// Health сообщает о разомкнутой цепи, не обращаясь к базе
+func (s *breakerStorage) Health(ctx context.Context) core.StorageHealth {
+	if health, open := s.br.health(); open {
+		health.Backend = s.backend
+		return health
+	}
+	return s.st.Health(ctx)
+}
+
+func (s *breakerStorage) PrettyPrint(v []core.Comic) bytes.Buffer {
+	return s.st.PrettyPrint(v)
+}
+
+func (s *breakerStorage) Close() error {
+	s.br.close()
+	return s.st.Close()
+}
+
+func (s *breakerStorage) GetComicByID(ctx context.Context, id int) (_ core.Comic, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.Comic{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicByID(ctx, id)
+}
+
+func (s *breakerStorage) GetLatestComic(ctx context.Context) (_ core.Comic, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.Comic{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetLatestComic(ctx)
+}
+
+func (s *breakerStorage) GetAllComics(ctx context.Context) (_ []core.Comic, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetAllComics(ctx)
+}
+
+func (s *breakerStorage) SaveComicToDatabase(ctx context.Context, comic core.Comic) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveComicToDatabase(ctx, comic)
+}
+
+func (s *breakerStorage) GetComicsToNormalize(ctx context.Context, afterID, version, limit int) (_ []core.Comic, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicsToNormalize(ctx, afterID, version, limit)
+}
+
+func (s *breakerStorage) UpdateComicKeywords(ctx context.Context, id int, keywords string, terms []core.ComicTerm, version int) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.UpdateComicKeywords(ctx, id, keywords, terms, version)
+}
+
+func (s *breakerStorage) SearchTerms(ctx context.Context, terms []string) (_ map[int]int, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SearchTerms(ctx, terms)
+}
+
+func (s *breakerStorage) SaveComicRevision(ctx context.Context, comic core.Comic, diff string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveComicRevision(ctx, comic, diff)
+}
+
+func (s *breakerStorage) SetComicHidden(ctx context.Context, id int, hidden bool) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SetComicHidden(ctx, id, hidden)
+}
+
+func (s *breakerStorage) DeleteComic(ctx context.Context, id int) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.DeleteComic(ctx, id)
+}
+
+func (s *breakerStorage) GetComicRevisions(ctx context.Context, id int) (_ []core.ComicRevision, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicRevisions(ctx, id)
+}
+
+func (s *breakerStorage) GetIngestionStatus(ctx context.Context, num int) (_ core.IngestionStatus, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.IngestionStatus{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetIngestionStatus(ctx, num)
+}
+
+func (s *breakerStorage) SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveIngestionStatus(ctx, status)
+}
+
+func (s *breakerStorage) GetIngestionStatuses(ctx context.Context) (_ []core.IngestionStatus, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetIngestionStatuses(ctx)
+}
+
+func (s *breakerStorage) CountIngestionStatuses(ctx context.Context) (_ map[string]int, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.CountIngestionStatuses(ctx)
+}
+
+func (s *breakerStorage) GetComicIDs(ctx context.Context) (_ []int, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicIDs(ctx)
+}
+
+func (s *breakerStorage) GetUnverifiedComicIDs(ctx context.Context) (_ []int, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetUnverifiedComicIDs(ctx)
+}
+
+func (s *breakerStorage) VerifyComic(ctx context.Context, id int) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.VerifyComic(ctx, id)
+}
+
+func (s *breakerStorage) RenumberComic(ctx context.Context, comic core.Comic) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RenumberComic(ctx, comic)
+}
+
+func (s *breakerStorage) GetComicImage(ctx context.Context, id int) (_ core.ComicImage, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.ComicImage{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicImage(ctx, id)
+}
+
+func (s *breakerStorage) SaveComicImage(ctx context.Context, image core.ComicImage) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveComicImage(ctx, image)
+}
+
+func (s *breakerStorage) GetCount(ctx context.Context) (_ int, err error) {
+	if err := s.br.allow(); err != nil {
+		return 0, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetCount(ctx)
+}
+
+func (s *breakerStorage) CreateUser(ctx context.Context, username, password, role string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.CreateUser(ctx, username, password, role)
+}
+
+func (s *breakerStorage) GetUserByUsername(ctx context.Context, username string) (_ core.User, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.User{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetUserByUsername(ctx, username)
+}
+
+func (s *breakerStorage) ListUsers(ctx context.Context, limit, offset int) (_ []core.User, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ListUsers(ctx, limit, offset)
+}
+
+func (s *breakerStorage) CountUsers(ctx context.Context) (_ int, err error) {
+	if err := s.br.allow(); err != nil {
+		return 0, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.CountUsers(ctx)
+}
+
+func (s *breakerStorage) SetUserRole(ctx context.Context, username, role string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SetUserRole(ctx, username, role)
+}
+
+func (s *breakerStorage) SetUserDisabled(ctx context.Context, username string, disabled bool) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SetUserDisabled(ctx, username, disabled)
+}
+
+func (s *breakerStorage) UpdateUserPassword(ctx context.Context, username, password string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.UpdateUserPassword(ctx, username, password)
+}
+
+func (s *breakerStorage) DeleteUser(ctx context.Context, username string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.DeleteUser(ctx, username)
+}
+
+func (s *breakerStorage) UpsertUser(ctx context.Context, user core.User) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.UpsertUser(ctx, user)
+}
+
+func (s *breakerStorage) GetRole(ctx context.Context, name string) (_ core.Role, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.Role{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetRole(ctx, name)
+}
+
+func (s *breakerStorage) ListRoles(ctx context.Context) (_ []core.Role, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ListRoles(ctx)
+}
+
+func (s *breakerStorage) ListPermissions(ctx context.Context) (_ []string, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ListPermissions(ctx)
+}
+
+func (s *breakerStorage) SaveRole(ctx context.Context, role core.Role) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveRole(ctx, role)
+}
+
+func (s *breakerStorage) DeleteRole(ctx context.Context, name string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.DeleteRole(ctx, name)
+}
+
+func (s *breakerStorage) UpsertComic(ctx context.Context, comic core.Comic) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.UpsertComic(ctx, comic)
+}
+
+func (s *breakerStorage) GetComicsAfter(ctx context.Context, afterID, limit int) (_ []core.Comic, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetComicsAfter(ctx, afterID, limit)
+}
+
+func (s *breakerStorage) SaveAuditRecord(ctx context.Context, record core.AuditRecord) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveAuditRecord(ctx, record)
+}
+
+func (s *breakerStorage) GetAuditRecords(ctx context.Context, filter core.AuditFilter) (_ []core.AuditRecord, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetAuditRecords(ctx, filter)
+}
+
+func (s *breakerStorage) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (_ bool, err error) {
+	if err := s.br.allow(); err != nil {
+		return false, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.AcquireLock(ctx, name, owner, ttl)
+}
+
+func (s *breakerStorage) ReleaseLock(ctx context.Context, name, owner string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ReleaseLock(ctx, name, owner)
+}
+
+func (s *breakerStorage) SaveRefreshToken(ctx context.Context, token core.RefreshToken) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveRefreshToken(ctx, token)
+}
+
+func (s *breakerStorage) UseRefreshToken(ctx context.Context, id string) (_ core.RefreshToken, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.RefreshToken{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.UseRefreshToken(ctx, id)
+}
+
+func (s *breakerStorage) RevokeRefreshFamily(ctx context.Context, family string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RevokeRefreshFamily(ctx, family)
+}
+
+func (s *breakerStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RevokeToken(ctx, jti, expiresAt)
+}
+
+func (s *breakerStorage) RevokeUserTokens(ctx context.Context, username string, before time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RevokeUserTokens(ctx, username, before)
+}
+
+func (s *breakerStorage) TokenRevoked(ctx context.Context, jti, username string, issuedAt time.Time) (_ bool, err error) {
+	if err := s.br.allow(); err != nil {
+		return false, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.TokenRevoked(ctx, jti, username, issuedAt)
+}
+
+func (s *breakerStorage) DeleteExpiredTokens(ctx context.Context, now time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.DeleteExpiredTokens(ctx, now)
+}
+
+func (s *breakerStorage) SaveAPIKey(ctx context.Context, key core.APIKey) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.SaveAPIKey(ctx, key)
+}
+
+func (s *breakerStorage) GetAPIKeyByHash(ctx context.Context, hash string) (_ core.APIKey, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.APIKey{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *breakerStorage) ListAPIKeys(ctx context.Context, username string) (_ []core.APIKey, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ListAPIKeys(ctx, username)
+}
+
+func (s *breakerStorage) RevokeAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RevokeAPIKey(ctx, id, at)
+}
+
+func (s *breakerStorage) TouchAPIKey(ctx context.Context, id string, at time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.TouchAPIKey(ctx, id, at)
+}
+
+func (s *breakerStorage) GetLoginFailures(ctx context.Context, subject string) (_ core.LoginFailures, err error) {
+	if err := s.br.allow(); err != nil {
+		return core.LoginFailures{}, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.GetLoginFailures(ctx, subject)
+}
+
+func (s *breakerStorage) RecordLoginFailure(ctx context.Context, subject string, at, resetBefore time.Time) (_ int, err error) {
+	if err := s.br.allow(); err != nil {
+		return 0, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.RecordLoginFailure(ctx, subject, at, resetBefore)
+}
+
+func (s *breakerStorage) LockLogin(ctx context.Context, subject string, until time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.LockLogin(ctx, subject, until)
+}
+
+func (s *breakerStorage) ClearLoginFailures(ctx context.Context, subject string) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ClearLoginFailures(ctx, subject)
+}
+
+func (s *breakerStorage) ListLoginLocks(ctx context.Context, now time.Time) (_ []core.LoginFailures, err error) {
+	if err := s.br.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.ListLoginLocks(ctx, now)
+}
+
+func (s *breakerStorage) DeleteLoginFailures(ctx context.Context, before, now time.Time) (err error) {
+	if err := s.br.allow(); err != nil {
+		return err
+	}
+	defer func() { s.br.record(err) }()
+	return s.st.DeleteLoginFailures(ctx, before, now)
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func TestIsConnError(t *testing.T) {
+	assert.True(t, isConnError(errConnRefused))
+	assert.True(t, isConnError(fmt.Errorf("query: %w", mysqldriver.ErrInvalidConn)))
+	assert.False(t, isConnError(sql.ErrNoRows))
+	assert.False(t, isConnError(&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry"}))
+	assert.False(t, isConnError(context.DeadlineExceeded))
+}
+
+func TestBreakerStorage(t *testing.T) {
+	// проба проходит, когда "база" снова поднята
+	var up atomic.Bool
+	br := newBreaker(func(context.Context) error {
+		if !up.Load() {
+			return errConnRefused
+		}
+		return nil
+	}, isConnError)
+	br.minBackoff, br.maxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	st := newBreakerStorage(openMemoryStorage(t), br, "memory")
+	ctx := context.Background()
+
+	// ошибки запроса не размыкают цепь
+	for i := 0; i < breakerThreshold; i++ {
+		br.record(sql.ErrNoRows)
+	}
+	_, err := st.GetCount(ctx)
+	assert.NoError(t, err)
+	assert.True(t, st.Health(ctx).Available)
+
+	for i := 0; i < breakerThreshold; i++ {
+		br.record(errConnRefused)
+	}
+	_, err = st.GetCount(ctx)
+	assert.ErrorIs(t, err, core.ErrStorageUnavailable)
+	assert.ErrorIs(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png"}), core.ErrStorageUnavailable)
+
+	health := st.Health(ctx)
+	assert.False(t, health.Available)
+	assert.Equal(t, "memory", health.Backend)
+	assert.False(t, health.Since.IsZero())
+	assert.Contains(t, health.Error, "connection refused")
+
+	up.Store(true)
+	assert.Eventually(t, func() bool { return st.Health(ctx).Available }, time.Second, 5*time.Millisecond)
+	_, err = st.GetCount(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, st.Close())
+}
+
+func TestBreakerClose(t *testing.T) {
+	br := newBreaker(func(context.Context) error { return errConnRefused }, isConnError)
+	br.minBackoff = time.Hour
+	br.down(errConnRefused)
+	assert.ErrorIs(t, br.allow(), core.ErrStorageUnavailable)
+
+	// Close останавливает переподключение и не зависает
+	br.close()
+	br.close()
+}
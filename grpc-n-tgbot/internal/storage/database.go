@@ -2,43 +2,79 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
 )
 
-type MySQLStorage struct {
-	db *sql.DB
+// SQLStorage реализует хранилище поверх database/sql, различия между СУБД описывает dialect
+type SQLStorage struct {
+	db      *sql.DB
+	dialect dialect
 }
 
-func NewMySQLDB(dsn string) *MySQLStorage {
-	db := connectToDatabase(dsn)
-	err := migrateDatabase(dsn, "up")
-	if err != nil {
-		log.Error().Err(err).Msg("Error applying migrations")
-	}
+// dialect описывает конструкции SQL, которые различаются между бэкендами
+type dialect struct {
+	name string
+	// upsert дописывает к INSERT обновление указанных колонок при конфликте по ключу key
+	upsert func(key string, columns ...string) string
+	// forUpdate блокирует выбранные строки до конца транзакции
+	forUpdate string
+	// duplicate сообщает, что INSERT не прошел из-за уже занятого ключа
+	duplicate func(err error) bool
+	// now - текущее время по часам базы, nowPlus - время через ? секунд от него
+	now, nowPlus string
+}
+
+func newSQLStorage(db *sql.DB, d dialect) *SQLStorage {
+	return &SQLStorage{db: db, dialect: d}
+}
+
+// comicColumns - полный набор полей комикса в порядке scanComic
+const comicColumns = `id, url, keywords, COALESCE(title, ''), COALESCE(alt, ''), COALESCE(transcript, ''),
+	normalizer_version, COALESCE(content_hash, ''), COALESCE(safe_title, ''), COALESCE(link, ''), COALESCE(news, ''), published,
+	hidden, edited_at`
 
-	return &MySQLStorage{db: db}
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func (mysql *MySQLStorage) GetComicByID(id int) (core.Comic, error) {
+func scanComic(row scanner) (core.Comic, error) {
 	var comic core.Comic
-	err := mysql.db.QueryRow("SELECT id, url, keywords FROM comics WHERE id = ?", id).Scan(&comic.ID, &comic.URL, &comic.Keywords)
+	var published, editedAt sql.NullTime
+	err := row.Scan(&comic.ID, &comic.URL, &comic.Keywords, &comic.Title, &comic.Alt, &comic.Transcript,
+		&comic.NormVersion, &comic.ContentHash, &comic.SafeTitle, &comic.Link, &comic.News, &published,
+		&comic.Hidden, &editedAt)
 	if err != nil {
 		return core.Comic{}, err
 	}
+	comic.Published = published.Time
+	comic.EditedAt = editedAt.Time
 	return comic, nil
 }
 
-func (mysql *MySQLStorage) GetAllComics() ([]core.Comic, error) {
-	rows, err := mysql.db.Query("SELECT id, url, keywords FROM comics")
+// Close закрывает соединение с базой
+func (st *SQLStorage) Close() error {
+	return st.db.Close()
+}
+
+func (st *SQLStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
+	return scanComic(st.db.QueryRowContext(ctx, "SELECT "+comicColumns+" FROM comics WHERE id = ?", id))
+}
+
+// GetLatestComic возвращает не скрытый комикс с наибольшим номером
+func (st *SQLStorage) GetLatestComic(ctx context.Context) (core.Comic, error) {
+	return scanComic(st.db.QueryRowContext(ctx, "SELECT "+comicColumns+" FROM comics WHERE hidden = FALSE ORDER BY id DESC LIMIT 1"))
+}
+
+func (st *SQLStorage) GetAllComics(ctx context.Context) ([]core.Comic, error) {
+	// скрытые комиксы не попадают в индекс
+	rows, err := st.db.QueryContext(ctx, "SELECT id, url, keywords FROM comics WHERE hidden = FALSE")
 	if err != nil {
 		return nil, err
 	}
@@ -56,97 +92,1061 @@ func (mysql *MySQLStorage) GetAllComics() ([]core.Comic, error) {
 	return comics, nil
 }
 
-func (mysql *MySQLStorage) SaveComicToDatabase(comic core.Comic) error {
-	_, err := mysql.db.Exec("INSERT INTO comics (url, keywords) VALUES (?, ?)", comic.URL, comic.Keywords)
+func (st *SQLStorage) SaveComicToDatabase(ctx context.Context, comic core.Comic) error {
+	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback() //nolint:errcheck
+
+	// id комикса совпадает с его номером на xkcd.com; если комикс уже сохранила
+	// другая реплика, вставка ничего не делает
+	_, err = tx.ExecContext(ctx, `INSERT INTO comics (id, url, keywords, title, alt, transcript, normalizer_version, content_hash,
+		safe_title, link, news, published) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		comic.ID, comic.URL, comic.Keywords, comic.Title, comic.Alt, comic.Transcript, comic.NormVersion, comic.ContentHash,
+		comic.SafeTitle, comic.Link, comic.News, nullTime(comic.Published))
+	if st.dialect.duplicate(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := replaceTerms(ctx, tx, comic.ID, comic.Terms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func connectToDatabase(dsn string) *sql.DB {
-	db, err := sql.Open("mysql", dsn)
+// UpsertComic сохраняет комикс целиком, заменяя уже существующий с тем же номером
+func (st *SQLStorage) UpsertComic(ctx context.Context, comic core.Comic) error {
+	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Error().Err(err).Msg("error connecting to database")
-		return nil
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO comics (id, url, keywords, title, alt, transcript, normalizer_version, content_hash,
+		safe_title, link, news, published, hidden, edited_at, verified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, TRUE)`+
+		st.dialect.upsert("id", "url", "keywords", "title", "alt", "transcript", "normalizer_version", "content_hash",
+			"safe_title", "link", "news", "published", "hidden", "edited_at", "verified"),
+		comic.ID, comic.URL, comic.Keywords, comic.Title, comic.Alt, comic.Transcript, comic.NormVersion, comic.ContentHash,
+		comic.SafeTitle, comic.Link, comic.News, nullTime(comic.Published), comic.Hidden, nullTime(comic.EditedAt))
+	if err != nil {
+		return err
+	}
+
+	if err := replaceTerms(ctx, tx, comic.ID, comic.Terms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetComicsAfter возвращает до limit комиксов с номером больше afterID, включая скрытые
+func (st *SQLStorage) GetComicsAfter(ctx context.Context, afterID, limit int) ([]core.Comic, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+comicColumns+" FROM comics WHERE id > ? ORDER BY id LIMIT ?", afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comics []core.Comic
+	for rows.Next() {
+		comic, err := scanComic(rows)
+		if err != nil {
+			return nil, err
+		}
+		comics = append(comics, comic)
+	}
+	return comics, rows.Err()
+}
+
+func (st *SQLStorage) GetComicsToNormalize(ctx context.Context, afterID, version, limit int) ([]core.Comic, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+comicColumns+" FROM comics WHERE id > ? AND normalizer_version < ? AND edited_at IS NULL ORDER BY id LIMIT ?",
+		afterID, version, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comics []core.Comic
+	for rows.Next() {
+		comic, err := scanComic(rows)
+		if err != nil {
+			return nil, err
+		}
+		comics = append(comics, comic)
+	}
+	return comics, rows.Err()
+}
+
+func (st *SQLStorage) UpdateComicKeywords(ctx context.Context, id int, keywords string, terms []core.ComicTerm, version int) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback() //nolint:errcheck
 
-	err = db.Ping()
+	_, err = tx.ExecContext(ctx, "UPDATE comics SET keywords = ?, normalizer_version = ? WHERE id = ?", keywords, version, id)
 	if err != nil {
-		log.Error().Err(err).Msg("error pinging database")
+		return err
+	}
+
+	if err := replaceTerms(ctx, tx, id, terms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceTerms заменяет слова комикса в comic_terms в рамках транзакции сохранения комикса
+func replaceTerms(ctx context.Context, tx *sql.Tx, comicID int, terms []core.ComicTerm) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM comic_terms WHERE comic_id = ?", comicID); err != nil {
+		return err
+	}
+	if len(terms) == 0 {
 		return nil
 	}
 
-	return db
+	placeholders := make([]string, len(terms))
+	args := make([]any, 0, len(terms)*4)
+	for i, term := range terms {
+		placeholders[i] = "(?, ?, ?, ?)"
+		args = append(args, comicID, term.Term, term.Field, term.TF)
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO comic_terms (comic_id, term, field, tf) VALUES "+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// SearchTerms ищет комиксы по нормализованным словам через comic_terms.
+// Возвращает число совпавших слов для каждого комикса, как и поиск по индексу
+func (st *SQLStorage) SearchTerms(ctx context.Context, terms []string) (map[int]int, error) {
+	relevant := make(map[int]int)
+	if len(terms) == 0 {
+		return relevant, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(terms)), ", ")
+	args := make([]any, len(terms))
+	for i, term := range terms {
+		args[i] = term
+	}
+
+	rows, err := st.db.QueryContext(ctx, `SELECT t.comic_id, COUNT(DISTINCT t.term) FROM comic_terms t JOIN comics c ON c.id = t.comic_id
+		WHERE c.hidden = FALSE AND t.term IN (`+placeholders+") GROUP BY t.comic_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, matched int
+		if err := rows.Scan(&id, &matched); err != nil {
+			return nil, err
+		}
+		relevant[id] = matched
+	}
+	return relevant, rows.Err()
+}
+
+// SetComicHidden скрывает комикс из поиска или возвращает его обратно
+func (st *SQLStorage) SetComicHidden(ctx context.Context, id int, hidden bool) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE comics SET hidden = ? WHERE id = ?", hidden, id)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// DeleteComic удаляет комикс вместе с его словами, ревизиями и картинкой.
+// Статус загрузки остается, поэтому следующий /update загрузит комикс заново
+func (st *SQLStorage) DeleteComic(ctx context.Context, id int) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, query := range []string{
+		"DELETE FROM comic_terms WHERE comic_id = ?",
+		"DELETE FROM comic_revisions WHERE comic_id = ?",
+		"DELETE FROM comic_images WHERE comic_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM comics WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// expectRow возвращает sql.ErrNoRows, если запрос не затронул ни одной строки
+func expectRow(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveComicRevision сохраняет новую ревизию комикса и обновляет его текущее содержимое
+func (st *SQLStorage) SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var revision int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(revision), 0) + 1 FROM comic_revisions WHERE comic_id = ?"+st.dialect.forUpdate, comic.ID).Scan(&revision)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO comic_revisions (comic_id, revision, content_hash, url, title, alt, transcript, diff)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, comic.ID, revision, comic.ContentHash, comic.URL, comic.Title, comic.Alt, comic.Transcript, diff)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE comics SET url = ?, keywords = ?, title = ?, alt = ?, transcript = ?, normalizer_version = ?, content_hash = ?,
+		safe_title = ?, link = ?, news = ?, published = ?, edited_at = ? WHERE id = ?`,
+		comic.URL, comic.Keywords, comic.Title, comic.Alt, comic.Transcript, comic.NormVersion, comic.ContentHash,
+		comic.SafeTitle, comic.Link, comic.News, nullTime(comic.Published), nullTime(comic.EditedAt), comic.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceTerms(ctx, tx, comic.ID, comic.Terms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *SQLStorage) GetComicRevisions(ctx context.Context, id int) ([]core.ComicRevision, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT comic_id, revision, content_hash, url, COALESCE(title, ''), COALESCE(alt, ''), COALESCE(transcript, ''),
+		COALESCE(diff, ''), created_at FROM comic_revisions WHERE comic_id = ? ORDER BY revision`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []core.ComicRevision
+	for rows.Next() {
+		var rev core.ComicRevision
+		err := rows.Scan(&rev.ComicID, &rev.Revision, &rev.ContentHash, &rev.URL, &rev.Title, &rev.Alt, &rev.Transcript, &rev.Diff, &rev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (st *SQLStorage) GetIngestionStatus(ctx context.Context, num int) (core.IngestionStatus, error) {
+	var status core.IngestionStatus
+	var nextRetry sql.NullTime
+	err := st.db.QueryRowContext(ctx, "SELECT num, status, COALESCE(error, ''), attempts, last_attempt, next_retry FROM ingestion_status WHERE num = ?", num).
+		Scan(&status.Num, &status.Status, &status.Error, &status.Attempts, &status.LastAttempt, &nextRetry)
+	if err != nil {
+		return core.IngestionStatus{}, err
+	}
+	status.NextRetry = nextRetry.Time
+	return status, nil
+}
+
+func (st *SQLStorage) SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) error {
+	_, err := st.db.ExecContext(ctx, `INSERT INTO ingestion_status (num, status, error, attempts, last_attempt, next_retry) VALUES (?, ?, ?, ?, ?, ?)`+
+		st.dialect.upsert("num", "status", "error", "attempts", "last_attempt", "next_retry"),
+		status.Num, status.Status, status.Error, status.Attempts, status.LastAttempt, nullTime(status.NextRetry))
+	return err
+}
+
+func (st *SQLStorage) GetIngestionStatuses(ctx context.Context) ([]core.IngestionStatus, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT num, status, COALESCE(error, ''), attempts, last_attempt, next_retry FROM ingestion_status ORDER BY num")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []core.IngestionStatus
+	for rows.Next() {
+		var status core.IngestionStatus
+		var nextRetry sql.NullTime
+		err := rows.Scan(&status.Num, &status.Status, &status.Error, &status.Attempts, &status.LastAttempt, &nextRetry)
+		if err != nil {
+			return nil, err
+		}
+		status.NextRetry = nextRetry.Time
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+func (st *SQLStorage) CountIngestionStatuses(ctx context.Context) (map[string]int, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM ingestion_status GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+func (st *SQLStorage) GetComicIDs(ctx context.Context) ([]int, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT id FROM comics ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetUnverifiedComicIDs возвращает номера комиксов, сохраненных старыми сборками под
+// автоинкрементным id; такой id может не совпадать с номером на xkcd.com
+func (st *SQLStorage) GetUnverifiedComicIDs(ctx context.Context) ([]int, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT id FROM comics WHERE verified = FALSE ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// VerifyComic подтверждает, что id комикса совпадает с его номером
+func (st *SQLStorage) VerifyComic(ctx context.Context, id int) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE comics SET verified = TRUE WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// RenumberComic записывает под номером комикса его настоящее содержимое вместо чужого.
+// Ревизии, картинка и ручные правки относились к другому комиксу и удаляются, скрытие сохраняется
+func (st *SQLStorage) RenumberComic(ctx context.Context, comic core.Comic) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, query := range []string{
+		"DELETE FROM comic_revisions WHERE comic_id = ?",
+		"DELETE FROM comic_images WHERE comic_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, comic.ID); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE comics SET url = ?, keywords = ?, title = ?, alt = ?, transcript = ?, normalizer_version = ?, content_hash = ?,
+		safe_title = ?, link = ?, news = ?, published = ?, edited_at = NULL, verified = TRUE WHERE id = ?`,
+		comic.URL, comic.Keywords, comic.Title, comic.Alt, comic.Transcript, comic.NormVersion, comic.ContentHash,
+		comic.SafeTitle, comic.Link, comic.News, nullTime(comic.Published), comic.ID)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+
+	if err := replaceTerms(ctx, tx, comic.ID, comic.Terms); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *SQLStorage) GetComicImage(ctx context.Context, id int) (core.ComicImage, error) {
+	var image core.ComicImage
+	err := st.db.QueryRowContext(ctx, "SELECT comic_id, source_url, hash, content_type, thumb_hash FROM comic_images WHERE comic_id = ?", id).
+		Scan(&image.ComicID, &image.SourceURL, &image.Hash, &image.ContentType, &image.ThumbHash)
+	if err != nil {
+		return core.ComicImage{}, err
+	}
+	return image, nil
+}
+
+func (st *SQLStorage) SaveComicImage(ctx context.Context, image core.ComicImage) error {
+	_, err := st.db.ExecContext(ctx, `INSERT INTO comic_images (comic_id, source_url, hash, content_type, thumb_hash) VALUES (?, ?, ?, ?, ?)`+
+		st.dialect.upsert("comic_id", "source_url", "hash", "content_type", "thumb_hash"), image.ComicID, image.SourceURL, image.Hash, image.ContentType, image.ThumbHash)
+	return err
+}
+
+// nullTime сохраняет нулевое время как NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (st *SQLStorage) GetCount(ctx context.Context) (int, error) {
+	var count int
+	err := st.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM comics").Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (st *SQLStorage) CreateUser(ctx context.Context, username, password, role string) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO users (username, password, role) VALUES (?, ?, ?)", username, password, role)
+	return err
+}
+
+func (st *SQLStorage) GetUserByUsername(ctx context.Context, username string) (core.User, error) {
+	var user core.User
+	err := st.db.QueryRowContext(ctx, "SELECT id, username, password, role, disabled FROM users WHERE username = ?", username).Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.Disabled)
+	if err != nil {
+		return core.User{}, err
+	}
+	return user, nil
+}
+
+// ListUsers возвращает страницу пользователей, упорядоченных по id
+func (st *SQLStorage) ListUsers(ctx context.Context, limit, offset int) ([]core.User, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT id, username, password, role, disabled FROM users ORDER BY id LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []core.User
+	for rows.Next() {
+		var user core.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.Disabled); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
 }
 
-func (mysql *MySQLStorage) GetCount() (int, error) {
+func (st *SQLStorage) CountUsers(ctx context.Context) (int, error) {
 	var count int
-	err := mysql.db.QueryRow("SELECT COUNT(*) FROM comics").Scan(&count)
+	err := st.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func migrateDatabase(dsn string, direction string) error {
-	// создание абсолютного пути к папке с миграционными файлами
-	migrationsPath := filepath.Join("internal", "storage", "migrations")
+func (st *SQLStorage) SetUserRole(ctx context.Context, username, role string) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// SetUserDisabled блокирует или разблокирует вход пользователя
+func (st *SQLStorage) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE users SET disabled = ? WHERE username = ?", disabled, username)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// UpdateUserPassword сохраняет новый хеш пароля
+func (st *SQLStorage) UpdateUserPassword(ctx context.Context, username, password string) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE username = ?", password, username)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// UpsertUser создает пользователя или обновляет существующего по имени.
+// Без хеша пароля меняются только роль и блокировка, а отсутствующий пользователь дает sql.ErrNoRows
+func (st *SQLStorage) UpsertUser(ctx context.Context, user core.User) error {
+	if user.Password == "" {
+		res, err := st.db.ExecContext(ctx, "UPDATE users SET role = ?, disabled = ? WHERE username = ?", user.Role, user.Disabled, user.Username)
+		if err != nil {
+			return err
+		}
+		return expectRow(res)
+	}
+
+	_, err := st.db.ExecContext(ctx, "INSERT INTO users (username, password, role, disabled) VALUES (?, ?, ?, ?)"+
+		st.dialect.upsert("username", "password", "role", "disabled"),
+		user.Username, user.Password, user.Role, user.Disabled)
+	return err
+}
+
+// DeleteUser удаляет пользователя вместе с его ключами API, refresh-токенами и счетчиком неудачных входов
+// и отзывает выданные ему access-токены: все они привязаны к имени, и иначе их унаследовал бы тот,
+// кто зарегистрируется под этим именем снова
+func (st *SQLStorage) DeleteUser(ctx context.Context, username string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM api_keys WHERE username = ?", username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE username = ?", username); err != nil {
+		return err
+	}
+	// ключ счетчика совпадает с тем, что пишет сервис авторизации
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_failures WHERE subject = ?", "user:"+username); err != nil {
+		return err
+	}
+	// токены, выданные в текущую секунду, не отзываются, чтобы не задеть вход нового владельца имени сразу после удаления
+	before := time.Now().UTC().Truncate(time.Second)
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_revocations (username, revoked_before) VALUES (?, ?)"+
+		st.dialect.upsert("username", "revoked_before"), username, before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRole возвращает роль с ее правами; неизвестная роль дает sql.ErrNoRows
+func (st *SQLStorage) GetRole(ctx context.Context, name string) (core.Role, error) {
+	role := core.Role{Name: name}
+	err := st.db.QueryRowContext(ctx, "SELECT description FROM roles WHERE name = ?", name).Scan(&role.Description)
+	if err != nil {
+		return core.Role{}, err
+	}
+
+	rows, err := st.db.QueryContext(ctx, "SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission", name)
+	if err != nil {
+		return core.Role{}, err
+	}
+	defer rows.Close()
+
+	role.Permissions = []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return core.Role{}, err
+		}
+		role.Permissions = append(role.Permissions, permission)
+	}
+	return role, rows.Err()
+}
+
+// ListRoles возвращает все роли с правами, упорядоченные по имени
+func (st *SQLStorage) ListRoles(ctx context.Context) ([]core.Role, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT name, description FROM roles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []core.Role
+	index := map[string]int{}
+	for rows.Next() {
+		role := core.Role{Permissions: []string{}}
+		if err := rows.Scan(&role.Name, &role.Description); err != nil {
+			return nil, err
+		}
+		index[role.Name] = len(roles)
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	permRows, err := st.db.QueryContext(ctx, "SELECT role, permission FROM role_permissions ORDER BY role, permission")
+	if err != nil {
+		return nil, err
+	}
+	defer permRows.Close()
+
+	for permRows.Next() {
+		var role, permission string
+		if err := permRows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+		if i, ok := index[role]; ok {
+			roles[i].Permissions = append(roles[i].Permissions, permission)
+		}
+	}
+	return roles, permRows.Err()
+}
+
+// ListPermissions возвращает все известные права по алфавиту
+func (st *SQLStorage) ListPermissions(ctx context.Context) ([]string, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT name FROM permissions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// SaveRole создает роль или заменяет ее описание и права; неизвестное право дает core.ErrUnknownPermission
+func (st *SQLStorage) SaveRole(ctx context.Context, role core.Role) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, permission := range role.Permissions {
+		var known bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM permissions WHERE name = ?)", permission).Scan(&known)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("%w %q", core.ErrUnknownPermission, permission)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO roles (name, description) VALUES (?, ?)"+
+		st.dialect.upsert("name", "description"), role.Name, role.Description)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", role.Name); err != nil {
+		return err
+	}
+	for _, permission := range role.Permissions {
+		_, err := tx.ExecContext(ctx, "INSERT INTO role_permissions (role, permission) VALUES (?, ?)"+
+			st.dialect.upsert("role, permission", "role"), role.Name, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
 
-	db, err := sql.Open("mysql", dsn)
+// DeleteRole удаляет роль вместе с ее правами; роль, назначенную пользователям, удалить нельзя
+func (st *SQLStorage) DeleteRole(ctx context.Context, name string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer tx.Rollback() //nolint:errcheck
+
+	var inUse bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE role = ?)", name).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse {
+		return core.ErrRoleInUse
+	}
 
-	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", name); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE name = ?", name)
 	if err != nil {
 		return err
 	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveAuditRecord добавляет запись в журнал аудита; записи журнала не меняются и не удаляются
+func (st *SQLStorage) SaveAuditRecord(ctx context.Context, record core.AuditRecord) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO audit_log (actor, ip, action, target, details, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		record.Actor, record.IP, record.Action, record.Target, record.Details, record.Status, record.CreatedAt)
+	return err
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://"+migrationsPath,
-		"mysql",
-		driver,
+// GetAuditRecords возвращает записи журнала по фильтру, новые первыми
+func (st *SQLStorage) GetAuditRecords(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error) {
+	var (
+		where []string
+		args  []any
 	)
+	for column, value := range map[string]string{"actor": filter.Actor, "ip": filter.IP, "action": filter.Action, "target": filter.Target} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	if filter.Failed {
+		where = append(where, "status >= 400")
+	}
+
+	query := "SELECT id, actor, ip, action, target, COALESCE(details, ''), status, created_at FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []core.AuditRecord
+	for rows.Next() {
+		var record core.AuditRecord
+		if err := rows.Scan(&record.ID, &record.Actor, &record.IP, &record.Action, &record.Target, &record.Details, &record.Status, &record.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// AcquireLock берет или продлевает аренду блокировки name до now+ttl.
+// Чужая блокировка перехватывается только после истечения срока. Время берется
+// с часов базы, чтобы реплики с разошедшимися часами не перехватывали аренду друг у друга
+func (st *SQLStorage) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	seconds := int64(ttl / time.Second)
+
+	res, err := st.db.ExecContext(ctx, "UPDATE locks SET owner = ?, expires_at = "+st.dialect.nowPlus+
+		" WHERE name = ? AND (owner = ? OR expires_at < "+st.dialect.now+")", owner, seconds, name, owner)
+	if err != nil {
+		return false, err
+	}
+	if err := expectRow(res); err == nil {
+		return true, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	// строку могла создать другая реплика; вставка тогда ничего не меняет, а владельца
+	// проверяем отдельно, потому что MySQL считает найденные строки затронутыми
+	_, err = st.db.ExecContext(ctx, "INSERT INTO locks (name, owner, expires_at) VALUES (?, ?, "+st.dialect.nowPlus+")"+
+		st.dialect.upsert("name", "name"), name, owner, seconds)
+	if err != nil {
+		return false, err
+	}
+	var holder string
+	err = st.db.QueryRowContext(ctx, "SELECT owner FROM locks WHERE name = ?", name).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return holder == owner, nil
+}
+
+// ReleaseLock снимает блокировку, если она еще принадлежит owner
+func (st *SQLStorage) ReleaseLock(ctx context.Context, name, owner string) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM locks WHERE name = ? AND owner = ?", name, owner)
+	return err
+}
+
+func (st *SQLStorage) SaveRefreshToken(ctx context.Context, token core.RefreshToken) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO refresh_tokens (id, username, role, family, used, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token.ID, token.Username, token.Role, token.Family, token.Used, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+// UseRefreshToken помечает refresh-токен использованным и возвращает его.
+// Уже использованный токен возвращается вместе с core.ErrTokenReused, чтобы можно было отозвать всю цепочку
+func (st *SQLStorage) UseRefreshToken(ctx context.Context, id string) (core.RefreshToken, error) {
+	var token core.RefreshToken
+	err := st.db.QueryRowContext(ctx, "SELECT id, username, role, family, used, created_at, expires_at FROM refresh_tokens WHERE id = ?", id).
+		Scan(&token.ID, &token.Username, &token.Role, &token.Family, &token.Used, &token.CreatedAt, &token.ExpiresAt)
+	if err != nil {
+		return core.RefreshToken{}, err
+	}
+	if token.Used {
+		return token, core.ErrTokenReused
+	}
+
+	// два одновременных обмена одного токена: выигрывает только один
+	res, err := st.db.ExecContext(ctx, "UPDATE refresh_tokens SET used = ? WHERE id = ? AND used = ?", true, id, false)
+	if err != nil {
+		return core.RefreshToken{}, err
+	}
+	if err := expectRow(res); errors.Is(err, sql.ErrNoRows) {
+		return token, core.ErrTokenReused
+	} else if err != nil {
+		return core.RefreshToken{}, err
+	}
+	token.Used = true
+	return token, nil
+}
+
+// RevokeRefreshFamily удаляет все refresh-токены цепочки обменов
+func (st *SQLStorage) RevokeRefreshFamily(ctx context.Context, family string) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE family = ?", family)
+	return err
+}
+
+// RevokeToken отзывает access-токен по jti; запись нужна только до истечения токена
+func (st *SQLStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)"+
+		st.dialect.upsert("jti", "expires_at"), jti, expiresAt)
+	return err
+}
+
+// RevokeUserTokens удаляет refresh-токены пользователя и отзывает его access-токены, выданные раньше before
+func (st *SQLStorage) RevokeUserTokens(ctx context.Context, username string, before time.Time) error {
+	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback() //nolint:errcheck
 
-	if direction == "up" {
-		err = m.Up()
-	} else if direction == "down" {
-		err = m.Down()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE username = ?", username); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_revocations (username, revoked_before) VALUES (?, ?)"+
+		st.dialect.upsert("username", "revoked_before"), username, before)
+	if err != nil {
+		return err
 	}
+	return tx.Commit()
+}
+
+// TokenRevoked проверяет, отозван ли access-токен по jti или вместе со всеми токенами пользователя
+func (st *SQLStorage) TokenRevoked(ctx context.Context, jti, username string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := st.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR EXISTS (SELECT 1 FROM user_revocations WHERE username = ? AND revoked_before > ?)`, jti, username, issuedAt).Scan(&revoked)
+	return revoked, err
+}
 
-	if err != nil && err != migrate.ErrNoChange {
+// DeleteExpiredTokens удаляет истекшие refresh-токены и записи об отзыве истекших access-токенов
+func (st *SQLStorage) DeleteExpiredTokens(ctx context.Context, now time.Time) error {
+	if _, err := st.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < ?", now); err != nil {
 		return err
 	}
+	_, err := st.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", now)
+	return err
+}
+
+const apiKeyColumns = "id, hash, username, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at"
 
-	if direction == "up" {
-		log.Info().Msg("Migrations applied successfully")
-	} else if direction == "down" {
-		log.Info().Msg("Migrations reverted successfully")
+func scanAPIKey(row scanner) (core.APIKey, error) {
+	var key core.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Hash, &key.Username, &key.Name, &key.Prefix, &scopes, &key.CreatedAt,
+		&expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return core.APIKey{}, err
+	}
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
 	}
+	key.ExpiresAt, key.LastUsedAt, key.RevokedAt = expiresAt.Time, lastUsedAt.Time, revokedAt.Time
+	return key, nil
+}
 
-	return nil
+// SaveAPIKey сохраняет новый ключ API; права хранятся через запятую, как ключевые слова комиксов
+func (st *SQLStorage) SaveAPIKey(ctx context.Context, key core.APIKey) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.Hash, key.Username, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.CreatedAt,
+		nullTime(key.ExpiresAt), nullTime(key.LastUsedAt), nullTime(key.RevokedAt))
+	return err
+}
+
+// GetAPIKeyByHash ищет ключ по хешу, в том числе отозванный и истекший; нет ключа - sql.ErrNoRows
+func (st *SQLStorage) GetAPIKeyByHash(ctx context.Context, hash string) (core.APIKey, error) {
+	return scanAPIKey(st.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?", hash))
+}
+
+// ListAPIKeys возвращает ключи пользователя, а с пустым username - все ключи, новые первыми
+func (st *SQLStorage) ListAPIKeys(ctx context.Context, username string) ([]core.APIKey, error) {
+	query, args := "SELECT "+apiKeyColumns+" FROM api_keys", []any{}
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+	rows, err := st.db.QueryContext(ctx, query+" ORDER BY created_at DESC, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []core.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
 }
 
-func (mysql *MySQLStorage) CreateUser(username, password, role string) error {
-	_, err := mysql.db.Exec("INSERT INTO users (username, password, role) VALUES (?, ?, ?)", username, password, role)
+// RevokeAPIKey отзывает ключ; нет такого действующего ключа - sql.ErrNoRows
+func (st *SQLStorage) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	res, err := st.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// TouchAPIKey запоминает время последнего использования ключа
+func (st *SQLStorage) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id)
 	return err
 }
 
-func (mysql *MySQLStorage) GetUserByUsername(username string) (core.User, error) {
-	var user core.User
-	err := mysql.db.QueryRow("SELECT id, username, password, role FROM users WHERE username = ?", username).Scan(&user.ID, &user.Username, &user.Password, &user.Role)
+// GetLoginFailures возвращает неудачные входы subject; sql.ErrNoRows, если их не было
+func (st *SQLStorage) GetLoginFailures(ctx context.Context, subject string) (core.LoginFailures, error) {
+	return scanLoginFailures(st.db.QueryRowContext(ctx, "SELECT subject, failures, last_failed_at, locked_until FROM login_failures WHERE subject = ?", subject))
+}
+
+func scanLoginFailures(row scanner) (core.LoginFailures, error) {
+	var f core.LoginFailures
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&f.Subject, &f.Failures, &f.LastFailedAt, &lockedUntil); err != nil {
+		return core.LoginFailures{}, err
+	}
+	f.LockedUntil = lockedUntil.Time
+	return f, nil
+}
+
+// RecordLoginFailure увеличивает счетчик неудачных входов subject и возвращает его новое значение;
+// если последняя неудача была раньше resetBefore, счет начинается заново
+func (st *SQLStorage) RecordLoginFailure(ctx context.Context, subject string, at, resetBefore time.Time) (int, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
-		return core.User{}, err
+		return 0, err
 	}
-	return user, nil
+	defer tx.Rollback() //nolint:errcheck
+
+	failures := 1
+	var lastFailedAt time.Time
+	err = tx.QueryRowContext(ctx, "SELECT failures, last_failed_at FROM login_failures WHERE subject = ?"+st.dialect.forUpdate, subject).Scan(&failures, &lastFailedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		failures = 1
+	case err != nil:
+		return 0, err
+	case lastFailedAt.Before(resetBefore):
+		failures = 1
+	default:
+		failures++
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO login_failures (subject, failures, last_failed_at) VALUES (?, ?, ?)"+
+		st.dialect.upsert("subject", "failures", "last_failed_at"), subject, failures, at)
+	if err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+// LockLogin запрещает вход subject до until
+func (st *SQLStorage) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE login_failures SET locked_until = ? WHERE subject = ?", nullTime(until), subject)
+	return err
+}
+
+// ClearLoginFailures сбрасывает неудачные входы и блокировку subject; sql.ErrNoRows, если их не было
+func (st *SQLStorage) ClearLoginFailures(ctx context.Context, subject string) error {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM login_failures WHERE subject = ?", subject)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// ListLoginLocks возвращает блокировки входа, действующие в момент now, начиная с самых долгих
+func (st *SQLStorage) ListLoginLocks(ctx context.Context, now time.Time) ([]core.LoginFailures, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT subject, failures, last_failed_at, locked_until FROM login_failures
+		WHERE locked_until > ? ORDER BY locked_until DESC, subject`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []core.LoginFailures{}
+	for rows.Next() {
+		f, err := scanLoginFailures(rows)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, f)
+	}
+	return locks, rows.Err()
+}
+
+// DeleteLoginFailures удаляет счетчики, последняя неудача которых была раньше before, если блокировка уже кончилась
+func (st *SQLStorage) DeleteLoginFailures(ctx context.Context, before, now time.Time) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM login_failures WHERE last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, now)
+	return err
+}
+
+// Health проверяет соединение с базой
+func (st *SQLStorage) Health(ctx context.Context) core.StorageHealth {
+	health := core.StorageHealth{Backend: st.dialect.name, Available: true}
+	if err := st.db.PingContext(ctx); err != nil {
+		health.Available = false
+		health.Error = err.Error()
+	}
+	return health
 }
 
-func (mysql *MySQLStorage) PrettyPrint(v []core.Comic) bytes.Buffer {
+func (st *SQLStorage) PrettyPrint(v []core.Comic) bytes.Buffer {
 	var responseBuffer bytes.Buffer
 	for i, comic := range v {
 		if i >= 10 {
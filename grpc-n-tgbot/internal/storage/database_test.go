@@ -0,0 +1,646 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func openMemoryStorage(t *testing.T) Storage {
+	st, err := Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open("postgres://localhost/xkcd")
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "xkcd.db")
+	st, err := Open("sqlite://" + path)
+	assert.NoError(t, err)
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Keywords: "barrel"}))
+	assert.NoError(t, st.Close())
+
+	// повторное открытие не ломается на уже применённых миграциях
+	st, err = Open("sqlite://" + path)
+	assert.NoError(t, err)
+	defer st.Close()
+
+	count, err := st.GetCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryStorageIsolated(t *testing.T) {
+	first := openMemoryStorage(t)
+	second := openMemoryStorage(t)
+
+	assert.NoError(t, first.SaveComicToDatabase(context.Background(), core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png"}))
+
+	count, err := second.GetCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestComics(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	published := time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)
+	comic := core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/barrel_cropped_(1).jpg", Keywords: "barrel boy",
+		Title: "Barrel - Part 1", Alt: "Don't we all.", SafeTitle: "Barrel - Part 1", NormVersion: 1, ContentHash: "hash1", Published: published}
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), comic))
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), core.Comic{ID: 3, URL: "https://imgs.xkcd.com/comics/island_color.jpg", Keywords: "island"}))
+
+	stored, err := st.GetComicByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.True(t, published.Equal(stored.Published))
+	stored.Published = published
+	assert.Equal(t, comic, stored)
+
+	latest, err := st.GetLatestComic(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, latest.ID)
+	// скрытый комикс не считается последним
+	assert.NoError(t, st.SetComicHidden(context.Background(), 3, true))
+	latest, err = st.GetLatestComic(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, latest.ID)
+	assert.NoError(t, st.SetComicHidden(context.Background(), 3, false))
+
+	_, err = st.GetComicByID(context.Background(), 2)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	ids, err := st.GetComicIDs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, ids)
+
+	pending, err := st.GetComicsToNormalize(context.Background(), 0, 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 3, pending[0].ID)
+
+	assert.NoError(t, st.UpdateComicKeywords(context.Background(), 3, "island sea", nil, 1))
+	pending, err = st.GetComicsToNormalize(context.Background(), 0, 1, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	all, err := st.GetAllComics(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	assert.Equal(t, "island sea", all[1].Keywords)
+}
+
+func TestComicRevisions(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: "old"}))
+
+	for _, title := range []string{"new", "newer"} {
+		assert.NoError(t, st.SaveComicRevision(context.Background(), core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: title, ContentHash: title}, "-old\n+"+title))
+	}
+
+	revisions, err := st.GetComicRevisions(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, 1, revisions[0].Revision)
+	assert.Equal(t, 2, revisions[1].Revision)
+	assert.Equal(t, "newer", revisions[1].Title)
+	assert.False(t, revisions[1].CreatedAt.IsZero())
+
+	comic, err := st.GetComicByID(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "newer", comic.Title)
+}
+
+func TestIngestionStatuses(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, st.SaveIngestionStatus(context.Background(), core.IngestionStatus{Num: 404, Status: core.StatusNotFound, Attempts: 1, LastAttempt: now, NextRetry: now.Add(time.Hour)}))
+	assert.NoError(t, st.SaveIngestionStatus(context.Background(), core.IngestionStatus{Num: 404, Status: core.StatusNotFound, Attempts: 2, LastAttempt: now, NextRetry: now.Add(2 * time.Hour)}))
+	assert.NoError(t, st.SaveIngestionStatus(context.Background(), core.IngestionStatus{Num: 1, Status: core.StatusOK, Attempts: 1, LastAttempt: now}))
+
+	status, err := st.GetIngestionStatus(context.Background(), 404)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Attempts)
+	assert.True(t, now.Add(2*time.Hour).Equal(status.NextRetry))
+
+	statuses, err := st.GetIngestionStatuses(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	assert.True(t, statuses[0].NextRetry.IsZero())
+
+	counts, err := st.CountIngestionStatuses(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{core.StatusOK: 1, core.StatusNotFound: 1}, counts)
+}
+
+func TestComicImages(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	image := core.ComicImage{ComicID: 1, SourceURL: "https://imgs.xkcd.com/comics/1.png", Hash: "a", ContentType: "image/png", ThumbHash: "a"}
+	assert.NoError(t, st.SaveComicImage(context.Background(), image))
+	image.Hash, image.ThumbHash = "b", "c"
+	assert.NoError(t, st.SaveComicImage(context.Background(), image))
+
+	stored, err := st.GetComicImage(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, image, stored)
+}
+
+func TestUsers(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	// пользователи из миграции 005
+	admin, err := st.GetUserByUsername(context.Background(), "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", admin.Role)
+
+	assert.NoError(t, st.CreateUser(context.Background(), "user3", "hash", "user"))
+	assert.Error(t, st.CreateUser(context.Background(), "user3", "hash", "user"))
+
+	user, err := st.GetUserByUsername(context.Background(), "user3")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash", user.Password)
+
+	_, err = st.GetUserByUsername(context.Background(), "nobody")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestManageUsers(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	users, err := st.ListUsers(ctx, 2, 1)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.Equal(t, "user1", users[0].Username)
+	count, err := st.CountUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.NoError(t, st.SetUserRole(ctx, "user1", "admin"))
+	assert.NoError(t, st.SetUserDisabled(ctx, "user1", true))
+	assert.NoError(t, st.UpdateUserPassword(ctx, "user1", "newhash"))
+	user, err := st.GetUserByUsername(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, core.User{ID: user.ID, Username: "user1", Password: "newhash", Role: "admin", Disabled: true}, user)
+
+	assert.NoError(t, st.DeleteUser(ctx, "user2"))
+	for _, err := range []error{
+		st.DeleteUser(ctx, "user2"),
+		st.SetUserRole(ctx, "user2", "admin"),
+		st.SetUserDisabled(ctx, "user2", true),
+		st.UpdateUserPassword(ctx, "user2", "hash"),
+	} {
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	role, err := st.GetRole(ctx, core.RoleModerator)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{core.PermComicsUpdate, core.PermSearchRead}, role.Permissions)
+
+	_, err = st.GetRole(ctx, "editor")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	permissions, err := st.ListPermissions(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, permissions, 6)
+
+	err = st.SaveRole(ctx, core.Role{Name: "editor", Permissions: []string{core.PermComicsUpdate, "comics:eat"}})
+	assert.ErrorIs(t, err, core.ErrUnknownPermission)
+	_, err = st.GetRole(ctx, "editor")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// права заменяются целиком, повторы не мешают
+	assert.NoError(t, st.SaveRole(ctx, core.Role{Name: "editor", Description: "edits", Permissions: []string{core.PermSearchRead}}))
+	assert.NoError(t, st.SaveRole(ctx, core.Role{Name: "editor", Description: "edits comics", Permissions: []string{core.PermComicsUpdate, core.PermComicsUpdate}}))
+	role, err = st.GetRole(ctx, "editor")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Role{Name: "editor", Description: "edits comics", Permissions: []string{core.PermComicsUpdate}}, role)
+
+	roles, err := st.ListRoles(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "editor", "moderator", "user"}, []string{roles[0].Name, roles[1].Name, roles[2].Name, roles[3].Name})
+	assert.Len(t, roles[0].Permissions, 6)
+	assert.Equal(t, []string{core.PermComicsUpdate}, roles[1].Permissions)
+
+	assert.NoError(t, st.SetUserRole(ctx, "user1", "editor"))
+	assert.ErrorIs(t, st.DeleteRole(ctx, "editor"), core.ErrRoleInUse)
+	assert.NoError(t, st.SetUserRole(ctx, "user1", core.RoleUser))
+	assert.NoError(t, st.DeleteRole(ctx, "editor"))
+	assert.ErrorIs(t, st.DeleteRole(ctx, "editor"), sql.ErrNoRows)
+}
+
+func TestAPIKeys(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key := core.APIKey{ID: "k1", Hash: "hash1", Username: "admin", Name: "ci", Prefix: "xkcd_abc",
+		Scopes: []string{core.PermSearchRead, core.PermComicsIngest}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, st.SaveAPIKey(ctx, key))
+	assert.NoError(t, st.SaveAPIKey(ctx, core.APIKey{ID: "k2", Hash: "hash2", Username: "user1", Name: "bot", Prefix: "xkcd_def", CreatedAt: now}))
+
+	got, err := st.GetAPIKeyByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, key.Scopes, got.Scopes)
+	assert.True(t, key.ExpiresAt.Equal(got.ExpiresAt))
+	assert.True(t, got.LastUsedAt.IsZero())
+	_, err = st.GetAPIKeyByHash(ctx, "nope")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.NoError(t, st.TouchAPIKey(ctx, "k1", now))
+	assert.NoError(t, st.RevokeAPIKey(ctx, "k1", now))
+	assert.ErrorIs(t, st.RevokeAPIKey(ctx, "k1", now), sql.ErrNoRows)
+	got, err = st.GetAPIKeyByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(got.LastUsedAt))
+	assert.True(t, now.Equal(got.RevokedAt))
+
+	keys, err := st.ListAPIKeys(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, []string{}, keys[0].Scopes)
+	keys, err = st.ListAPIKeys(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestLoginFailures(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := st.GetLoginFailures(ctx, "user:user1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	for i := 1; i <= 3; i++ {
+		failures, err := st.RecordLoginFailure(ctx, "user:user1", now, now.Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, i, failures)
+	}
+	assert.NoError(t, st.LockLogin(ctx, "user:user1", now.Add(time.Minute)))
+	got, err := st.GetLoginFailures(ctx, "user:user1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, got.Failures)
+	assert.True(t, now.Add(time.Minute).Equal(got.LockedUntil))
+
+	// давняя неудача не считается
+	failures, err := st.RecordLoginFailure(ctx, "ip:10.0.0.1", now.Add(-2*time.Hour), now.Add(-3*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failures)
+	failures, err = st.RecordLoginFailure(ctx, "ip:10.0.0.1", now, now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	locks, err := st.ListLoginLocks(ctx, now)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 1)
+	assert.Equal(t, "user:user1", locks[0].Subject)
+
+	assert.NoError(t, st.DeleteLoginFailures(ctx, now.Add(time.Hour), now))
+	_, err = st.GetLoginFailures(ctx, "ip:10.0.0.1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, st.ClearLoginFailures(ctx, "user:user1"))
+	assert.ErrorIs(t, st.ClearLoginFailures(ctx, "user:user1"), sql.ErrNoRows)
+}
+
+func TestComicTerms(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Terms: []core.ComicTerm{
+		{Term: "appl", Field: core.FieldTitle, TF: 1},
+		{Term: "appl", Field: core.FieldAlt, TF: 2},
+		{Term: "doctor", Field: core.FieldAlt, TF: 1},
+	}}))
+	assert.NoError(t, st.SaveComicToDatabase(context.Background(), core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", Terms: []core.ComicTerm{
+		{Term: "doctor", Field: core.FieldTranscript, TF: 3},
+	}}))
+
+	matches, err := st.SearchTerms(context.Background(), []string{"appl", "doctor", "island"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, matches)
+
+	// пересчёт ключевых слов заменяет слова комикса целиком
+	assert.NoError(t, st.UpdateComicKeywords(context.Background(), 1, "island", []core.ComicTerm{{Term: "island", Field: core.FieldTitle, TF: 1}}, 2))
+	matches, err = st.SearchTerms(context.Background(), []string{"appl", "island"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1}, matches)
+
+	// новая ревизия тоже обновляет слова
+	assert.NoError(t, st.SaveComicRevision(context.Background(), core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", ContentHash: "new",
+		Terms: []core.ComicTerm{{Term: "island", Field: core.FieldAlt, TF: 1}}}, ""))
+	matches, err = st.SearchTerms(context.Background(), []string{"doctor", "island"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, matches)
+
+	matches, err = st.SearchTerms(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestCancelledContext(t *testing.T) {
+	st := openMemoryStorage(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := st.GetAllComics(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png"}), context.Canceled)
+}
+
+func TestHideAndDeleteComic(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	terms := []core.ComicTerm{{Term: "appl", Field: core.FieldTitle, TF: 1}}
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Keywords: "appl", Terms: terms}))
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", Keywords: "appl", Terms: terms}))
+
+	// скрытый комикс остается в базе, но пропадает из поиска
+	assert.NoError(t, st.SetComicHidden(ctx, 1, true))
+	assert.NoError(t, st.SetComicHidden(ctx, 1, true))
+	comic, err := st.GetComicByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, comic.Hidden)
+
+	all, err := st.GetAllComics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+	matches, err := st.SearchTerms(ctx, []string{"appl"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{2: 1}, matches)
+
+	assert.ErrorIs(t, st.SetComicHidden(ctx, 3, true), sql.ErrNoRows)
+
+	assert.NoError(t, st.SaveComicRevision(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", EditedAt: time.Now()}, ""))
+	pending, err := st.GetComicsToNormalize(ctx, 0, 100, 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].ID)
+
+	assert.NoError(t, st.DeleteComic(ctx, 2))
+	_, err = st.GetComicByID(ctx, 2)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	revisions, err := st.GetComicRevisions(ctx, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, revisions)
+	assert.ErrorIs(t, st.DeleteComic(ctx, 2), sql.ErrNoRows)
+}
+
+func TestRenumberComic(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/3.png", Title: "Three"}))
+	assert.NoError(t, st.SaveComicRevision(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/3.png", Title: "Three!", EditedAt: time.Now()}, ""))
+	assert.NoError(t, st.SetComicHidden(ctx, 2, true))
+	ids, err := st.GetUnverifiedComicIDs(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+
+	terms := []core.ComicTerm{{Term: "two", Field: core.FieldTitle, TF: 1}}
+	assert.NoError(t, st.RenumberComic(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png", Title: "Two", Keywords: "two", Terms: terms}))
+	comic, err := st.GetComicByID(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Two", comic.Title)
+	assert.True(t, comic.EditedAt.IsZero())
+	assert.True(t, comic.Hidden)
+	revisions, err := st.GetComicRevisions(ctx, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, revisions)
+
+	assert.NoError(t, st.VerifyComic(ctx, 2))
+	assert.ErrorIs(t, st.VerifyComic(ctx, 5), sql.ErrNoRows)
+	assert.ErrorIs(t, st.RenumberComic(ctx, core.Comic{ID: 5}), sql.ErrNoRows)
+}
+
+func TestAuditLog(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, record := range []core.AuditRecord{
+		{Actor: "user1", IP: "192.0.2.1", Action: core.AuditLogin, Status: 401},
+		{Actor: "admin", IP: "192.0.2.2", Action: core.AuditLogin, Status: 200},
+		{Actor: "admin", IP: "192.0.2.2", Action: core.AuditRegister, Target: "root", Details: "role=admin", Status: 201},
+	} {
+		record.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		assert.NoError(t, st.SaveAuditRecord(ctx, record))
+	}
+
+	records, err := st.GetAuditRecords(ctx, core.AuditFilter{Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, core.AuditRegister, records[0].Action)
+	assert.Equal(t, "role=admin", records[0].Details)
+	assert.True(t, start.Add(2*time.Hour).Equal(records[0].CreatedAt))
+
+	records, err = st.GetAuditRecords(ctx, core.AuditFilter{Actor: "admin", Action: core.AuditLogin, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, 200, records[0].Status)
+
+	records, err = st.GetAuditRecords(ctx, core.AuditFilter{Failed: true, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "user1", records[0].Actor)
+
+	records, err = st.GetAuditRecords(ctx, core.AuditFilter{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour), Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.2", records[0].IP)
+
+	records, err = st.GetAuditRecords(ctx, core.AuditFilter{Limit: 1, Offset: 2})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "user1", records[0].Actor)
+}
+
+func TestUpsertComicsAndUsers(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: "old"}))
+
+	edited := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	comic := core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: "new", Hidden: true, EditedAt: edited,
+		Terms: []core.ComicTerm{{Term: "new", Field: core.FieldTitle, TF: 1}}}
+	assert.NoError(t, st.UpsertComic(ctx, comic))
+	assert.NoError(t, st.UpsertComic(ctx, core.Comic{ID: 2, URL: "https://imgs.xkcd.com/comics/2.png"}))
+
+	stored, err := st.GetComicByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", stored.Title)
+	assert.True(t, stored.Hidden)
+	assert.True(t, edited.Equal(stored.EditedAt))
+
+	// скрытые комиксы тоже выгружаются
+	comics, err := st.GetComicsAfter(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, comics, 2)
+	comics, err = st.GetComicsAfter(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, comics, 1)
+	assert.Equal(t, 2, comics[0].ID)
+
+	assert.NoError(t, st.UpsertUser(ctx, core.User{Username: "user1", Role: "admin", Disabled: true}))
+	assert.ErrorIs(t, st.UpsertUser(ctx, core.User{Username: "nobody", Role: "user"}), sql.ErrNoRows)
+	assert.NoError(t, st.UpsertUser(ctx, core.User{Username: "user4", Password: "hash", Role: "user"}))
+	assert.NoError(t, st.UpsertUser(ctx, core.User{Username: "user4", Password: "newhash", Role: "admin"}))
+
+	user, err := st.GetUserByUsername(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", user.Role)
+	assert.True(t, user.Disabled)
+	assert.NotEmpty(t, user.Password)
+
+	user, err = st.GetUserByUsername(ctx, "user4")
+	assert.NoError(t, err)
+	assert.Equal(t, "newhash", user.Password)
+	assert.Equal(t, "admin", user.Role)
+}
+
+func TestLocks(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	ok, err := st.AcquireLock(ctx, "ingestion", "replica1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// вторая реплика ждет, первая продлевает аренду
+	ok, err = st.AcquireLock(ctx, "ingestion", "replica2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = st.AcquireLock(ctx, "ingestion", "replica1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// чужую блокировку снять нельзя
+	assert.NoError(t, st.ReleaseLock(ctx, "ingestion", "replica2"))
+	ok, err = st.AcquireLock(ctx, "ingestion", "replica2", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, st.ReleaseLock(ctx, "ingestion", "replica1"))
+	ok, err = st.AcquireLock(ctx, "ingestion", "replica2", -time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// просроченная аренда перехватывается
+	ok, err = st.AcquireLock(ctx, "ingestion", "replica1", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDialectDuplicate(t *testing.T) {
+	// только дубликат ключа считается "уже есть", остальные ошибки вставки не прячутся
+	assert.True(t, mysqlDialect.duplicate(fmt.Errorf("insert: %w", &mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry"})))
+	assert.False(t, mysqlDialect.duplicate(&mysqldriver.MySQLError{Number: 1406, Message: "Data too long"}))
+	assert.False(t, sqliteDialect.duplicate(nil))
+
+	st, err := newSQLiteStorage("file:dialect?mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	ctx := context.Background()
+	_, err = st.db.ExecContext(ctx, "INSERT INTO locks (name, owner, expires_at) VALUES (?, ?, ?)", "ingestion", "replica1", time.Now())
+	assert.NoError(t, err)
+	_, err = st.db.ExecContext(ctx, "INSERT INTO locks (name, owner, expires_at) VALUES (?, ?, ?)", "ingestion", "replica2", time.Now())
+	assert.True(t, sqliteDialect.duplicate(err))
+	_, err = st.db.ExecContext(ctx, "INSERT INTO locks (name, owner, expires_at) VALUES (?, NULL, ?)", "other", time.Now())
+	assert.Error(t, err)
+	assert.False(t, sqliteDialect.duplicate(err))
+}
+
+func TestSaveComicIdempotent(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+
+	terms := []core.ComicTerm{{Term: "appl", Field: core.FieldTitle, TF: 1}}
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: "first", Terms: terms}))
+	// повторная вставка того же номера другой репликой ничего не меняет
+	assert.NoError(t, st.SaveComicToDatabase(ctx, core.Comic{ID: 1, URL: "https://imgs.xkcd.com/comics/1.png", Title: "second"}))
+
+	comic, err := st.GetComicByID(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", comic.Title)
+	count, err := st.GetCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	matches, err := st.SearchTerms(ctx, []string{"appl"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1}, matches)
+}
+
+func TestTokens(t *testing.T) {
+	st := openMemoryStorage(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	token := core.RefreshToken{ID: "hash1", Username: "user1", Role: "user", Family: "family1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, st.SaveRefreshToken(ctx, token))
+
+	used, err := st.UseRefreshToken(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, "family1", used.Family)
+	assert.True(t, token.ExpiresAt.Equal(used.ExpiresAt))
+
+	// повторный обмен того же токена выдает цепочку
+	used, err = st.UseRefreshToken(ctx, "hash1")
+	assert.ErrorIs(t, err, core.ErrTokenReused)
+	assert.Equal(t, "family1", used.Family)
+
+	assert.NoError(t, st.RevokeRefreshFamily(ctx, "family1"))
+	_, err = st.UseRefreshToken(ctx, "hash1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	revoked, err := st.TokenRevoked(ctx, "jti1", "user1", now)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, st.RevokeToken(ctx, "jti1", now.Add(time.Minute)))
+	assert.NoError(t, st.RevokeToken(ctx, "jti1", now.Add(time.Minute)))
+	revoked, err = st.TokenRevoked(ctx, "jti1", "user1", now)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// отзыв всех токенов пользователя действует на выданные раньше момента отзыва
+	token.ID, token.Family = "hash2", "family2"
+	assert.NoError(t, st.SaveRefreshToken(ctx, token))
+	assert.NoError(t, st.RevokeUserTokens(ctx, "user1", now))
+	assert.NoError(t, st.RevokeUserTokens(ctx, "user1", now.Add(time.Second)))
+	_, err = st.UseRefreshToken(ctx, "hash2")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	revoked, err = st.TokenRevoked(ctx, "jti2", "user1", now)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = st.TokenRevoked(ctx, "jti2", "user1", now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, st.DeleteExpiredTokens(ctx, now.Add(2*time.Minute)))
+	revoked, err = st.TokenRevoked(ctx, "jti1", "user2", now)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
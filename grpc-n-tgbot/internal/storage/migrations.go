@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "modernc.org/sqlite"
+)
+
+// migrationsFS содержит наборы миграций для каждого бэкенда: migrations/<backend>/*.sql
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// ErrSchemaTooNew возвращается, если база мигрирована более новой версией сервиса
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// legacyVersions переводит версии, которые записывали старые сборки, в текущую нумерацию.
+// Раньше откат 001 и 003 лежал под номерами 002 и 004, поэтому база на версии 2 или 4
+// по схеме совпадает с 1 или 3, а таких версий в наборе больше нет
+var legacyVersions = map[uint]uint{2: 1, 4: 3}
+
+// Migrator управляет версией схемы базы по встроенным миграциям
+type Migrator struct {
+	m       *migrate.Migrate
+	backend string
+	// versions - все версии из встроенного набора по возрастанию
+	versions []uint
+	// closeDB закрывает соединение, открытое только для миграций
+	closeDB func() error
+
+	// DryRun печатает план и SQL вместо применения
+	DryRun bool
+	// Out получает план dry-run и журнал применённых миграций
+	Out io.Writer
+}
+
+// NewMigrator открывает базу по DSN (mysql:// или без схемы, sqlite://) для управления миграциями
+func NewMigrator(dsn string) (*Migrator, error) {
+	switch schemeOf(dsn) {
+	case "mysql":
+		return newMySQLMigrator(trimScheme(dsn))
+	case "sqlite":
+		db, err := openSQLiteDB("file:" + trimScheme(dsn))
+		if err != nil {
+			return nil, err
+		}
+		mg, err := newSQLiteMigrator(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		mg.closeDB = db.Close
+		return mg, nil
+	default:
+		return nil, fmt.Errorf("migrations are not supported for %q storage", schemeOf(dsn))
+	}
+}
+
+func newMySQLMigrator(dsn string) (*Migrator, error) {
+	db, err := openMySQLMigrationsDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	mg, err := newMigrator("mysql", driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	mg.closeDB = db.Close
+	return mg, nil
+}
+
+// newSQLiteMigrator работает поверх соединения хранилища и не закрывает его
+func newSQLiteMigrator(db *sql.DB) (*Migrator, error) {
+	driver, err := sqlite.WithInstance(db, &sqlite.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator("sqlite", driver)
+}
+
+func newMigrator(backend string, driver database.Driver) (*Migrator, error) {
+	src, err := iofs.New(migrationsFS, path.Join("migrations", backend))
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := sourceVersions(src)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, backend, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{m: m, backend: backend, versions: versions, Out: io.Discard}, nil
+}
+
+func sourceVersions(src source.Driver) ([]uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []uint{version}
+	for {
+		version, err = src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+}
+
+// Close закрывает соединение, если мигратор открывал его сам
+func (mg *Migrator) Close() error {
+	if mg.closeDB == nil {
+		return nil
+	}
+	return mg.closeDB()
+}
+
+// Latest возвращает последнюю версию схемы, известную этой сборке
+func (mg *Migrator) Latest() uint {
+	return mg.versions[len(mg.versions)-1]
+}
+
+// Version возвращает текущую версию схемы; 0 - миграции ещё не применялись
+func (mg *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// checkSchema не даёт трогать схему, которую мигрировала более новая версия сервиса,
+// и переводит версию из старой нумерации в текущую
+func (mg *Migrator) checkSchema() (uint, error) {
+	version, dirty, err := mg.Version()
+	if err != nil {
+		return 0, err
+	}
+	if current, ok := legacyVersions[version]; ok && !dirty {
+		fmt.Fprintf(mg.Out, "-- version %d renumbered to %d\n", version, current)
+		if !mg.DryRun {
+			if err := mg.m.Force(int(current)); err != nil {
+				return 0, err
+			}
+		}
+		version = current
+	}
+	if version > mg.Latest() {
+		return 0, fmt.Errorf("%w: schema version %d, latest known %d", ErrSchemaTooNew, version, mg.Latest())
+	}
+	return version, nil
+}
+
+// Up применяет все недостающие миграции
+func (mg *Migrator) Up() error {
+	current, err := mg.checkSchema()
+	if err != nil {
+		return err
+	}
+
+	if mg.DryRun {
+		return mg.printPlan(mg.upTo(current, mg.Latest()), true)
+	}
+	return mg.run(mg.m.Up)
+}
+
+// Down откатывает n последних миграций
+func (mg *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid number of migrations to revert: %d", n)
+	}
+
+	current, err := mg.checkSchema()
+	if err != nil {
+		return err
+	}
+
+	if mg.DryRun {
+		steps := mg.downTo(current, 0)
+		if len(steps) < n {
+			return fmt.Errorf("can't revert %d migrations, only %d applied", n, len(steps))
+		}
+		return mg.printPlan(steps[:n], false)
+	}
+	return mg.run(func() error { return mg.m.Steps(-n) })
+}
+
+// Goto переводит схему на версию version вверх или вниз
+func (mg *Migrator) Goto(version uint) error {
+	if !mg.known(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	current, err := mg.checkSchema()
+	if err != nil {
+		return err
+	}
+
+	if mg.DryRun {
+		if version >= current {
+			return mg.printPlan(mg.upTo(current, version), true)
+		}
+		return mg.printPlan(mg.downTo(current, version), false)
+	}
+	return mg.run(func() error { return mg.m.Migrate(version) })
+}
+
+// Force записывает версию без выполнения миграций и снимает флаг dirty
+func (mg *Migrator) Force(version int) error {
+	if mg.DryRun {
+		fmt.Fprintf(mg.Out, "-- would force version %d\n", version)
+		return nil
+	}
+	return mg.m.Force(version)
+}
+
+func (mg *Migrator) run(apply func() error) error {
+	mg.m.Log = migrateLogger{out: mg.Out}
+	err := apply()
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Fprintln(mg.Out, "no change")
+		return nil
+	}
+	return err
+}
+
+func (mg *Migrator) known(version uint) bool {
+	i := sort.Search(len(mg.versions), func(i int) bool { return mg.versions[i] >= version })
+	return i < len(mg.versions) && mg.versions[i] == version
+}
+
+// upTo - версии из (from, to] по возрастанию
+func (mg *Migrator) upTo(from, to uint) []uint {
+	var steps []uint
+	for _, v := range mg.versions {
+		if v > from && v <= to {
+			steps = append(steps, v)
+		}
+	}
+	return steps
+}
+
+// downTo - версии из (to, from] по убыванию
+func (mg *Migrator) downTo(from, to uint) []uint {
+	var steps []uint
+	for i := len(mg.versions) - 1; i >= 0; i-- {
+		if v := mg.versions[i]; v > to && v <= from {
+			steps = append(steps, v)
+		}
+	}
+	return steps
+}
+
+func (mg *Migrator) printPlan(steps []uint, up bool) error {
+	if len(steps) == 0 {
+		fmt.Fprintln(mg.Out, "no change")
+		return nil
+	}
+
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	dir := path.Join("migrations", mg.backend)
+	for _, version := range steps {
+		name, body, err := readMigration(dir, version, direction)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			fmt.Fprintf(mg.Out, "-- %d %s: no statements\n", version, direction)
+			continue
+		}
+		fmt.Fprintf(mg.Out, "-- %d %s (%s)\n%s\n", version, direction, name, body)
+	}
+	return nil
+}
+
+// readMigration ищет файл <version>_<name>.<direction>.sql
+func readMigration(dir string, version uint, direction string) (string, string, error) {
+	entries, err := migrationsFS.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, entry := range entries {
+		m, err := source.DefaultParse(entry.Name())
+		if err != nil || m.Version != version || string(m.Direction) != direction {
+			continue
+		}
+		body, err := migrationsFS.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return "", "", err
+		}
+		return m.Identifier, string(body), nil
+	}
+	return "", "", nil
+}
+
+// migrateLogger выводит журнал golang-migrate в Out мигратора
+type migrateLogger struct {
+	out io.Writer
+}
+
+func (l migrateLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(l.out, format, v...)
+}
+
+func (l migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1 @@
+DELETE FROM users WHERE username IN ('admin', 'user1', 'user2');
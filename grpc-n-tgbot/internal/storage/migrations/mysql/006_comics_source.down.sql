@@ -0,0 +1,5 @@
+ALTER TABLE comics
+    DROP COLUMN title,
+    DROP COLUMN alt,
+    DROP COLUMN transcript,
+    DROP COLUMN normalizer_version;
\ No newline at end of file
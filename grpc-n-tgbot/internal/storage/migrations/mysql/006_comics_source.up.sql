@@ -0,0 +1,5 @@
+ALTER TABLE comics
+    ADD COLUMN title TEXT,
+    ADD COLUMN alt TEXT,
+    ADD COLUMN transcript TEXT,
+    ADD COLUMN normalizer_version INT NOT NULL DEFAULT 0;
\ No newline at end of file
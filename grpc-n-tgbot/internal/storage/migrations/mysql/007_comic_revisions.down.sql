@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS comic_revisions;
+
+ALTER TABLE comics DROP COLUMN content_hash;
@@ -0,0 +1,15 @@
+ALTER TABLE comics ADD COLUMN content_hash CHAR(64);
+
+CREATE TABLE IF NOT EXISTS comic_revisions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    comic_id INT NOT NULL,
+    revision INT NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    title TEXT,
+    alt TEXT,
+    transcript TEXT,
+    diff TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY comic_revision (comic_id, revision)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE comics
+    DROP COLUMN safe_title,
+    DROP COLUMN link,
+    DROP COLUMN news,
+    DROP COLUMN published;
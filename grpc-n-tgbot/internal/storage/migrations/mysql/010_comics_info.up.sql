@@ -0,0 +1,5 @@
+ALTER TABLE comics
+    ADD COLUMN safe_title TEXT,
+    ADD COLUMN link VARCHAR(255),
+    ADD COLUMN news TEXT,
+    ADD COLUMN published DATE NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS comic_terms (
+    comic_id INT NOT NULL,
+    term VARCHAR(255) NOT NULL,
+    field VARCHAR(32) NOT NULL,
+    tf INT NOT NULL,
+    PRIMARY KEY (comic_id, term, field),
+    KEY comic_terms_term (term),
+    CONSTRAINT comic_terms_comic FOREIGN KEY (comic_id) REFERENCES comics (id) ON DELETE CASCADE
+);
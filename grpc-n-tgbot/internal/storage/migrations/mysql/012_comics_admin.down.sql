@@ -0,0 +1,3 @@
+ALTER TABLE comics
+    DROP COLUMN hidden,
+    DROP COLUMN edited_at;
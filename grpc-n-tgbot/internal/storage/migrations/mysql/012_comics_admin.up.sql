@@ -0,0 +1,3 @@
+ALTER TABLE comics
+    ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN edited_at TIMESTAMP NULL;
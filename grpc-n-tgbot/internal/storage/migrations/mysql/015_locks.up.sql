@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS locks (
+    name VARCHAR(64) PRIMARY KEY,
+    owner VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
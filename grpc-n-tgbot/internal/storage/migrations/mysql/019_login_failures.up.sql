@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    subject VARCHAR(255) PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NULL,
+    KEY login_failures_last_failed_at (last_failed_at)
+);
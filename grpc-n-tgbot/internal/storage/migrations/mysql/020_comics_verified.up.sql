@@ -0,0 +1,7 @@
+ALTER TABLE comics
+    ADD COLUMN verified BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- старые сборки сохраняли комиксы с автоинкрементным id, который не совпадает с номером на xkcd.com,
+-- а номер подтвержден только у комиксов, загруженных по номеру вместе со статусом загрузки
+UPDATE comics SET verified = FALSE
+WHERE id NOT IN (SELECT num FROM ingestion_status WHERE status IN ('ok', 'no_image'));
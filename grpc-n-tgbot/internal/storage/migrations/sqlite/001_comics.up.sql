@@ -0,0 +1,5 @@
+CREATE TABLE comics (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url VARCHAR(255) NOT NULL,
+    keywords TEXT
+);
@@ -0,0 +1,5 @@
+INSERT OR IGNORE INTO users (username, password, role)
+VALUES
+    ('admin', '$2a$10$eKAvhwoJTIbFDLQwCYiZW.b25GuTv3isO6vEn66n5rpmbPMV1gfta', 'admin'),
+    ('user1', '$2a$10$YYUUgKpR8ERRdBLGlFZtz.Z6v6kJo4ndsoCedpXYVVUQBFtqXBquK', 'user'),
+    ('user2', '$2a$10$YYUUgKpR8ERRdBLGlFZtz.Z6v6kJo4ndsoCedpXYVVUQBFtqXBquK', 'user');
@@ -0,0 +1,4 @@
+ALTER TABLE comics DROP COLUMN title;
+ALTER TABLE comics DROP COLUMN alt;
+ALTER TABLE comics DROP COLUMN transcript;
+ALTER TABLE comics DROP COLUMN normalizer_version;
@@ -0,0 +1,4 @@
+ALTER TABLE comics ADD COLUMN title TEXT;
+ALTER TABLE comics ADD COLUMN alt TEXT;
+ALTER TABLE comics ADD COLUMN transcript TEXT;
+ALTER TABLE comics ADD COLUMN normalizer_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+ALTER TABLE comics ADD COLUMN content_hash CHAR(64);
+
+CREATE TABLE IF NOT EXISTS comic_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comic_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    title TEXT,
+    alt TEXT,
+    transcript TEXT,
+    diff TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (comic_id, revision)
+);
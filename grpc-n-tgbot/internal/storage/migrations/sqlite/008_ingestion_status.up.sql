@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ingestion_status (
+    num INTEGER PRIMARY KEY,
+    status VARCHAR(32) NOT NULL,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_retry TIMESTAMP NULL
+);
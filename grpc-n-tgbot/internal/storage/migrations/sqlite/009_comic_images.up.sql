@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS comic_images (
+    comic_id INTEGER PRIMARY KEY,
+    source_url VARCHAR(255) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    thumb_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+ALTER TABLE comics DROP COLUMN safe_title;
+ALTER TABLE comics DROP COLUMN link;
+ALTER TABLE comics DROP COLUMN news;
+ALTER TABLE comics DROP COLUMN published;
@@ -0,0 +1,4 @@
+ALTER TABLE comics ADD COLUMN safe_title TEXT;
+ALTER TABLE comics ADD COLUMN link VARCHAR(255);
+ALTER TABLE comics ADD COLUMN news TEXT;
+ALTER TABLE comics ADD COLUMN published DATE NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS comic_terms (
+    comic_id INTEGER NOT NULL REFERENCES comics (id) ON DELETE CASCADE,
+    term VARCHAR(255) NOT NULL,
+    field VARCHAR(32) NOT NULL,
+    tf INTEGER NOT NULL,
+    PRIMARY KEY (comic_id, term, field)
+);
+
+CREATE INDEX IF NOT EXISTS comic_terms_term ON comic_terms (term);
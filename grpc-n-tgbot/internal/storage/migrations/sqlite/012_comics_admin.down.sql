@@ -0,0 +1,2 @@
+ALTER TABLE comics DROP COLUMN hidden;
+ALTER TABLE comics DROP COLUMN edited_at;
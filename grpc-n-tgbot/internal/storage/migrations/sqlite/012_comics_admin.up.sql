@@ -0,0 +1,2 @@
+ALTER TABLE comics ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE comics ADD COLUMN edited_at TIMESTAMP NULL;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(64) PRIMARY KEY,
+    username VARCHAR(255) NOT NULL,
+    role VARCHAR(64) NOT NULL,
+    family VARCHAR(64) NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_username ON refresh_tokens (username);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family ON refresh_tokens (family);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_revocations (
+    username VARCHAR(255) PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL
+);
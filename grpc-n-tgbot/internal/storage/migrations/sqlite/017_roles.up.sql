@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(64) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(64) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(64) NOT NULL,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT OR IGNORE INTO roles (name, description)
+VALUES
+    ('admin', 'full access'),
+    ('moderator', 'fixes comics'),
+    ('user', 'searches comics');
+
+INSERT OR IGNORE INTO permissions (name, description)
+VALUES
+    ('search:read', 'search comics'),
+    ('comics:update', 'view, edit, hide and refetch single comics'),
+    ('comics:ingest', 'update and normalize the comics database'),
+    ('users:manage', 'manage users and roles'),
+    ('audit:read', 'read the audit log'),
+    ('keys:manage', 'rotate token signing keys');
+
+INSERT OR IGNORE INTO role_permissions (role, permission)
+VALUES
+    ('admin', 'search:read'),
+    ('admin', 'comics:update'),
+    ('admin', 'comics:ingest'),
+    ('admin', 'users:manage'),
+    ('admin', 'audit:read'),
+    ('admin', 'keys:manage'),
+    ('moderator', 'search:read'),
+    ('moderator', 'comics:update'),
+    ('user', 'search:read');
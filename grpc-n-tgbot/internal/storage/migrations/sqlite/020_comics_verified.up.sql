@@ -0,0 +1,6 @@
+ALTER TABLE comics ADD COLUMN verified BOOLEAN NOT NULL DEFAULT 1;
+
+-- старые сборки сохраняли комиксы с автоинкрементным id, который не совпадает с номером на xkcd.com,
+-- а номер подтвержден только у комиксов, загруженных по номеру вместе со статусом загрузки
+UPDATE comics SET verified = 0
+WHERE id NOT IN (SELECT num FROM ingestion_status WHERE status IN ('ok', 'no_image'));
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMigrator(t *testing.T, dsn string) *Migrator {
+	mg, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mg.Close() })
+	return mg
+}
+
+func TestMigrator(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "xkcd.db")
+	mg := newTestMigrator(t, dsn)
+
+	version, dirty, err := mg.Version()
+	assert.NoError(t, err)
+	assert.False(t, dirty)
+	assert.Equal(t, uint(0), version)
+
+	// dry-run печатает план, но не меняет схему
+	var out bytes.Buffer
+	mg.Out, mg.DryRun = &out, true
+	assert.NoError(t, mg.Goto(3))
+	assert.Contains(t, out.String(), "-- 1 up (comics)")
+	assert.NotContains(t, out.String(), "-- 2 up")
+	assert.Contains(t, out.String(), "CREATE TABLE IF NOT EXISTS users")
+	version, _, _ = mg.Version()
+	assert.Equal(t, uint(0), version)
+
+	mg.DryRun = false
+	assert.NoError(t, mg.Up())
+	version, _, _ = mg.Version()
+	assert.Equal(t, mg.Latest(), version)
+
+	out.Reset()
+	mg.DryRun = true
+	assert.NoError(t, mg.Down(2))
+	assert.Contains(t, out.String(), fmt.Sprintf("-- %d down", mg.Latest()))
+	assert.Contains(t, out.String(), fmt.Sprintf("-- %d down", mg.Latest()-1))
+	assert.NotContains(t, out.String(), fmt.Sprintf("-- %d down", mg.Latest()-2))
+	assert.Error(t, mg.Down(100))
+
+	mg.DryRun = false
+	assert.NoError(t, mg.Down(2))
+	version, _, _ = mg.Version()
+	assert.Equal(t, mg.Latest()-2, version)
+
+	assert.NoError(t, mg.Goto(mg.Latest()))
+	assert.Error(t, mg.Goto(mg.Latest()+1))
+
+	// хранилище открывается поверх уже мигрированной базы
+	st, err := Open(dsn)
+	assert.NoError(t, err)
+	st.Close()
+}
+
+func TestMigratorSchemaTooNew(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "xkcd.db")
+	mg := newTestMigrator(t, dsn)
+
+	assert.NoError(t, mg.Up())
+	assert.NoError(t, mg.Force(int(mg.Latest())+1))
+
+	assert.ErrorIs(t, mg.Up(), ErrSchemaTooNew)
+
+	_, err := Open(dsn)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+
+	// force остаётся способом вернуть базу под управление
+	assert.NoError(t, mg.Force(int(mg.Latest())))
+	assert.NoError(t, mg.Up())
+}
+
+// откат и повторный накат проходят по тем же версиям и оставляют рабочую схему
+func TestMigratorDownUp(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "xkcd.db")
+	mg := newTestMigrator(t, dsn)
+
+	assert.NoError(t, mg.Up())
+	assert.NoError(t, mg.Goto(3))
+	version, dirty, _ := mg.Version()
+	assert.Equal(t, uint(3), version)
+	assert.False(t, dirty)
+
+	var out bytes.Buffer
+	mg.Out, mg.DryRun = &out, true
+	assert.NoError(t, mg.Goto(1))
+	assert.Contains(t, out.String(), "-- 3 down (users)")
+	assert.Contains(t, out.String(), "DROP TABLE IF EXISTS users")
+	mg.DryRun = false
+
+	// users на версии 3 еще есть, поэтому 005 с заполнением users проходит
+	assert.NoError(t, mg.Up())
+	assert.NoError(t, mg.Goto(1))
+	assert.NoError(t, mg.Down(1))
+	version, _, _ = mg.Version()
+	assert.Equal(t, uint(0), version)
+	assert.NoError(t, mg.Up())
+	version, dirty, _ = mg.Version()
+	assert.Equal(t, mg.Latest(), version)
+	assert.False(t, dirty)
+
+	st, err := Open(dsn)
+	assert.NoError(t, err)
+	defer st.Close()
+	_, err = st.GetUserByUsername(context.Background(), "admin")
+	assert.NoError(t, err)
+}
+
+// базы, которые старые сборки оставили на версии 2 или 4, продолжают мигрировать
+func TestMigratorLegacyVersions(t *testing.T) {
+	for _, tt := range []struct{ legacy, goTo uint }{{2, 1}, {4, 3}} {
+		dsn := "sqlite://" + filepath.Join(t.TempDir(), "xkcd.db")
+		mg := newTestMigrator(t, dsn)
+
+		assert.NoError(t, mg.Goto(tt.goTo))
+		assert.NoError(t, mg.Force(int(tt.legacy)))
+		assert.NoError(t, mg.Up())
+		version, dirty, _ := mg.Version()
+		assert.Equal(t, mg.Latest(), version, "legacy version %d", tt.legacy)
+		assert.False(t, dirty)
+	}
+}
+
+// комиксы, которые старые сборки сохранили без статуса загрузки, остаются непроверенными
+func TestMigratorMarksLegacyComics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xkcd.db")
+	mg := newTestMigrator(t, "sqlite://"+path)
+	assert.NoError(t, mg.Goto(19))
+
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	for _, query := range []string{
+		"INSERT INTO comics (id, url, keywords) VALUES (1, '1.png', ''), (2, '3.png', ''), (3, '3.png', '')",
+		"INSERT INTO ingestion_status (num, status) VALUES (3, 'ok')",
+	} {
+		_, err := db.Exec(query)
+		assert.NoError(t, err)
+	}
+	db.Close()
+
+	assert.NoError(t, mg.Up())
+	st, err := Open("sqlite://" + path)
+	assert.NoError(t, err)
+	defer st.Close()
+	ids, err := st.GetUnverifiedComicIDs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+}
+
+func TestMigratorUnsupportedBackend(t *testing.T) {
+	_, err := NewMigrator("memory://")
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+)
+
+// Storage объединяет интерфейсы хранилища, которые нужны пакетам service, search, xkcd и images
+type Storage interface {
+	GetComicByID(ctx context.Context, id int) (core.Comic, error)
+	GetLatestComic(ctx context.Context) (core.Comic, error)
+	GetAllComics(ctx context.Context) ([]core.Comic, error)
+	SaveComicToDatabase(ctx context.Context, comic core.Comic) error
+	GetComicsToNormalize(ctx context.Context, afterID, version, limit int) ([]core.Comic, error)
+	UpdateComicKeywords(ctx context.Context, id int, keywords string, terms []core.ComicTerm, version int) error
+	SearchTerms(ctx context.Context, terms []string) (map[int]int, error)
+	SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error
+	SetComicHidden(ctx context.Context, id int, hidden bool) error
+	DeleteComic(ctx context.Context, id int) error
+	GetComicRevisions(ctx context.Context, id int) ([]core.ComicRevision, error)
+	GetIngestionStatus(ctx context.Context, num int) (core.IngestionStatus, error)
+	SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) error
+	GetIngestionStatuses(ctx context.Context) ([]core.IngestionStatus, error)
+	CountIngestionStatuses(ctx context.Context) (map[string]int, error)
+	GetComicIDs(ctx context.Context) ([]int, error)
+	GetUnverifiedComicIDs(ctx context.Context) ([]int, error)
+	VerifyComic(ctx context.Context, id int) error
+	RenumberComic(ctx context.Context, comic core.Comic) error
+	GetComicImage(ctx context.Context, id int) (core.ComicImage, error)
+	SaveComicImage(ctx context.Context, image core.ComicImage) error
+	GetCount(ctx context.Context) (int, error)
+	CreateUser(ctx context.Context, username, password, role string) error
+	GetUserByUsername(ctx context.Context, username string) (core.User, error)
+	ListUsers(ctx context.Context, limit, offset int) ([]core.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	SetUserRole(ctx context.Context, username, role string) error
+	SetUserDisabled(ctx context.Context, username string, disabled bool) error
+	UpdateUserPassword(ctx context.Context, username, password string) error
+	DeleteUser(ctx context.Context, username string) error
+	UpsertUser(ctx context.Context, user core.User) error
+	GetRole(ctx context.Context, name string) (core.Role, error)
+	ListRoles(ctx context.Context) ([]core.Role, error)
+	ListPermissions(ctx context.Context) ([]string, error)
+	SaveRole(ctx context.Context, role core.Role) error
+	DeleteRole(ctx context.Context, name string) error
+	UpsertComic(ctx context.Context, comic core.Comic) error
+	GetComicsAfter(ctx context.Context, afterID, limit int) ([]core.Comic, error)
+	SaveAuditRecord(ctx context.Context, record core.AuditRecord) error
+	GetAuditRecords(ctx context.Context, filter core.AuditFilter) ([]core.AuditRecord, error)
+	AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, name, owner string) error
+	SaveRefreshToken(ctx context.Context, token core.RefreshToken) error
+	UseRefreshToken(ctx context.Context, id string) (core.RefreshToken, error)
+	RevokeRefreshFamily(ctx context.Context, family string) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, username string, before time.Time) error
+	TokenRevoked(ctx context.Context, jti, username string, issuedAt time.Time) (bool, error)
+	DeleteExpiredTokens(ctx context.Context, now time.Time) error
+	SaveAPIKey(ctx context.Context, key core.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (core.APIKey, error)
+	ListAPIKeys(ctx context.Context, username string) ([]core.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+	GetLoginFailures(ctx context.Context, subject string) (core.LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, subject string, at, resetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, subject string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, subject string) error
+	ListLoginLocks(ctx context.Context, now time.Time) ([]core.LoginFailures, error)
+	DeleteLoginFailures(ctx context.Context, before, now time.Time) error
+	Health(ctx context.Context) core.StorageHealth
+	PrettyPrint(v []core.Comic) bytes.Buffer
+	Close() error
+}
+
+// Opener открывает хранилище по полному DSN, включая схему
+type Opener func(dsn string) (Storage, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Opener{}
+)
+
+// Register регистрирует бэкенд для схемы DSN (mysql, sqlite, memory)
+func Register(scheme string, opener Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[scheme]; ok {
+		panic("storage: backend already registered: " + scheme)
+	}
+	backends[scheme] = opener
+}
+
+// Open выбирает бэкенд по схеме DSN. DSN без схемы считается адресом MySQL,
+// чтобы старые конфиги продолжали работать
+func Open(dsn string) (Storage, error) {
+	scheme := schemeOf(dsn)
+
+	backendsMu.RLock()
+	opener, ok := backends[scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q", scheme)
+	}
+
+	return opener(dsn)
+}
+
+// schemeOf возвращает схему DSN; DSN без схемы считается адресом MySQL
+func schemeOf(dsn string) string {
+	if i := strings.Index(dsn, "://"); i > 0 {
+		return dsn[:i]
+	}
+	return "mysql"
+}
+
+// trimScheme возвращает DSN без схемы, в виде, понятном драйверу
+func trimScheme(dsn string) string {
+	if i := strings.Index(dsn, "://"); i > 0 {
+		return dsn[i+len("://"):]
+	}
+	return dsn
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	upsert: func(key string, columns ...string) string {
+		set := make([]string, len(columns))
+		for i, column := range columns {
+			set[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+		}
+		return fmt.Sprintf(" ON CONFLICT(%s) DO UPDATE SET %s", key, strings.Join(set, ", "))
+	},
+	// sqlite блокирует всю базу на запись, отдельная блокировка строк не нужна
+	forUpdate: "",
+	duplicate: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		if !errors.As(err, &sqliteErr) {
+			return false
+		}
+		code := sqliteErr.Code()
+		return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	},
+	now:     "datetime('now')",
+	nowPlus: "datetime('now', ? || ' seconds')",
+}
+
+// memoryDBs нумерует in-memory базы, чтобы каждое хранилище получало свою
+var memoryDBs atomic.Int64
+
+func init() {
+	Register("sqlite", openSQLite)
+	Register("memory", openMemory)
+}
+
+// openSQLite открывает файл базы по адресу вида sqlite://path/to/xkcd.db
+func openSQLite(dsn string) (Storage, error) {
+	path := trimScheme(dsn)
+	if path == "" {
+		return nil, fmt.Errorf("empty sqlite path in dsn %q", dsn)
+	}
+	return newSQLiteStorage("file:" + path + "?_pragma=busy_timeout(5000)")
+}
+
+// openMemory создаёт пустую базу в памяти, данные живут до закрытия хранилища
+func openMemory(string) (Storage, error) {
+	return newSQLiteStorage(fmt.Sprintf("file:memory%d?mode=memory&cache=shared", memoryDBs.Add(1)))
+}
+
+func newSQLiteStorage(dsn string) (*SQLStorage, error) {
+	db, err := openSQLiteDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("applying migrations: %w", err)
+	}
+
+	return newSQLStorage(db, sqliteDialect), nil
+}
+
+func openSQLiteDB(dsn string) (*sql.DB, error) {
+	// внешние ключи в sqlite включаются для каждого соединения отдельно
+	if strings.Contains(dsn, "?") {
+		dsn += "&_pragma=foreign_keys(1)"
+	} else {
+		dsn += "?_pragma=foreign_keys(1)"
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// одно соединение: sqlite не поддерживает параллельную запись,
+	// а база в памяти существует, пока открыто хотя бы одно соединение
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	mg, err := newSQLiteMigrator(db)
+	if err != nil {
+		return err
+	}
+	return mg.Up()
+}
@@ -8,28 +8,51 @@ import (
 	"github.com/kljensen/snowball"
 )
 
+// Version - версия нормализатора. Увеличивается при любом изменении стоп-слов,
+// стемминга или StyledToNormal, чтобы сохраненные ключевые слова можно было пересчитать.
+// Версия 2 добавила таблицу comic_terms, пересчёт заполняет её для старых комиксов
+const Version = 2
+
 func NormalizeWords(texts ...string) []string {
 	var words []string
 	seen := make(map[string]bool)
 	for _, text := range texts {
-		// разбивка на слова
-		splitWords := strings.FieldsFunc(text, func(r rune) bool {
-			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
-		})
-		// нормализация и аппенд
-		for _, word := range splitWords {
-			normalized := unstyle(normalize(word))
-			if !seen[normalized] && !Stopwords[normalized] && !IsStopWord(word) {
+		for _, normalized := range normalizedTokens(text) {
+			if !seen[normalized] {
 				seen[normalized] = true
-				if normalized != "" && len(normalized) > 2 {
-					words = append(words, normalized)
-				}
+				words = append(words, normalized)
 			}
 		}
 	}
 	return words
 }
 
+// TermFrequencies считает, сколько раз каждое нормализованное слово встречается в тексте
+func TermFrequencies(text string) map[string]int {
+	tf := make(map[string]int)
+	for _, normalized := range normalizedTokens(text) {
+		tf[normalized]++
+	}
+	return tf
+}
+
+// normalizedTokens возвращает нормализованные слова текста без стоп-слов, с повторами
+func normalizedTokens(text string) []string {
+	var tokens []string
+	// разбивка на слова
+	splitWords := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	// нормализация и аппенд
+	for _, word := range splitWords {
+		normalized := unstyle(normalize(word))
+		if !Stopwords[normalized] && !IsStopWord(word) && normalized != "" && len(normalized) > 2 {
+			tokens = append(tokens, normalized)
+		}
+	}
+	return tokens
+}
+
 func normalize(word string) string {
 	// удаление лишних символов
 	f := func(r rune) bool {
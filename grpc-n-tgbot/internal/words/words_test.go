@@ -97,4 +97,10 @@ func TestNormalizeWords(t *testing.T) {
 		msg := fmt.Sprintf("NormalizeWords(%v) = %v; expected %v", test.input, result, test.expected)
 		assert.Equal(t, test.expected, result, "they should be equal", msg)
 	}
-}
\ No newline at end of file
+}
+func TestTermFrequencies(t *testing.T) {
+	tf := TermFrequencies("An apple a day keeps the doctor away, apples!")
+	assert.Equal(t, map[string]int{"appl": 2, "day": 1, "keep": 1, "doctor": 1, "away": 1}, tf)
+
+	assert.Empty(t, TermFrequencies(""))
+}
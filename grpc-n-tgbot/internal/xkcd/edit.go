@@ -0,0 +1,48 @@
+package xkcd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/words"
+)
+
+// ApplyPatch применяет ручную правку к комиксу: пересчитывает ключевые слова, comic_terms
+// и хеш содержимого. Возвращает исправленный комикс и diff для истории ревизий
+func ApplyPatch(comic core.Comic, patch core.ComicPatch, now time.Time) (core.Comic, string) {
+	edited := comic
+	if patch.Title != nil {
+		edited.Title = *patch.Title
+	}
+	if patch.Alt != nil {
+		edited.Alt = *patch.Alt
+	}
+	if patch.Transcript != nil {
+		edited.Transcript = *patch.Transcript
+	}
+
+	if patch.Keywords != nil {
+		// заданные вручную слова нормализуются так же, как поисковый запрос
+		keywords := words.NormalizeWords(strings.Join(*patch.Keywords, " "))
+		edited.Keywords = strings.Join(keywords, ",")
+		edited.Terms = make([]core.ComicTerm, len(keywords))
+		for i, keyword := range keywords {
+			edited.Terms[i] = core.ComicTerm{Term: keyword, Field: core.FieldKeywords, TF: 1}
+		}
+	} else {
+		edited.Keywords = strings.Join(words.NormalizeWords(edited.Title, edited.Transcript, edited.Alt), ",")
+		edited.Terms = ComicTerms(edited)
+	}
+
+	edited.NormVersion = words.Version
+	edited.ContentHash = contentHash(edited)
+	edited.EditedAt = now
+
+	diff := comicDiff(comic, edited)
+	if comic.Keywords != edited.Keywords {
+		diff += fmt.Sprintf("--- keywords\n+++ keywords\n-%s\n+%s\n", comic.Keywords, edited.Keywords)
+	}
+	return edited, diff
+}
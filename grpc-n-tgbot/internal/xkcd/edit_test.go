@@ -0,0 +1,77 @@
+package xkcd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sgsoul/internal/core"
+	mocks "github.com/sgsoul/internal/xkcd/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPatch(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	comic := core.Comic{ID: 1, URL: "http://example.com/1.png", Title: "Apples", Transcript: "garbled trnscript", Keywords: "appl,garbl,trnscript"}
+	comic.ContentHash = contentHash(comic)
+
+	transcript := "An apple a day"
+	edited, diff := ApplyPatch(comic, core.ComicPatch{Transcript: &transcript}, now)
+	assert.Equal(t, "appl,day", edited.Keywords)
+	assert.Equal(t, now, edited.EditedAt)
+	assert.NotEqual(t, comic.ContentHash, edited.ContentHash)
+	assert.Contains(t, diff, "-garbled trnscript\n+An apple a day\n")
+	assert.Contains(t, diff, "--- keywords\n+++ keywords\n-appl,garbl,trnscript\n+appl,day\n")
+	assert.Contains(t, edited.Terms, core.ComicTerm{Term: "day", Field: core.FieldTranscript, TF: 1})
+
+	keywords := []string{"Doctors", "the"}
+	edited, _ = ApplyPatch(comic, core.ComicPatch{Keywords: &keywords}, now)
+	assert.Equal(t, "doctor", edited.Keywords)
+	assert.Equal(t, []core.ComicTerm{{Term: "doctor", Field: core.FieldKeywords, TF: 1}}, edited.Terms)
+	assert.Equal(t, comic.ContentHash, edited.ContentHash)
+}
+
+func TestRefetchComic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"num": 1, "title": "Apples", "transcript": "garbled", "img": "http://example.com/1.png"}`))
+	}))
+	defer server.Close()
+
+	upstream := core.Comic{ID: 1, URL: "http://example.com/1.png", Title: "Apples", Transcript: "garbled"}
+	upstream.ContentHash = contentHash(upstream)
+
+	// ручная правка отменяется даже при совпадающем хеше
+	edited := upstream
+	edited.Keywords = "doctor"
+	edited.Hidden = true
+	edited.EditedAt = time.Now()
+
+	mockStorage := mocks.NewMockStorage(ctrl)
+	gomock.InOrder(
+		mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(edited, nil),
+		mockStorage.EXPECT().SaveComicRevision(gomock.Any(), gomock.Any(), "").
+			DoAndReturn(func(_ context.Context, comic core.Comic, diff string) error {
+				assert.True(t, comic.EditedAt.IsZero())
+				assert.Equal(t, "appl,garbl", comic.Keywords)
+				return nil
+			}),
+		mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(upstream, nil),
+	)
+
+	client := NewClient(server.URL, mockStorage)
+
+	comic, err := client.RefetchComic(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.True(t, comic.Hidden)
+
+	// без правок и изменений на xkcd.com сохранять нечего
+	comic, err = client.RefetchComic(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, upstream, comic)
+}
@@ -0,0 +1,43 @@
+package xkcd
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+)
+
+// InfoFromComic собирает из сохраненного комикса ответ в формате info.0.json
+func InfoFromComic(comic core.Comic) core.ComicInfo {
+	info := core.ComicInfo{
+		Num:        comic.ID,
+		Link:       comic.Link,
+		News:       comic.News,
+		SafeTitle:  comic.SafeTitle,
+		Transcript: comic.Transcript,
+		Alt:        comic.Alt,
+		Img:        comic.URL,
+		Title:      comic.Title,
+	}
+	if info.SafeTitle == "" {
+		info.SafeTitle = comic.Title
+	}
+	if !comic.Published.IsZero() {
+		// xkcd.com отдает дату строками без ведущих нулей
+		info.Year = strconv.Itoa(comic.Published.Year())
+		info.Month = strconv.Itoa(int(comic.Published.Month()))
+		info.Day = strconv.Itoa(comic.Published.Day())
+	}
+	return info
+}
+
+// publishedDate разбирает дату публикации из info.0.json; при ошибке возвращает нулевое время
+func publishedDate(info core.ComicInfo) time.Time {
+	year, errYear := strconv.Atoi(info.Year)
+	month, errMonth := strconv.Atoi(info.Month)
+	day, errDay := strconv.Atoi(info.Day)
+	if errYear != nil || errMonth != nil || errDay != nil {
+		return time.Time{}
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
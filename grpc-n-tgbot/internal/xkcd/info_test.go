@@ -0,0 +1,31 @@
+package xkcd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfoRoundTrip(t *testing.T) {
+	original := `{"month": "1", "num": 1, "link": "", "year": "2006", "news": "", "safe_title": "Barrel - Part 1",
+		"transcript": "[[A boy sits in a barrel]]", "alt": "Don't we all.",
+		"img": "https://imgs.xkcd.com/comics/barrel_cropped_(1).jpg", "title": "Barrel - Part 1", "day": "1"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(original))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, nil)
+	comic, err := client.retrieveComic(context.Background(), 1)
+	assert.NoError(t, err)
+
+	// сервис, синхронизирующийся с нашим зеркалом, должен получить тот же ответ
+	mirrored, err := json.Marshal(InfoFromComic(comic))
+	assert.NoError(t, err)
+	assert.JSONEq(t, original, string(mirrored))
+}
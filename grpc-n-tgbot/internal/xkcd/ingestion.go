@@ -0,0 +1,107 @@
+package xkcd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+)
+
+var (
+	ErrComicNotFound = errors.New("comic not found")
+	ErrNoImage       = errors.New("comic has no image")
+	ErrDecode        = errors.New("error decoding comic")
+)
+
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// повторные попытки для неудачных исходов; ok и no_image сохраняются в базу и не повторяются
+var retryPolicies = map[string]retryPolicy{
+	core.StatusNotFound:    {maxAttempts: 3, backoff: 24 * time.Hour},
+	core.StatusDecodeError: {maxAttempts: 5, backoff: time.Hour},
+	core.StatusFetchError:  {maxAttempts: 10, backoff: 10 * time.Minute},
+}
+
+// statusOf сопоставляет ошибку загрузки с исходом из ingestion_status
+func statusOf(err error) string {
+	switch {
+	case err == nil:
+		return core.StatusOK
+	case errors.Is(err, ErrNoImage):
+		return core.StatusNoImage
+	case errors.Is(err, ErrComicNotFound):
+		return core.StatusNotFound
+	case errors.Is(err, ErrDecode):
+		return core.StatusDecodeError
+	default:
+		return core.StatusFetchError
+	}
+}
+
+// shouldRetry решает, пора ли снова загружать номер с прошлым исходом status
+func shouldRetry(status core.IngestionStatus, now time.Time) bool {
+	policy, ok := retryPolicies[status.Status]
+	if !ok {
+		return true
+	}
+	if status.Attempts >= policy.maxAttempts {
+		return false
+	}
+	return !now.Before(status.NextRetry)
+}
+
+// nextStatus возвращает запись о новой попытке загрузки номера num
+func nextStatus(num int, prev core.IngestionStatus, err error, now time.Time) core.IngestionStatus {
+	status := core.IngestionStatus{
+		Num:         num,
+		Status:      statusOf(err),
+		Attempts:    1,
+		LastAttempt: now,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if prev.Status == status.Status {
+		status.Attempts = prev.Attempts + 1
+	}
+
+	if policy, ok := retryPolicies[status.Status]; ok {
+		// экспоненциальная задержка между попытками
+		status.NextRetry = now.Add(policy.backoff << (status.Attempts - 1))
+	}
+	return status
+}
+
+// imageURL выбирает адрес картинки: ручная замена, img или ссылка на картинку из link
+func (c *Client) imageURL(info core.ComicInfo) (string, error) {
+	if override, ok := c.imageOverrides[info.Num]; ok {
+		return override, nil
+	}
+	if isImageURL(info.Img) {
+		return info.Img, nil
+	}
+	if isImageURL(info.Link) {
+		return info.Link, nil
+	}
+	// у интерактивных комиксов картинки нет, ссылаемся на страницу комикса
+	return fmt.Sprintf("%s/%d/", c.baseURL, info.Num), ErrNoImage
+}
+
+func isImageURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp":
+		return true
+	}
+	return false
+}
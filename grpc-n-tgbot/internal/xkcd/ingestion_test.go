@@ -0,0 +1,84 @@
+package xkcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOf(t *testing.T) {
+	assert.Equal(t, core.StatusOK, statusOf(nil))
+	assert.Equal(t, core.StatusNotFound, statusOf(fmt.Errorf("comic 404: %w", ErrComicNotFound)))
+	assert.Equal(t, core.StatusNoImage, statusOf(fmt.Errorf("comic 1608: %w", ErrNoImage)))
+	assert.Equal(t, core.StatusDecodeError, statusOf(fmt.Errorf("comic 1: %w", ErrDecode)))
+	assert.Equal(t, core.StatusFetchError, statusOf(errors.New("connection refused")))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	now := time.Now()
+
+	first := nextStatus(404, core.IngestionStatus{}, ErrComicNotFound, now)
+	assert.Equal(t, 1, first.Attempts)
+	assert.Equal(t, now.Add(24*time.Hour), first.NextRetry)
+	assert.False(t, shouldRetry(first, now))
+	assert.True(t, shouldRetry(first, now.Add(24*time.Hour)))
+
+	second := nextStatus(404, first, ErrComicNotFound, now)
+	assert.Equal(t, 2, second.Attempts)
+	assert.Equal(t, now.Add(48*time.Hour), second.NextRetry)
+
+	third := nextStatus(404, second, ErrComicNotFound, now)
+	assert.False(t, shouldRetry(third, now.Add(365*24*time.Hour)))
+
+	ok := nextStatus(1, third, nil, now)
+	assert.Equal(t, core.StatusOK, ok.Status)
+	assert.Equal(t, 1, ok.Attempts)
+	assert.True(t, ok.NextRetry.IsZero())
+}
+
+func TestRetrieveComicImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/info.0.json":
+			_, _ = w.Write([]byte(`{"num": 1, "title": "Interactive", "img": "https://imgs.xkcd.com/comics/"}`))
+		case "/2/info.0.json":
+			_, _ = w.Write([]byte(`{"num": 2, "title": "Large", "img": "", "link": "https://imgs.xkcd.com/comics/large_large.png"}`))
+		case "/3/info.0.json":
+			_, _ = w.Write([]byte(`{"num": 3, "title": "Override", "img": "https://imgs.xkcd.com/comics/"}`))
+		case "/4/info.0.json":
+			_, _ = w.Write([]byte(`not json`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, nil)
+	client.SetImageOverrides(map[int]string{3: "https://example.com/override.png"})
+
+	comic, err := client.retrieveComic(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrNoImage)
+	assert.Equal(t, server.URL+"/1/", comic.URL)
+	assert.Equal(t, "interact", comic.Keywords)
+
+	comic, err = client.retrieveComic(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://imgs.xkcd.com/comics/large_large.png", comic.URL)
+
+	comic, err = client.retrieveComic(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/override.png", comic.URL)
+
+	_, err = client.retrieveComic(context.Background(), 4)
+	assert.ErrorIs(t, err, ErrDecode)
+
+	_, err = client.retrieveComic(context.Background(), 404)
+	assert.ErrorIs(t, err, ErrComicNotFound)
+}
@@ -5,6 +5,7 @@
 package mock_xkcd
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,31 +35,183 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// DeleteComic mocks base method.
+func (m *MockStorage) DeleteComic(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteComic", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteComic indicates an expected call of DeleteComic.
+func (mr *MockStorageMockRecorder) DeleteComic(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteComic", reflect.TypeOf((*MockStorage)(nil).DeleteComic), ctx, id)
+}
+
 // GetComicByID mocks base method.
-func (m *MockStorage) GetComicByID(id int) (core.Comic, error) {
+func (m *MockStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetComicByID", id)
+	ret := m.ctrl.Call(m, "GetComicByID", ctx, id)
 	ret0, _ := ret[0].(core.Comic)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetComicByID indicates an expected call of GetComicByID.
-func (mr *MockStorageMockRecorder) GetComicByID(id interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetComicByID(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), ctx, id)
+}
+
+// GetIngestionStatus mocks base method.
+func (m *MockStorage) GetIngestionStatus(ctx context.Context, num int) (core.IngestionStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIngestionStatus", ctx, num)
+	ret0, _ := ret[0].(core.IngestionStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIngestionStatus indicates an expected call of GetIngestionStatus.
+func (mr *MockStorageMockRecorder) GetIngestionStatus(ctx, num interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIngestionStatus", reflect.TypeOf((*MockStorage)(nil).GetIngestionStatus), ctx, num)
+}
+
+// GetUnverifiedComicIDs mocks base method.
+func (m *MockStorage) GetUnverifiedComicIDs(ctx context.Context) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUnverifiedComicIDs", ctx)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnverifiedComicIDs indicates an expected call of GetUnverifiedComicIDs.
+func (mr *MockStorageMockRecorder) GetUnverifiedComicIDs(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnverifiedComicIDs", reflect.TypeOf((*MockStorage)(nil).GetUnverifiedComicIDs), ctx)
+}
+
+// RenumberComic mocks base method.
+func (m *MockStorage) RenumberComic(ctx context.Context, comic core.Comic) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenumberComic", ctx, comic)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenumberComic indicates an expected call of RenumberComic.
+func (mr *MockStorageMockRecorder) RenumberComic(ctx, comic interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetComicByID", reflect.TypeOf((*MockStorage)(nil).GetComicByID), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenumberComic", reflect.TypeOf((*MockStorage)(nil).RenumberComic), ctx, comic)
+}
+
+// SaveComicRevision mocks base method.
+func (m *MockStorage) SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveComicRevision", ctx, comic, diff)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveComicRevision indicates an expected call of SaveComicRevision.
+func (mr *MockStorageMockRecorder) SaveComicRevision(ctx, comic, diff interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicRevision", reflect.TypeOf((*MockStorage)(nil).SaveComicRevision), ctx, comic, diff)
 }
 
 // SaveComicToDatabase mocks base method.
-func (m *MockStorage) SaveComicToDatabase(comic core.Comic) error {
+func (m *MockStorage) SaveComicToDatabase(ctx context.Context, comic core.Comic) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveComicToDatabase", comic)
+	ret := m.ctrl.Call(m, "SaveComicToDatabase", ctx, comic)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveComicToDatabase indicates an expected call of SaveComicToDatabase.
-func (mr *MockStorageMockRecorder) SaveComicToDatabase(comic interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) SaveComicToDatabase(ctx, comic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicToDatabase", reflect.TypeOf((*MockStorage)(nil).SaveComicToDatabase), ctx, comic)
+}
+
+// SaveIngestionStatus mocks base method.
+func (m *MockStorage) SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveIngestionStatus", ctx, status)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveIngestionStatus indicates an expected call of SaveIngestionStatus.
+func (mr *MockStorageMockRecorder) SaveIngestionStatus(ctx, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveIngestionStatus", reflect.TypeOf((*MockStorage)(nil).SaveIngestionStatus), ctx, status)
+}
+
+// UpsertComic mocks base method.
+func (m *MockStorage) UpsertComic(ctx context.Context, comic core.Comic) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertComic", ctx, comic)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertComic indicates an expected call of UpsertComic.
+func (mr *MockStorageMockRecorder) UpsertComic(ctx, comic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertComic", reflect.TypeOf((*MockStorage)(nil).UpsertComic), ctx, comic)
+}
+
+// VerifyComic mocks base method.
+func (m *MockStorage) VerifyComic(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyComic", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyComic indicates an expected call of VerifyComic.
+func (mr *MockStorageMockRecorder) VerifyComic(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyComic", reflect.TypeOf((*MockStorage)(nil).VerifyComic), ctx, id)
+}
+
+// MockImageMirror is a mock of ImageMirror interface.
+type MockImageMirror struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageMirrorMockRecorder
+}
+
+// MockImageMirrorMockRecorder is the mock recorder for MockImageMirror.
+type MockImageMirrorMockRecorder struct {
+	mock *MockImageMirror
+}
+
+// NewMockImageMirror creates a new mock instance.
+func NewMockImageMirror(ctrl *gomock.Controller) *MockImageMirror {
+	mock := &MockImageMirror{ctrl: ctrl}
+	mock.recorder = &MockImageMirrorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageMirror) EXPECT() *MockImageMirrorMockRecorder {
+	return m.recorder
+}
+
+// MirrorImage mocks base method.
+func (m *MockImageMirror) MirrorImage(ctx context.Context, comicID int, url string) (core.ComicImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MirrorImage", ctx, comicID, url)
+	ret0, _ := ret[0].(core.ComicImage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MirrorImage indicates an expected call of MirrorImage.
+func (mr *MockImageMirrorMockRecorder) MirrorImage(ctx, comicID, url interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveComicToDatabase", reflect.TypeOf((*MockStorage)(nil).SaveComicToDatabase), comic)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MirrorImage", reflect.TypeOf((*MockImageMirror)(nil).MirrorImage), ctx, comicID, url)
 }
@@ -0,0 +1,93 @@
+package xkcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/sgsoul/internal/core"
+)
+
+// contentHash - хеш исходного содержимого комикса, по которому определяются правки на xkcd.com
+func contentHash(comic core.Comic) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{comic.URL, comic.Title, comic.Alt, comic.Transcript}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// comicDiff возвращает построчный diff изменившихся полей комикса
+func comicDiff(old, new core.Comic) string {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"img", old.URL, new.URL},
+		{"title", old.Title, new.Title},
+		{"alt", old.Alt, new.Alt},
+		{"transcript", old.Transcript, new.Transcript},
+	}
+
+	var builder strings.Builder
+	for _, field := range fields {
+		if field.old == field.new {
+			continue
+		}
+		fmt.Fprintf(&builder, "--- %s\n+++ %s\n", field.name, field.name)
+		for _, line := range diffLines(field.old, field.new) {
+			builder.WriteString(line)
+			builder.WriteByte('\n')
+		}
+	}
+	return builder.String()
+}
+
+// diffLines сравнивает тексты построчно через наибольшую общую подпоследовательность
+// и возвращает только удаленные ("-") и добавленные ("+") строки
+func diffLines(a, b string) []string {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] - длина общей подпоследовательности x[i:] и y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, "+"+y[j])
+	}
+	return diff
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
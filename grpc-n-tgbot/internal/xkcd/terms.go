@@ -0,0 +1,33 @@
+package xkcd
+
+import (
+	"sort"
+
+	"github.com/sgsoul/internal/core"
+	"github.com/sgsoul/internal/words"
+)
+
+// ComicTerms раскладывает исходный текст комикса на нормализованные слова по полям
+func ComicTerms(comic core.Comic) []core.ComicTerm {
+	fields := []struct {
+		name string
+		text string
+	}{
+		{core.FieldTitle, comic.Title},
+		{core.FieldAlt, comic.Alt},
+		{core.FieldTranscript, comic.Transcript},
+	}
+
+	var terms []core.ComicTerm
+	for _, field := range fields {
+		tf := words.TermFrequencies(field.text)
+		start := len(terms)
+		for term, count := range tf {
+			terms = append(terms, core.ComicTerm{Term: term, Field: field.name, TF: count})
+		}
+		// порядок внутри поля стабилен, чтобы вставки и тесты были детерминированы
+		fieldTerms := terms[start:]
+		sort.Slice(fieldTerms, func(i, j int) bool { return fieldTerms[i].Term < fieldTerms[j].Term })
+	}
+	return terms
+}
@@ -1,12 +1,16 @@
 package xkcd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/rs/zerolog/log"
 	"github.com/sgsoul/internal/core"
@@ -16,13 +20,28 @@ import (
 //go:generate mockgen -source=xkcd.go -destination=mocks/mock.go
 
 type Storage interface {
-	GetComicByID(id int) (core.Comic, error)
-	SaveComicToDatabase(comic core.Comic) error
+	GetComicByID(ctx context.Context, id int) (core.Comic, error)
+	SaveComicToDatabase(ctx context.Context, comic core.Comic) error
+	SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error
+	UpsertComic(ctx context.Context, comic core.Comic) error
+	GetIngestionStatus(ctx context.Context, num int) (core.IngestionStatus, error)
+	SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) error
+	GetUnverifiedComicIDs(ctx context.Context) ([]int, error)
+	VerifyComic(ctx context.Context, id int) error
+	RenumberComic(ctx context.Context, comic core.Comic) error
+	DeleteComic(ctx context.Context, id int) error
+}
+
+// ImageMirror сохраняет локальную копию картинки комикса
+type ImageMirror interface {
+	MirrorImage(ctx context.Context, comicID int, url string) (core.ComicImage, error)
 }
 
 type Client struct {
-	baseURL string
-	storage Storage
+	baseURL        string
+	storage        Storage
+	imageOverrides map[int]string
+	images         ImageMirror
 }
 
 func NewClient(url string, st Storage) *Client {
@@ -32,11 +51,36 @@ func NewClient(url string, st Storage) *Client {
 	}
 }
 
-func (c *Client) retrieveComic(num int) (core.Comic, error) {
+// SetImageOverrides задает адреса картинок для комиксов, у которых img пустой или неверный
+func (c *Client) SetImageOverrides(overrides map[int]string) {
+	c.imageOverrides = overrides
+}
+
+// SetImageMirror включает скачивание картинок при загрузке комиксов
+func (c *Client) SetImageMirror(images ImageMirror) {
+	c.images = images
+}
+
+func (c *Client) mirrorImage(ctx context.Context, comic core.Comic) {
+	if c.images == nil {
+		return
+	}
+	if _, err := c.images.MirrorImage(ctx, comic.ID, comic.URL); err != nil {
+		log.Error().Err(err).Msgf("error mirroring image of comic %d", comic.ID)
+	}
+}
+
+// retrieveComic загружает комикс с номером num. Для комиксов без картинки
+// возвращается заполненный комикс вместе с ErrNoImage
+func (c *Client) retrieveComic(ctx context.Context, num int) (core.Comic, error) {
 	var comic core.Comic
 
 	// Загружаем информацию о комиксе
-	resp, err := http.Get(fmt.Sprintf("%s/%d/info.0.json", c.baseURL, num))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%d/info.0.json", c.baseURL, num), nil)
+	if err != nil {
+		return comic, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return comic, err
 	}
@@ -44,7 +88,10 @@ func (c *Client) retrieveComic(num int) (core.Comic, error) {
 
 	// Проверяем наличие комикса по коду статуса HTTP
 	if resp.StatusCode == http.StatusNotFound {
-		return comic, fmt.Errorf("comic %d not found", num)
+		return comic, fmt.Errorf("comic %d: %w", num, ErrComicNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return comic, fmt.Errorf("comic %d: unexpected status %s", num, resp.Status)
 	}
 
 	// Читаем тело ответа
@@ -53,31 +100,55 @@ func (c *Client) retrieveComic(num int) (core.Comic, error) {
 		return comic, err
 	}
 
-	// Декодируем JSON
-	err = json.Unmarshal(body, &core.ComicInfo)
+	// Декодируем JSON в локальную структуру, чтобы поля не переходили между комиксами
+	var info core.ComicInfo
+	err = json.Unmarshal(body, &info)
 	if err != nil {
-		return comic, err
+		return comic, fmt.Errorf("comic %d: %w: %v", num, ErrDecode, err)
+	}
+	if info.Num == 0 {
+		info.Num = num
 	}
 
+	imageURL, imageErr := c.imageURL(info)
+
 	// Нормализуем ключевые слова
-	keywords := words.NormalizeWords(core.ComicInfo.Title, core.ComicInfo.Transcript, core.ComicInfo.Alt)
+	keywords := words.NormalizeWords(info.Title, info.Transcript, info.Alt)
 
 	// Преобразуем слайс ключевых слов в строку, разделенную запятыми
 	keywordsStr := strings.Join(keywords, ",")
 
-	// Заполняем структуру Comic
-	comic.URL = core.ComicInfo.Img
+	// Заполняем структуру Comic, сохраняя исходный текст для повторной нормализации
+	comic.ID = num
+	comic.URL = imageURL
 	comic.Keywords = keywordsStr
-
+	comic.Title = info.Title
+	comic.Alt = info.Alt
+	comic.Transcript = info.Transcript
+	comic.SafeTitle = info.SafeTitle
+	comic.Link = info.Link
+	comic.News = info.News
+	comic.Published = publishedDate(info)
+	comic.NormVersion = words.Version
+	comic.Terms = ComicTerms(comic)
+	comic.ContentHash = contentHash(comic)
+
+	if imageErr != nil {
+		return comic, fmt.Errorf("comic %d: %w", num, imageErr)
+	}
 	return comic, nil
 }
 
-func (c *Client) retrieveLatestComicNum() (int, error) {
-	return c.retrieveLatestComicNumFromAPI()
+func (c *Client) retrieveLatestComicNum(ctx context.Context) (int, error) {
+	return c.retrieveLatestComicNumFromAPI(ctx)
 }
 
-func (c *Client) retrieveLatestComicNumFromAPI() (int, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/info.0.json", c.baseURL))
+func (c *Client) retrieveLatestComicNumFromAPI(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/info.0.json", c.baseURL), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return 0, err
 	}
@@ -95,14 +166,74 @@ func (c *Client) retrieveLatestComicNumFromAPI() (int, error) {
 	return info.Num, nil
 }
 
-func (c *Client) RunWorkers(workers int) {
+// unverifiedComics возвращает номера комиксов, которые старые сборки сохранили под автоинкрементным id
+func (c *Client) unverifiedComics(ctx context.Context) map[int]bool {
+	ids, err := c.storage.GetUnverifiedComicIDs(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting unverified comics")
+		return nil
+	}
+	unverified := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		unverified[id] = true
+	}
+	return unverified
+}
+
+// verifyComic сверяет комикс, сохраненный под автоинкрементным id, с комиксом с тем же номером на xkcd.com.
+// Совпала картинка - номер подтверждается, иначе под номером записывается настоящий комикс,
+// а строка без комикса на xkcd.com удаляется. Возвращает true, если номер теперь подтвержден
+func (c *Client) verifyComic(ctx context.Context, stored core.Comic) bool {
+	comic, err := c.retrieveComic(ctx, stored.ID)
+	switch {
+	case errors.Is(err, ErrComicNotFound):
+		log.Warn().Msgf("deleting comic %d: no such comic on xkcd", stored.ID)
+		if err := c.storage.DeleteComic(ctx, stored.ID); err != nil {
+			log.Error().Err(err).Msgf("error deleting comic %d", stored.ID)
+		}
+		return false
+	case err != nil && !errors.Is(err, ErrNoImage):
+		log.Warn().Err(err).Msgf("error verifying comic %d", stored.ID)
+		return false
+	case comic.URL == stored.URL:
+		if err := c.storage.VerifyComic(ctx, stored.ID); err != nil {
+			log.Error().Err(err).Msgf("error verifying comic %d", stored.ID)
+			return false
+		}
+		return true
+	}
+
+	log.Warn().Msgf("comic %d was stored under a wrong number, replacing it", stored.ID)
+	if err := c.storage.RenumberComic(ctx, comic); err != nil {
+		log.Error().Err(err).Msgf("error renumbering comic %d", stored.ID)
+		return false
+	}
+	if comic.URL != "" {
+		c.mirrorImage(ctx, comic)
+	}
+	return true
+}
+
+type ingested struct {
+	comic  core.Comic
+	status core.IngestionStatus
+}
+
+func (c *Client) saveStatus(ctx context.Context, status core.IngestionStatus) {
+	if err := c.storage.SaveIngestionStatus(ctx, status); err != nil {
+		log.Error().Err(err).Msgf("error saving ingestion status of comic %d", status.Num)
+	}
+}
+
+func (c *Client) RunWorkers(ctx context.Context, workers int) {
 	var (
 		wg            sync.WaitGroup
-		comicsChannel = make(chan core.Comic)
+		comicsChannel = make(chan ingested)
 		doneChannel   = make(chan struct{})
 	)
 
-	latestComic, _ := c.retrieveLatestComicNum()
+	latestComic, _ := c.retrieveLatestComicNum(ctx)
+	unverified := c.unverifiedComics(ctx)
 
 	log.Info().Msg("Loading comics..")
 
@@ -112,14 +243,39 @@ func (c *Client) RunWorkers(workers int) {
 		defer wg.Done()
 
 		for i := 1; i <= latestComic; i++ {
-			_, err := c.storage.GetComicByID(i)
-			if err != nil {
-				comic, err := c.retrieveComic(i)
-				if err != nil {
-					continue
+			// вызывающий ушел - дальше не загружаем
+			if ctx.Err() != nil {
+				log.Warn().Err(ctx.Err()).Msgf("loading interrupted at comic %d", i)
+				break
+			}
+
+			stored, err := c.storage.GetComicByID(ctx, i)
+			if err == nil {
+				if unverified[i] {
+					c.verifyComic(ctx, stored)
 				}
-				comicsChannel <- comic
+				continue
 			}
+
+			// пропускаем номера, для которых еще не подошло время повторной попытки
+			now := time.Now()
+			prev, err := c.storage.GetIngestionStatus(ctx, i)
+			if err == nil && !shouldRetry(prev, now) {
+				continue
+			}
+
+			comic, err := c.retrieveComic(ctx, i)
+			if ctx.Err() != nil {
+				// отмена запроса - не ошибка загрузки, статус не сохраняем
+				continue
+			}
+			status := nextStatus(i, prev, err, now)
+			if err != nil && !errors.Is(err, ErrNoImage) {
+				log.Warn().Err(err).Msgf("skipping comic %d", i)
+				c.saveStatus(ctx, status)
+				continue
+			}
+			comicsChannel <- ingested{comic: comic, status: status}
 		}
 
 		close(comicsChannel)
@@ -131,10 +287,15 @@ func (c *Client) RunWorkers(workers int) {
 		go func() {
 			defer wg.Done()
 
-			for comic := range comicsChannel {
-				if err := c.storage.SaveComicToDatabase(comic); err != nil {
+			for item := range comicsChannel {
+				if err := c.storage.SaveComicToDatabase(ctx, item.comic); err != nil {
+					log.Error().Err(err).Msgf("error saving comic %d", item.comic.ID)
 					continue
 				}
+				c.saveStatus(ctx, item.status)
+				if item.status.Status == core.StatusOK {
+					c.mirrorImage(ctx, item.comic)
+				}
 			}
 		}()
 	}
@@ -148,3 +309,130 @@ func (c *Client) RunWorkers(workers int) {
 
 	log.Info().Msg("Finished loading.")
 }
+
+// ResyncComics повторно загружает уже сохраненные комиксы и сохраняет новую ревизию
+// для каждого, у которого изменилось содержимое
+func (c *Client) ResyncComics(ctx context.Context, workers int) core.ResyncCount {
+	type revision struct {
+		comic   core.Comic
+		diff    string
+		noImage bool
+	}
+
+	var (
+		wg              sync.WaitGroup
+		count           core.ResyncCount
+		changed         atomic.Int64
+		revisionChannel = make(chan revision)
+	)
+
+	latestComic, _ := c.retrieveLatestComicNum(ctx)
+	unverified := c.unverifiedComics(ctx)
+
+	log.Info().Msg("Re-syncing comics..")
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 1; i <= latestComic; i++ {
+			if ctx.Err() != nil {
+				log.Warn().Err(ctx.Err()).Msgf("re-sync interrupted at comic %d", i)
+				break
+			}
+
+			stored, err := c.storage.GetComicByID(ctx, i)
+			if err != nil {
+				continue
+			}
+			// чужое содержимое под номером - не правка на xkcd.com, ревизию не сохраняем
+			if unverified[i] {
+				if c.verifyComic(ctx, stored) {
+					count.CheckedComics++
+				}
+				continue
+			}
+			// исправленные вручную комиксы обновляются только через refetch
+			if !stored.EditedAt.IsZero() {
+				continue
+			}
+			comic, err := c.retrieveComic(ctx, i)
+			if err != nil && !errors.Is(err, ErrNoImage) {
+				continue
+			}
+			count.CheckedComics++
+
+			if comic.ContentHash == stored.ContentHash {
+				continue
+			}
+			// комиксы, сохраненные до появления хэша, сравнивать не с чем: хэш заполняется без ревизии
+			if stored.ContentHash == "" {
+				comic.Hidden = stored.Hidden
+				if err := c.storage.UpsertComic(ctx, comic); err != nil {
+					log.Error().Err(err).Msgf("error backfilling content hash of comic %d", i)
+				}
+				continue
+			}
+			comic.ID = stored.ID
+			revisionChannel <- revision{comic: comic, diff: comicDiff(stored, comic), noImage: err != nil}
+		}
+
+		close(revisionChannel)
+	}()
+
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for rev := range revisionChannel {
+				if err := c.storage.SaveComicRevision(ctx, rev.comic, rev.diff); err != nil {
+					log.Error().Err(err).Msgf("error saving revision of comic %d", rev.comic.ID)
+					continue
+				}
+				changed.Add(1)
+				if !rev.noImage {
+					c.mirrorImage(ctx, rev.comic)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	count.ChangedComics = int(changed.Load())
+
+	log.Info().Msgf("Finished re-syncing. %d of %d comics changed.", count.ChangedComics, count.CheckedComics)
+	return count
+}
+
+// RefetchComic заново загружает комикс и сохраняет его как новую ревизию,
+// отменяя ручные правки. Скрытый комикс остается скрытым
+func (c *Client) RefetchComic(ctx context.Context, num int) (core.Comic, error) {
+	comic, err := c.retrieveComic(ctx, num)
+	if err != nil && !errors.Is(err, ErrNoImage) {
+		return core.Comic{}, err
+	}
+	noImage := err != nil
+
+	stored, err := c.storage.GetComicByID(ctx, num)
+	switch {
+	case err != nil:
+		if err := c.storage.SaveComicToDatabase(ctx, comic); err != nil {
+			return core.Comic{}, err
+		}
+	case stored.ContentHash != comic.ContentHash || !stored.EditedAt.IsZero():
+		if err := c.storage.SaveComicRevision(ctx, comic, comicDiff(stored, comic)); err != nil {
+			return core.Comic{}, err
+		}
+		comic.Hidden = stored.Hidden
+	default:
+		return stored, nil
+	}
+
+	if !noImage {
+		c.mirrorImage(ctx, comic)
+	}
+	return comic, nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -44,7 +45,7 @@ func TestRetrieveComic(t *testing.T) {
 
     client.baseURL = server.URL
 
-    comic, err := client.retrieveComic(1)
+    comic, err := client.retrieveComic(context.Background(), 1)
     assert.NoError(t, err)
     assert.Equal(t, "http://example.com/image.png", comic.URL)
     assert.Equal(t, "test,comic,appl,doctor", comic.Keywords)
@@ -73,17 +74,18 @@ func TestRetrieveLatestComicNum(t *testing.T) {
 
     client.baseURL = server.URL
 
-    num, err := client.retrieveLatestComicNum()
+    num, err := client.retrieveLatestComicNum(context.Background())
     assert.NoError(t, err)
     assert.Equal(t, 123, num)
 }
 
 type FakeStorage struct {
-    comics map[int]core.Comic
-    mu     sync.Mutex
+    comics     map[int]core.Comic
+    unverified map[int]bool
+    mu         sync.Mutex
 }
 
-func (s *FakeStorage) GetComicByID(id int) (core.Comic, error) {
+func (s *FakeStorage) GetComicByID(ctx context.Context, id int) (core.Comic, error) {
     s.mu.Lock()
     defer s.mu.Unlock()
     if comic, ok := s.comics[id]; ok {
@@ -92,13 +94,68 @@ func (s *FakeStorage) GetComicByID(id int) (core.Comic, error) {
     return core.Comic{}, errors.New("Comic not found")
 }
 
-func (s *FakeStorage) SaveComicToDatabase(comic core.Comic) error {
+func (s *FakeStorage) SaveComicToDatabase(ctx context.Context, comic core.Comic) error {
     s.mu.Lock()
     defer s.mu.Unlock()
     s.comics[comic.ID] = comic
     return nil
 }
 
+func (s *FakeStorage) SaveComicRevision(ctx context.Context, comic core.Comic, diff string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.comics[comic.ID] = comic
+    return nil
+}
+
+func (s *FakeStorage) UpsertComic(ctx context.Context, comic core.Comic) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.comics[comic.ID] = comic
+    return nil
+}
+
+func (s *FakeStorage) GetIngestionStatus(ctx context.Context, num int) (core.IngestionStatus, error) {
+    return core.IngestionStatus{}, errors.New("no status")
+}
+
+func (s *FakeStorage) SaveIngestionStatus(ctx context.Context, status core.IngestionStatus) error {
+    return nil
+}
+
+func (s *FakeStorage) GetUnverifiedComicIDs(ctx context.Context) ([]int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var ids []int
+    for id := range s.unverified {
+        ids = append(ids, id)
+    }
+    return ids, nil
+}
+
+func (s *FakeStorage) VerifyComic(ctx context.Context, id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.unverified, id)
+    return nil
+}
+
+func (s *FakeStorage) RenumberComic(ctx context.Context, comic core.Comic) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.comics[comic.ID] = comic
+    delete(s.unverified, comic.ID)
+    return nil
+}
+
+func (s *FakeStorage) DeleteComic(ctx context.Context, id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.comics, id)
+    delete(s.unverified, id)
+    return nil
+}
+
 func TestRunWorkers(t *testing.T) {
     fakeDB := &FakeStorage{comics: make(map[int]core.Comic)}
     client := NewClient("https://xkcd.com", fakeDB)
@@ -109,7 +166,7 @@ func TestRunWorkers(t *testing.T) {
     defer cancel()
 
     go func() {
-        client.RunWorkers(5)
+        client.RunWorkers(ctx, 5)
         close(done)
     }()
 
@@ -120,7 +177,131 @@ func TestRunWorkers(t *testing.T) {
     }
 
     for i := 1; i <= 1; i++ {
-        comic, _ := fakeDB.GetComicByID(i)
+        comic, _ := fakeDB.GetComicByID(context.Background(), i)
         assert.NotNil(t, comic)
     }
-}
\ No newline at end of file
+}
+
+func TestRunWorkersCancelled(t *testing.T) {
+    var requests atomic.Int64
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests.Add(1)
+        w.Write([]byte(`{"num": 3, "img": "https://imgs.xkcd.com/comics/test.png"}`))
+    }))
+    defer server.Close()
+
+    fakeDB := &FakeStorage{comics: make(map[int]core.Comic)}
+    client := NewClient(server.URL, fakeDB)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    client.RunWorkers(ctx, 2)
+
+    assert.Empty(t, fakeDB.comics)
+    assert.Zero(t, requests.Load())
+}
+
+func TestRunWorkersRenumbersLegacyComics(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/info.0.json":
+            _, _ = w.Write([]byte(`{"num": 5}`))
+        case "/1/info.0.json", "/2/info.0.json", "/3/info.0.json", "/5/info.0.json":
+            num := r.URL.Path[1:2]
+            _, _ = w.Write([]byte(`{"num": ` + num + `, "title": "Comic ` + num + `", "img": "http://example.com/` + num + `.png"}`))
+        default:
+            w.WriteHeader(http.StatusNotFound)
+        }
+    }))
+    defer server.Close()
+
+    // старая сборка пропустила несуществующий 4 и сохранила 5 под id 4, а 3 - под id 2
+    fakeDB := &FakeStorage{
+        comics: map[int]core.Comic{
+            1: {ID: 1, URL: "http://example.com/1.png"},
+            2: {ID: 2, URL: "http://example.com/3.png"},
+            4: {ID: 4, URL: "http://example.com/5.png"},
+        },
+        unverified: map[int]bool{1: true, 2: true, 4: true},
+    }
+    client := NewClient(server.URL, fakeDB)
+
+    client.RunWorkers(context.Background(), 2)
+
+    assert.Empty(t, fakeDB.unverified)
+    urls := map[int]string{}
+    for id, comic := range fakeDB.comics {
+        assert.Equal(t, id, comic.ID)
+        urls[id] = comic.URL
+    }
+    assert.Equal(t, map[int]string{
+        1: "http://example.com/1.png",
+        2: "http://example.com/2.png",
+        3: "http://example.com/3.png",
+        5: "http://example.com/5.png",
+    }, urls)
+    assert.Equal(t, "Comic 2", fakeDB.comics[2].Title)
+}
+
+func TestResyncComics(t *testing.T) {
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/info.0.json":
+            _, _ = w.Write([]byte(`{"num": 3}`))
+        case "/1/info.0.json":
+            _, _ = w.Write([]byte(`{"title": "Same", "alt": "alt", "img": "http://example.com/1.png"}`))
+        case "/2/info.0.json":
+            _, _ = w.Write([]byte(`{"title": "Edited", "alt": "fixed typo", "img": "http://example.com/2.png"}`))
+        case "/3/info.0.json":
+            _, _ = w.Write([]byte(`{"title": "Legacy", "img": "http://example.com/3.png"}`))
+        default:
+            w.WriteHeader(http.StatusNotFound)
+        }
+    }))
+    defer server.Close()
+
+    same := core.Comic{ID: 1, URL: "http://example.com/1.png", Title: "Same", Alt: "alt"}
+    same.ContentHash = contentHash(same)
+    edited := core.Comic{ID: 2, URL: "http://example.com/2.png", Title: "Edited", Alt: "fixed tpyo"}
+    edited.ContentHash = contentHash(edited)
+
+    mockStorage := mocks.NewMockStorage(ctrl)
+    mockStorage.EXPECT().GetUnverifiedComicIDs(gomock.Any()).Return(nil, nil)
+    mockStorage.EXPECT().GetComicByID(gomock.Any(), 1).Return(same, nil)
+    mockStorage.EXPECT().GetComicByID(gomock.Any(), 2).Return(edited, nil)
+    // у старой строки нет хэша - он заполняется без ревизии
+    mockStorage.EXPECT().GetComicByID(gomock.Any(), 3).Return(core.Comic{ID: 3, URL: "http://example.com/3.png", Hidden: true}, nil)
+    mockStorage.EXPECT().UpsertComic(gomock.Any(), gomock.Any()).
+        DoAndReturn(func(_ context.Context, comic core.Comic) error {
+            assert.Equal(t, 3, comic.ID)
+            assert.Equal(t, "Legacy", comic.Title)
+            assert.Equal(t, contentHash(comic), comic.ContentHash)
+            assert.True(t, comic.Hidden)
+            return nil
+        })
+    mockStorage.EXPECT().SaveComicRevision(gomock.Any(), gomock.Any(), "--- alt\n+++ alt\n-fixed tpyo\n+fixed typo\n").
+        DoAndReturn(func(_ context.Context, comic core.Comic, diff string) error {
+            assert.Equal(t, 2, comic.ID)
+            assert.Equal(t, "fixed typo", comic.Alt)
+            assert.NotEqual(t, edited.ContentHash, comic.ContentHash)
+            return nil
+        })
+
+    client := NewClient(server.URL, mockStorage)
+
+    count := client.ResyncComics(context.Background(), 2)
+    assert.Equal(t, core.ResyncCount{CheckedComics: 3, ChangedComics: 1}, count)
+}
+
+func TestDiffLines(t *testing.T) {
+    old := "line one\nline two\nline three"
+    new := "line one\nline 2\nline three\nline four"
+
+    assert.Equal(t, []string{"-line two", "+line 2", "+line four"}, diffLines(old, new))
+    assert.Empty(t, diffLines(old, old))
+    assert.Equal(t, []string{"+added"}, diffLines("", "added"))
+}